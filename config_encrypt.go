@@ -0,0 +1,63 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+// configEncrypt encrypts a value read from stdin, or passed with -value, for use as a secret in the configuration.
+// The value is sealed with the secret of config.ValueSecret.
+func configEncrypt(args []string, logger *log.Logger) int {
+	fs := flag.NewFlagSet("config encrypt", flag.ExitOnError)
+	value := fs.String("value", "", "value to encrypt, instead of reading it from stdin")
+	_ = fs.Parse(args)
+
+	secret, err := config.ValueSecret()
+	if err != nil {
+		logger.Println(err)
+		return exitConfig
+	}
+	v := *value
+	if v == "" {
+		if interactive() {
+			fmt.Fprint(os.Stderr, "Value to encrypt: ")
+		}
+		v, err = readSecret(bufio.NewReader(os.Stdin))
+		if interactive() {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err != nil {
+			logger.Printf("couldn't read the value: %v", err)
+			return exitFailure
+		}
+	}
+	sealed, err := crypt.SealValue(secret, v)
+	if err != nil {
+		logger.Println(err)
+		return exitFailure
+	}
+	fmt.Println(sealed)
+	return exitOK
+}
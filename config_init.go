@@ -0,0 +1,203 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// starterConfig is the configuration config init writes, clientconfig.yaml.example documents all options.
+var starterConfig = template.Must(template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(
+	`# Written by mediasync-client config init, see clientconfig.yaml.example for all options.
+
+# The base URL of the mediasync server.
+remote: {{quote .Remote}}
+username: {{quote .UserName}}
+{{- if .Password}}
+password: {{quote .Password}}
+{{- else}}
+# Set the password here, with password_file, MEDIASYNC_PASSWORD or in the keyring with keyring: true.
+# password: ""
+{{- end}}
+
+# Where the files of remote_path end up locally, add a mapping per directory of the server.
+root_mapping:
+  - remote_path: {{quote .RemotePath}}
+    local_path: {{quote .LocalPath}}
+
+# The bot that sends a report after every run, and the chat it sends them to.
+telegram:
+  token: {{quote .TelegramToken}}
+  chat_id: {{.ChatID}}
+
+# Keep running and sync every interval, instead of syncing once.
+# interval: 1h
+`))
+
+// starter holds the values of the starter configuration.
+type starter struct {
+	Remote        string
+	UserName      string
+	Password      string
+	RemotePath    string
+	LocalPath     string
+	TelegramToken string
+	ChatID        int64
+}
+
+// configCommand runs the config subcommands.
+func configCommand(args []string, logger *log.Logger) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "init":
+			return configInit(args[1:], logger)
+		case "encrypt":
+			return configEncrypt(args[1:], logger)
+		}
+	}
+	logger.Println("usage: mediasync-client config init|encrypt [flags]")
+	return exitConfig
+}
+
+// configInit writes a starter configuration with the values of the flags, and asks for the missing ones if stdin
+// is a terminal.
+func configInit(args []string, logger *log.Logger) int {
+	fs := flag.NewFlagSet("config init", flag.ExitOnError)
+	output := fs.String("output", filepath.Join(config.ConfigDir(), config.ConfigName+".yaml"),
+		"file to write the configuration to")
+	force := fs.Bool("force", false, "overwrite an existing configuration")
+	var s starter
+	fs.StringVar(&s.Remote, "remote", "", "base URL of the mediasync server")
+	fs.StringVar(&s.UserName, "username", "", "user name for the server")
+	fs.StringVar(&s.RemotePath, "remote-path", "", "directory of the server to synchronise")
+	fs.StringVar(&s.LocalPath, "local-path", "", "local directory to synchronise it to")
+	fs.StringVar(&s.TelegramToken, "telegram-token", "", "token of the Telegram bot that sends the reports")
+	fs.Int64Var(&s.ChatID, "telegram-chat-id", 0, "ID of the Telegram chat the reports are sent to")
+	_ = fs.Parse(args)
+
+	p := config.ExpandHome(*output)
+	if _, err := os.Stat(p); err == nil && !*force {
+		logger.Printf("%s already exists, use -force to overwrite it", p)
+		return exitConfig
+	}
+	if interactive() {
+		if err := s.ask(bufio.NewReader(os.Stdin)); err != nil {
+			logger.Println(err)
+			return exitFailure
+		}
+	}
+	if err := s.write(p); err != nil {
+		logger.Println(err)
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", p)
+	return exitOK
+}
+
+// interactive tells whether stdin is a terminal.
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ask asks for the values that weren't passed as flags, until stdin ends.
+func (s *starter) ask(in *bufio.Reader) error {
+	questions := []struct {
+		prompt string
+		value  *string
+		secret bool
+	}{
+		{"URL of the mediasync server", &s.Remote, false},
+		{"User name", &s.UserName, false},
+		{"Password (empty to set it elsewhere)", &s.Password, true},
+		{"Directory of the server to synchronise", &s.RemotePath, false},
+		{"Local directory to synchronise it to", &s.LocalPath, false},
+		{"Telegram bot token", &s.TelegramToken, true},
+	}
+	for _, q := range questions {
+		if *q.value != "" {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: ", q.prompt)
+		var err error
+		if q.secret {
+			*q.value, err = readSecret(in)
+			fmt.Fprintln(os.Stderr)
+		} else {
+			*q.value, err = readLine(in)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for s.ChatID == 0 {
+		fmt.Fprint(os.Stderr, "Telegram chat ID: ")
+		line, err := readLine(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.ChatID, err = strconv.ParseInt(line, 10, 64); err != nil {
+			fmt.Fprintln(os.Stderr, "that isn't a chat ID, those are numbers")
+		}
+	}
+	return nil
+}
+
+// write writes the configuration to p, which is only readable by the user as it may hold secrets.
+func (s *starter) write(p string) error {
+	if s.Remote == "" || s.RemotePath == "" || s.LocalPath == "" {
+		return errors.New("the configuration needs at least -remote, -remote-path and -local-path")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := starterConfig.Execute(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
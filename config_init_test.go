@@ -0,0 +1,96 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/spf13/viper"
+)
+
+func TestConfigInit(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, config.ConfigName+".yaml")
+	logger := log.New(ioutil.Discard, "", 0)
+	args := []string{
+		"-output", p, "-remote", "https://file.example.org", "-username", "user", "-remote-path", "/tv",
+		"-local-path", filepath.Join(dir, "tv"), "-telegram-token", "123:secret", "-telegram-chat-id", "42",
+	}
+	if code := configInit(args, logger); code != exitOK {
+		t.Fatalf("config init exited with %d", code)
+	}
+	if fi, err := os.Stat(p); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("configuration isn't only readable by the user: %v", err)
+	}
+	if code := configInit(args, logger); code != exitConfig {
+		t.Errorf("config init overwrote the configuration, exited with %d", code)
+	}
+
+	// The starter configuration is read like any other.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+	defer func() { _ = os.Unsetenv("XDG_CONFIG_HOME") }()
+	defer viper.Reset()
+
+	c, err := config.GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("starter configuration is invalid: %v", err)
+	}
+	if c.Remote != "https://file.example.org" || c.UserName != "user" || c.Telegram.ChatID != 42 ||
+		len(c.RootMapping) != 1 || c.RootMapping[0].RemotePath != "/tv" {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+}
+
+func TestStarterAsk(t *testing.T) {
+	s := starter{Remote: "https://file.example.org"}
+	in := "user\n\n/tv\n/media/tv\n123:secret\nchat\n42\n"
+	if err := s.ask(bufio.NewReader(strings.NewReader(in))); err != nil {
+		t.Fatal(err)
+	}
+	want := starter{Remote: "https://file.example.org", UserName: "user", RemotePath: "/tv", LocalPath: "/media/tv",
+		TelegramToken: "123:secret", ChatID: 42}
+	if s != want {
+		t.Errorf("answers gave %+v, want %+v", s, want)
+	}
+
+	// Input that ends early leaves the rest unset.
+	s = starter{}
+	if err := s.ask(bufio.NewReader(strings.NewReader("https://file.example.org\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if s.Remote != "https://file.example.org" || s.UserName != "" {
+		t.Errorf("short input gave %+v", s)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+)
+
+// daemon keeps syncing until ctx is done, the exit codes of the separate runs don't matter. SIGHUP reloads the
+// configuration between runs and starts a run with it, a new configuration that is invalid is rejected and the
+// current one is kept.
+func daemon(ctx context.Context, cl *client, m engine.Mode, logger *log.Logger) int {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	announced, stopWatching := startWatching(ctx, cl, logger)
+	defer func() { stopWatching() }()
+	for {
+		syncOnce(ctx, cl, logger)
+		var interval <-chan time.Time
+		if cl.c.Interval > 0 {
+			interval = time.After(cl.c.Interval)
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return exitInterrupted
+			case <-interval:
+				waiting = false
+			case f := <-announced:
+				logger.Printf("remote announced %s", f)
+				waiting = false
+			case <-hup:
+				old, err := swap(cl, m, logger)
+				if err != nil {
+					logger.Printf("keeping the current configuration: %v", err)
+					continue
+				}
+				stopWatching()
+				old.close()
+				announced, stopWatching = startWatching(ctx, cl, logger)
+				logger.Println("reloaded the configuration")
+				waiting = false
+			}
+		}
+	}
+}
+
+// startWatching watches for the files the remotes of cl announce, until the returned function is called.
+func startWatching(ctx context.Context, cl *client, logger *log.Logger) (<-chan string, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	return watch(ctx, cl.c, cl.engines, logger), cancel
+}
+
+// swap replaces cl in place with a client reloaded from the current configuration and returns the client it
+// replaced, which the caller has to close. cl is left alone if the new configuration is rejected.
+func swap(cl *client, m engine.Mode, logger *log.Logger) (*client, error) {
+	next, err := reload(m, logger)
+	if err != nil {
+		return nil, err
+	}
+	old := *cl
+	*cl = *next
+	return &old, nil
+}
+
+// reload sets up a client from the current configuration, which has to keep the client running.
+func reload(m engine.Mode, logger *log.Logger) (*client, error) {
+	cl, err := setup(m, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cl.c.Interval <= 0 && !cl.c.Watch {
+		cl.close()
+		return nil, errors.New("the new configuration has neither an interval nor watch")
+	}
+	return cl, nil
+}
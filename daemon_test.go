@@ -0,0 +1,108 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+	"github.com/spf13/viper"
+)
+
+// fakeTelegram answers the requests to the Telegram API, which are made as soon as a reporter is set up. The
+// reporter clones the default transport, its TLS connections are dialed to a plain HTTP server instead.
+func fakeTelegram(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"ok": true, "result": {"id": 1, "is_bot": true, "username": "mediasync_bot"}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	orig := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = orig })
+	http.DefaultTransport = &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+}
+
+func TestSwap(t *testing.T) {
+	fakeTelegram(t)
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+	defer func() { _ = os.Unsetenv("XDG_CONFIG_HOME") }()
+	defer viper.Reset()
+
+	writeConfig := func(extra string) {
+		t.Helper()
+		yaml := fmt.Sprintf("remote: http://127.0.0.1:1\ntelegram:\n  token: 1:secret\n  chat_id: 1\n"+
+			"root_mapping:\n  - remote_path: /tv\n    local_path: %s\n%s", dir, extra)
+		if err := ioutil.WriteFile(filepath.Join(dir, config.ConfigName+".yaml"), []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	writeConfig("interval: 1h\n")
+	cl, err := setup(engine.ModeSync, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.close()
+	current := cl.c
+
+	// Without an interval or watch the daemon would never run again, so the configuration is rejected.
+	writeConfig("")
+	if _, err := swap(cl, engine.ModeSync, logger); err == nil {
+		t.Error("a configuration without interval or watch was accepted")
+	}
+	if cl.c != current {
+		t.Error("the client was replaced by a rejected configuration")
+	}
+
+	writeConfig("interval: 2h\n")
+	old, err := swap(cl, engine.ModeSync, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.close()
+	if old.c != current {
+		t.Error("the replaced client isn't returned")
+	}
+	if cl.c == current || cl.c.Interval.String() != "2h0m0s" || len(cl.engines) != 1 {
+		t.Errorf("the client wasn't replaced: %+v", cl.c)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+// decrypt decrypts files that were encrypted by the engine, next to the encrypted files.
+func decrypt(args []string, logger *log.Logger) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := fs.String("key", "", "file with the key, defaults to encryption_key of the configuration")
+	stdout := fs.Bool("stdout", false, "write the decrypted data to stdout instead of next to the files")
+	remove := fs.Bool("remove", false, "remove the encrypted files after decrypting them")
+	_ = fs.Parse(args)
+
+	if *keyFile == "" {
+		c, err := config.GetConfig()
+		if err != nil {
+			logger.Printf("Can't get configuration: %s", err)
+			return exitConfig
+		}
+		*keyFile = c.EncryptionKey
+	}
+	if *keyFile == "" {
+		logger.Println("no key configured, use -key to pass one")
+		return exitConfig
+	}
+	key, err := crypt.LoadKey(*keyFile)
+	if err != nil {
+		logger.Println(err)
+		return exitConfig
+	}
+
+	code := exitOK
+	for _, p := range fs.Args() {
+		if err := decryptFile(key, p, *stdout, *remove); err != nil {
+			logger.Println(err)
+			code = exitFilesFailed
+		}
+	}
+	return code
+}
+
+// decryptFile decrypts p to its name without crypt.Ext, or to stdout.
+func decryptFile(key []byte, p string, stdout, remove bool) error {
+	in, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := crypt.NewReader(in, key)
+	if err != nil {
+		return fmt.Errorf("couldn't decrypt %s: %w", p, err)
+	}
+
+	if stdout {
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return fmt.Errorf("couldn't decrypt %s: %w", p, err)
+		}
+		return nil
+	}
+
+	dst := strings.TrimSuffix(p, crypt.Ext)
+	if dst == p {
+		return errors.New(p + " doesn't end in " + crypt.Ext)
+	}
+	// The decrypted data only gets its final name once all of it is verified.
+	tmp := dst + ".decrypting"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("couldn't decrypt %s: %w", p, err)
+	}
+
+	if remove {
+		return os.Remove(p)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/keyring"
+)
+
+// login asks for the passwords of the remotes and the Telegram token, and stores them in the keyring of the OS,
+// for configurations with keyring set.
+func login(args []string, logger *log.Logger) int {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	skipTelegram := fs.Bool("skip-telegram", false, "don't ask for the Telegram token")
+	_ = fs.Parse(args)
+
+	c, err := config.GetConfig()
+	if err != nil {
+		logger.Printf("Can't get configuration: %s", err)
+		return exitConfig
+	}
+	cs, err := c.Split()
+	if err != nil {
+		logger.Printf("Invalid remotes: %s", err)
+		return exitConfig
+	}
+	if !c.Keyring {
+		logger.Println("keyring isn't set in the configuration, the stored secrets won't be used")
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	seen := make(map[string]bool)
+	for _, rc := range cs {
+		if !rc.UsesPassword() || seen[rc.PasswordAccount()] {
+			continue
+		}
+		seen[rc.PasswordAccount()] = true
+		if err := store(in, rc.PasswordAccount(), "Password for "+rc.PasswordAccount()); err != nil {
+			logger.Println(err)
+			return exitFailure
+		}
+	}
+	if !*skipTelegram {
+		if err := store(in, config.TelegramAccount, "Telegram bot token"); err != nil {
+			logger.Println(err)
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// store asks for the secret of account and stores it, an empty answer keeps the current secret.
+func store(in *bufio.Reader, account, prompt string) error {
+	fmt.Fprintf(os.Stderr, "%s (empty to keep): ", prompt)
+	secret, err := readSecret(in)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("couldn't read the secret for %s: %w", account, err)
+	}
+	if secret == "" {
+		return nil
+	}
+	if err := keyring.Set(account, secret); err != nil {
+		return fmt.Errorf("couldn't store the secret for %s: %w", account, err)
+	}
+	return nil
+}
+
+// readSecret reads a line from in, with echo turned off if stdin is a terminal that stty can control.
+func readSecret(in *bufio.Reader) (string, error) {
+	if stty("-echo") == nil {
+		defer func() { _ = stty("echo") }()
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
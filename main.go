@@ -17,232 +17,445 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
-	"crypto/rand"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
+	"net/http/pprof"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+	"github.com/ainmosni/mediasync-client/pkg/filter"
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+	"github.com/ainmosni/mediasync-client/pkg/mapping"
+	"github.com/ainmosni/mediasync-client/pkg/progress"
 	"github.com/ainmosni/mediasync-client/pkg/report"
+	"github.com/ainmosni/mediasync-client/pkg/version"
 	"github.com/nightlyone/lockfile"
+	"github.com/spf13/pflag"
 )
 
 const (
 	lockFile = "/tmp/mediasync.lock"
 
-	postfixLen = 8
+	reportTimeout = 30 * time.Second
 )
 
-type wp struct {
-	WebPath string `json:"web_path"`
-}
+// Exit codes, so that wrapper scripts can tell what went wrong.
+const (
+	exitOK = iota
+	exitFailure
+	exitConfig
+	exitAuth
+	exitRemoteUnavailable
+	exitDiskFull
+	exitFilesFailed
+	exitInterrupted
+)
 
-func randomString(n int) (string, error) {
-	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, engine.ErrAuth):
+		return exitAuth
+	case errors.Is(err, engine.ErrRemoteUnavailable), errors.Is(err, engine.ErrRateLimited):
+		return exitRemoteUnavailable
+	case errors.Is(err, engine.ErrDiskFull):
+		return exitDiskFull
+	default:
+		return exitFailure
 	}
-	return fmt.Sprintf("%X", b), nil
 }
 
-func createURL(c *config.Configuration, rPath string) (*url.URL, error) {
-	u, err := url.Parse(c.Remote)
-	if err != nil {
-		return nil, err
-	}
-	u.Path = path.Join(u.Path, rPath)
-	return u, nil
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM. The handler is removed after the
+// first signal, so a second one kills the process right away.
+func signalContext(logger *log.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sigs:
+			logger.Printf("received %s, stopping", s)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
 }
 
-func reqWithAuth(method, url string, c *config.Configuration) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, nil)
+// runContext returns a context derived from parent that expires at the configured deadline, if any.
+func runContext(parent context.Context, c *config.Configuration) (context.Context, context.CancelFunc, error) {
+	if c.Deadline == "" {
+		ctx, cancel := context.WithCancel(parent)
+		return ctx, cancel, nil
+	}
+
+	t, err := time.Parse("15:04", c.Deadline)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("invalid deadline %q: %w", c.Deadline, err)
 	}
 
-	req.SetBasicAuth(c.UserName, c.Password)
+	now := time.Now()
+	deadline := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !deadline.After(now) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
 
-	return http.DefaultClient.Do(req)
+	ctx, cancel := context.WithDeadline(parent, deadline)
+	return ctx, cancel, nil
 }
 
-func getFiles(c *config.Configuration) ([]wp, error) {
-	fileInfo, err := createURL(c, "/fileinfo")
-	if err != nil {
-		return []wp{}, fmt.Errorf("can't parse remote: %w", err)
-	}
+var (
+	pprofAddr    = pflag.String("pprof", "", "serve pprof endpoints on this address, e.g. localhost:6060")
+	showProgress = pflag.Bool("progress", false, "show the progress of downloads on stderr")
+	showVersion  = pflag.Bool("version", false, "print the version and exit")
+	mode         = pflag.String("mode", string(engine.ModeSync),
+		"sync to download and then upload the files of push mappings, upload to only upload")
+)
 
-	resp, err := reqWithAuth("GET", fileInfo.String(), c)
-	if err != nil {
-		return []wp{}, fmt.Errorf("failed to get fileinfo: %w", err)
+// servePprof serves the pprof endpoints on addr, they are deliberately not registered on the default mux.
+func servePprof(addr string, logger *log.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		logger.Printf("pprof server stopped: %v", http.ListenAndServe(addr, mux))
+	}()
+}
+
+func main() {
+	config.RegisterFlags(pflag.CommandLine)
+	// The arguments after a subcommand are its own.
+	pflag.CommandLine.SetInterspersed(false)
+	pflag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
 	}
+	switch pflag.Arg(0) {
+	case "simulate":
+		os.Exit(simulate(pflag.Args()[1:], log.New(os.Stderr, "", log.LstdFlags)))
+	case "decrypt":
+		os.Exit(decrypt(pflag.Args()[1:], log.New(os.Stderr, "", log.LstdFlags)))
+	case "login":
+		os.Exit(login(pflag.Args()[1:], log.New(os.Stderr, "", log.LstdFlags)))
+	case "config":
+		os.Exit(configCommand(pflag.Args()[1:], log.New(os.Stderr, "", log.LstdFlags)))
+	}
+	os.Exit(run())
+}
 
-	defer resp.Body.Close()
+func run() int {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
 
-	buf := bytes.NewBuffer([]byte{})
-	_, err = io.Copy(buf, resp.Body)
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr, logger)
+	}
 
+	lock, err := lockfile.New(lockFile)
 	if err != nil {
-		return []wp{}, fmt.Errorf("failed to copy: %w", err)
+		panic(err)
 	}
 
-	var files []wp
-	err = json.Unmarshal(buf.Bytes(), &files)
-	if err != nil {
-		return []wp{}, fmt.Errorf("couldn't parse json: %w", err)
+	if err := lock.TryLock(); err != nil {
+		panic(fmt.Sprintf("Can't lock %q, reason %v", lock, err))
 	}
-	return files, nil
-}
 
-func delFile(u fmt.Stringer, c *config.Configuration) error {
-	delResp, err := reqWithAuth("DELETE", u.String(), c)
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logger.Printf("Can't unlock %q, reason %v", lock, err)
+		}
+	}()
+
+	m, err := engine.ParseMode(*mode)
 	if err != nil {
-		return fmt.Errorf("failed to delete %s: %w", u.String(), err)
+		logger.Println(err)
+		return exitConfig
 	}
-	defer delResp.Body.Close()
+	cl, err := setup(m, logger)
+	if err != nil {
+		logger.Println(err)
+		return exitConfig
+	}
+	// The daemon replaces the client when it reloads the configuration.
+	defer func() { cl.close() }()
 
-	return nil
-}
+	ctx, cancel := signalContext(logger)
+	defer cancel()
 
-func findLocal(f string, c *config.Configuration) string {
-	localFile := ""
-	for _, p := range c.RootMapping {
-		if strings.HasPrefix(f, p.RemotePath) {
-			localFile = strings.ReplaceAll(f, p.RemotePath, p.LocalPath)
-		}
+	if cl.c.DryRun {
+		return dryRun(ctx, cl.engines, logger)
+	}
+	if cl.c.Interval <= 0 && !cl.c.Watch {
+		return syncOnce(ctx, cl, logger)
 	}
-	return localFile
+	return daemon(ctx, cl, m, logger)
 }
 
-func downloadFile(remote, local string, c *config.Configuration) error {
-	dir, fName := filepath.Split(local)
-	err := os.MkdirAll(dir, 0775)
+// client is everything that is set up from the configuration.
+type client struct {
+	c       *config.Configuration
+	engines []*engine.Engine
+	// configs are the configurations of the engines, per remote.
+	configs  []*config.Configuration
+	journals []*journal.Journal
+	r        *report.Reporter
+}
+
+// setup reads and checks the configuration, and sets up the engines of its remotes in mode m.
+func setup(m engine.Mode, logger *log.Logger) (*client, error) {
+	c, err := config.GetConfig()
 	if err != nil {
-		return fmt.Errorf("couldn't create dir: %w", err)
+		return nil, fmt.Errorf("can't get configuration: %w", err)
 	}
-
-	postfix, err := randomString(postfixLen)
+	for _, n := range c.Notices {
+		logger.Println(n)
+	}
+	// Vault and the keyring are read at the top level first for the Telegram token, the remotes may have their own
+	// passwords.
+	if err := c.ReadVault(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := c.ReadKeyring(); err != nil {
+		return nil, err
+	}
+	cs, err := c.Split()
 	if err != nil {
-		return fmt.Errorf("couldn't generate postfix: %w", err)
+		return nil, fmt.Errorf("invalid remotes: %w", err)
+	}
+	for _, rc := range cs {
+		if err := rc.ReadKeyring(); err != nil {
+			return nil, err
+		}
+		if err := checkConfig(rc); err != nil {
+			return nil, err
+		}
 	}
 
-	tmpFile := path.Join(dir, fmt.Sprintf(".%s.%s", fName, postfix))
-	output, err := os.Create(tmpFile)
+	r, err := report.New(c)
 	if err != nil {
-		return fmt.Errorf("couldn't create file: %w", err)
+		return nil, fmt.Errorf("can't send telegram messages: %w", err)
 	}
 
-	defer func() {
-		_ = output.Close()
-		_, err := os.Stat(tmpFile)
+	cl := &client{c: c, r: r}
+	push := false
+	for _, rc := range cs {
+		e, j, err := newEngine(rc, m, logger)
 		if err != nil {
-			if os.IsNotExist(err) {
-				return
-			}
-			panic(err)
+			cl.close()
+			return nil, err
 		}
-		os.Remove(tmpFile)
-	}()
+		if j != nil {
+			cl.journals = append(cl.journals, j)
+		}
+		push = push || e.HasPushMappings()
+		cl.engines = append(cl.engines, e)
+		cl.configs = append(cl.configs, rc)
+	}
+	if m == engine.ModeUpload && !push {
+		cl.close()
+		return nil, errors.New("upload mode needs mappings with direction push")
+	}
+	return cl, nil
+}
 
-	resp, err := reqWithAuth("GET", remote, c)
-	if err != nil {
-		return fmt.Errorf("couldn't download %s: %w", remote, err)
+// close closes the journals of the engines.
+func (cl *client) close() {
+	for _, j := range cl.journals {
+		_ = j.Close()
 	}
-	defer resp.Body.Close()
+}
 
-	_, err = io.Copy(output, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed downloading %s: %w", remote, err)
+// checkConfig validates the parts of the configuration of a remote that the engine would only reject during a run.
+func checkConfig(c *config.Configuration) error {
+	if err := c.Validate(); err != nil {
+		return err
 	}
-	err = output.Close()
-	if err != nil {
-		return fmt.Errorf("failed to close %s: %w", tmpFile, err)
+	if err := mapping.Validate(c.RootMapping); err != nil {
+		return fmt.Errorf("invalid root mapping: %w", err)
 	}
-	err = os.Rename(tmpFile, local)
-	if err != nil {
-		return fmt.Errorf("couldn't rename %s to %s: %w", tmpFile, local, err)
+	if _, err := filter.FromConfig(c); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
 	}
-
-	return nil
+	if _, err := filter.New(c.HTTP.CompressPatterns, nil); err != nil {
+		return fmt.Errorf("invalid compress_patterns: %w", err)
+	}
+	if c.HTTP.ProxyURL != "" {
+		if _, err := engine.ParseProxyURL(c.HTTP.ProxyURL); err != nil {
+			return err
+		}
+	}
+	if err := engine.CheckDialing(c.HTTP); err != nil {
+		return err
+	}
+	return engine.CheckBackend(c)
 }
 
-func getFile(f wp, c *config.Configuration) error {
-	localFile := findLocal(f.WebPath, c)
-	if localFile == "" {
-		return fmt.Errorf("couldn't find config for remote file: %s", f)
+// newEngine sets up the engine for the remote of c, the returned journal is nil if c doesn't have one and should
+// be closed by the caller otherwise.
+func newEngine(c *config.Configuration, m engine.Mode, logger *log.Logger) (*engine.Engine, *journal.Journal, error) {
+	e := engine.New(c)
+	e.SetMode(m)
+	e.Subscribe(func(ev engine.Event) {
+		if ev.Type == engine.Warning {
+			logger.Println(ev.Err)
+		}
+	})
+	if *showProgress {
+		e.Subscribe(progress.New(os.Stderr).Handle)
 	}
 
-	fileURL, err := createURL(c, f.WebPath)
+	tc, err := engine.LoadTLSConfig(c.TLS)
 	if err != nil {
-		return fmt.Errorf("couldn't parse remote: %w", err)
+		return nil, nil, err
+	}
+	e.SetTLSConfig(tc)
+	if c.TLS.InsecureSkipVerify {
+		logger.Printf("tls.insecure_skip_verify is set, the certificate of %s isn't verified", c.Remote)
 	}
 
-	err = downloadFile(fileURL.String(), localFile, c)
+	if c.EncryptionKey != "" {
+		key, err := crypt.LoadKey(c.EncryptionKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		e.SetEncryptionKey(key)
+	}
+
+	if c.Journal == "" {
+		return e, nil, nil
+	}
+	j, err := journal.Open(c.Journal)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	e.SetJournal(j)
+	return e, j, nil
+}
 
-	err = delFile(fileURL, c)
-	return err
+// watch starts watching for files the remotes announce if the configuration asks for it, the returned channel
+// receives the announced files. It is nil if the client doesn't watch.
+func watch(ctx context.Context, c *config.Configuration, engines []*engine.Engine, logger *log.Logger) <-chan string {
+	if !c.Watch {
+		return nil
+	}
+	announced := make(chan string, 1)
+	for _, e := range engines {
+		go func(e *engine.Engine) {
+			if err := e.Watch(ctx, announced); err != nil && ctx.Err() == nil {
+				logger.Printf("stopped watching for new files: %v", err)
+			}
+		}(e)
+	}
+	return announced
 }
 
-func main() {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
+// resultCode derives the exit code from the result of a run.
+func resultCode(res *engine.Result) int {
+	if errors.Is(res.Err, context.Canceled) {
+		return exitInterrupted
+	}
+	if res.Err != nil {
+		return exitCode(res.Err)
+	}
 
-	lock, err := lockfile.New(lockFile)
+	failed := res.Filter(engine.Failed)
+	if len(failed) == 0 {
+		return exitOK
+	}
+	if code := exitCode(failed[0].Err); code != exitFailure {
+		return code
+	}
+	return exitFilesFailed
+}
+
+// writeResult stores the result of a run as JSON, so other tools can act on it.
+func writeResult(p string, res *engine.Result) error {
+	b, err := json.MarshalIndent(res, "", "  ")
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return ioutil.WriteFile(p, b, 0644)
+}
 
-	if err := lock.TryLock(); err != nil {
-		panic(fmt.Sprintf("Can't lock %q, reason %v", lock, err))
+// dryRun prints the files the engines would download, once, without reporting them.
+func dryRun(ctx context.Context, engines []*engine.Engine, logger *log.Logger) int {
+	results := make([]*engine.Result, 0, len(engines))
+	for _, e := range engines {
+		res, err := e.Run(ctx)
+		if err != nil {
+			logger.Println(err)
+		}
+		results = append(results, res)
 	}
+	res := engine.Merge(results...)
+	printResult(res)
+	return resultCode(res)
+}
 
+// syncOnce runs the engines one after the other, sends a single report for all of them and returns the exit code
+// for the run. The report is also sent when ctx is cancelled halfway through the run. With several remotes, every
+// remote also gets its own result file.
+func syncOnce(parent context.Context, cl *client, logger *log.Logger) int {
+	c, r := cl.c, cl.r
 	defer func() {
-		if err := lock.Unlock(); err != nil {
-			logger.Printf("Can't unlock %q, reason %v", lock, err)
+		// The report gets its own context, it should still be sent when the run hit its deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+		defer cancel()
+		if err := r.SendReport(ctx); err != nil {
+			logger.Printf("couldn't send report: %v", err)
 		}
+		r.Reset()
 	}()
 
-	c, err := config.GetConfig()
+	ctx, cancel, err := runContext(parent, c)
 	if err != nil {
-		logger.Printf("Can't get configuration: %s", err)
-		return
+		r.AddError(err)
+		logger.Println(err)
+		return exitConfig
 	}
+	defer cancel()
 
-	r, err := report.New(c)
-	if err != nil {
-		logger.Printf("can't send telegram messages: %v", err)
-		return
-	}
-	defer func() {
-		err := r.SendReport()
+	results := make([]*engine.Result, 0, len(cl.engines))
+	for i, e := range cl.engines {
+		res, err := e.Run(ctx)
 		if err != nil {
-			panic(err)
+			logger.Println(err)
+		}
+		results = append(results, res)
+		if rc := cl.configs[i]; rc.ResultFile != "" && rc.ResultFile != c.ResultFile {
+			if err := writeResult(rc.ResultFile, res); err != nil {
+				logger.Printf("couldn't write result of %s: %v", rc.Name, err)
+			}
+		}
+		if ctx.Err() != nil {
+			break
 		}
-	}()
-
-	files, err := getFiles(c)
-	if err != nil {
-		e := fmt.Errorf("couldn't get file list: %w", err)
-		r.AddError(e)
-		logger.Println(e)
-		return
 	}
+	res := engine.Merge(results...)
+	r.AddResult(res)
 
-	for _, f := range files {
-		err := getFile(f, c)
-		if err != nil {
-			r.AddError(err)
-			continue
+	if c.ResultFile != "" {
+		if err := writeResult(c.ResultFile, res); err != nil {
+			logger.Printf("couldn't write result: %v", err)
 		}
-		r.AddFile(path.Base(f.WebPath))
 	}
+
+	return resultCode(res)
 }
@@ -0,0 +1,46 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+)
+
+func TestSignalContext(t *testing.T) {
+	ctx, cancel := signalContext(log.New(ioutil.Discard, "", 0))
+	defer cancel()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM didn't cancel the context")
+	}
+
+	// A run that was cancelled gets its own exit code, even though it stopped with an error.
+	if code := resultCode(&engine.Result{Err: ctx.Err()}); code != exitInterrupted {
+		t.Errorf("cancelled run exits with %d, want %d", code, exitInterrupted)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// mappingDefaults gives the mappings of all remotes the delete behaviour and the permissions of the top level,
+// where they don't set their own.
+func (c *Configuration) mappingDefaults() {
+	mappings := [][]FilePath{c.RootMapping}
+	for _, r := range c.Remotes {
+		mappings = append(mappings, r.RootMapping)
+	}
+	for _, ms := range mappings {
+		for i := range ms {
+			m := &ms[i]
+			if m.DeleteRemote == nil {
+				m.DeleteRemote = c.DeleteRemote
+			}
+			m.Completion = orDefault(m.Completion, c.Completion)
+			m.FileMode = orDefault(m.FileMode, c.FileMode)
+			m.DirMode = orDefault(m.DirMode, c.DirMode)
+			m.Owner = orDefault(m.Owner, c.Owner)
+			m.Group = orDefault(m.Group, c.Group)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "testing"
+
+func TestMappingDefaults(t *testing.T) {
+	keep, del := false, true
+	c := &Configuration{
+		DeleteRemote: &keep,
+		Completion:   CompletionArchive,
+		FileMode:     "0640",
+		Owner:        "plex",
+		RootMapping: []FilePath{
+			{RemotePath: "/tv"},
+			{RemotePath: "/movies", DeleteRemote: &del, FileMode: "0600", Group: "media"},
+		},
+		Remotes: []RemoteConfig{{Name: "two", RootMapping: []FilePath{{RemotePath: "/music"}}}},
+	}
+	c.mappingDefaults()
+
+	for _, m := range []FilePath{c.RootMapping[0], c.Remotes[0].RootMapping[0]} {
+		if m.DeleteRemote == nil || *m.DeleteRemote || m.Completion != CompletionArchive || m.FileMode != "0640" ||
+			m.Owner != "plex" || m.Group != "" {
+			t.Errorf("%s didn't get the defaults: %+v", m.RemotePath, m)
+		}
+	}
+	m := c.RootMapping[1]
+	if !*m.DeleteRemote || m.FileMode != "0600" || m.Group != "media" || m.Owner != "plex" {
+		t.Errorf("%s lost its own settings: %+v", m.RemotePath, m)
+	}
+}
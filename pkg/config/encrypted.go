@@ -0,0 +1,70 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+const (
+	// KeyFileEnv names the hex encoded key file that encrypted values are sealed with.
+	KeyFileEnv = EnvPrefix + "_CONFIG_KEY_FILE"
+	// PassphraseEnv holds the passphrase that encrypted values are sealed with, if KeyFileEnv isn't set.
+	PassphraseEnv = EnvPrefix + "_CONFIG_PASSPHRASE"
+)
+
+// ErrNoValueSecret is returned when neither KeyFileEnv nor PassphraseEnv is set.
+var ErrNoValueSecret = errors.New(KeyFileEnv + " or " + PassphraseEnv + " must be set for encrypted values")
+
+// ValueSecret returns the secret that encrypted configuration values are sealed with, the key in the file named by
+// KeyFileEnv or else the passphrase in PassphraseEnv.
+func ValueSecret() ([]byte, error) {
+	if p := os.Getenv(KeyFileEnv); p != "" {
+		return crypt.LoadKey(ExpandHome(p))
+	}
+	if pass := os.Getenv(PassphraseEnv); pass != "" {
+		return []byte(pass), nil
+	}
+	return nil, ErrNoValueSecret
+}
+
+// decryptSecrets replaces the secrets that start with crypt.ValuePrefix by their decrypted values. The secret is
+// only needed when there are encrypted values.
+func (c *Configuration) decryptSecrets() error {
+	var secret []byte
+	for _, s := range c.secrets() {
+		if !crypt.IsSealed(*s.value) {
+			continue
+		}
+		if secret == nil {
+			var err error
+			if secret, err = ValueSecret(); err != nil {
+				return fmt.Errorf("couldn't decrypt %s: %w", s.field, err)
+			}
+		}
+		v, err := crypt.OpenValue(secret, *s.value)
+		if err != nil {
+			return fmt.Errorf("couldn't decrypt %s: %w", s.field, err)
+		}
+		*s.value = v
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+func TestDecryptSecrets(t *testing.T) {
+	_ = os.Unsetenv(KeyFileEnv)
+	_ = os.Setenv(PassphraseEnv, "passphrase")
+	defer func() { _ = os.Unsetenv(PassphraseEnv) }()
+
+	seal := func(v string) string {
+		s, err := crypt.SealValue([]byte("passphrase"), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	c := &Configuration{
+		Password: seal("pass"),
+		Telegram: TelegramConfig{Token: "123:plain"},
+		Remotes:  []RemoteConfig{{Name: "two", Password: seal("other")}},
+	}
+	if err := c.decryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "pass" || c.Remotes[0].Password != "other" {
+		t.Errorf("secrets weren't decrypted: %+v", c)
+	}
+	if c.Telegram.Token != "123:plain" {
+		t.Errorf("plain secret was changed to %q", c.Telegram.Token)
+	}
+
+	c = &Configuration{Password: seal("pass")}
+	_ = os.Setenv(PassphraseEnv, "wrong")
+	if err := c.decryptSecrets(); !errors.Is(err, crypt.ErrInvalid) {
+		t.Errorf("decrypting with the wrong passphrase gave %v, want %v", err, crypt.ErrInvalid)
+	}
+	_ = os.Unsetenv(PassphraseEnv)
+	if err := c.decryptSecrets(); !errors.Is(err, ErrNoValueSecret) {
+		t.Errorf("decrypting without a secret gave %v, want %v", err, ErrNoValueSecret)
+	}
+	c = &Configuration{Password: "pass"}
+	if err := c.decryptSecrets(); err != nil {
+		t.Errorf("plain configuration needed a secret: %v", err)
+	}
+}
+
+func TestValueSecretKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "key")
+	hexKey := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"
+	if err := ioutil.WriteFile(p, []byte(hexKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv(KeyFileEnv, p)
+	defer func() { _ = os.Unsetenv(KeyFileEnv) }()
+	_ = os.Setenv(PassphraseEnv, "ignored")
+	defer func() { _ = os.Unsetenv(PassphraseEnv) }()
+
+	secret, err := ValueSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != crypt.KeySize || secret[1] != 1 {
+		t.Errorf("got secret %x, want the key of the key file", secret)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the configuration. The variable of a key is
+// its upper case path with underscores, MEDIASYNC_HTTP_PROXY_URL sets http.proxy_url.
+const EnvPrefix = "MEDIASYNC"
+
+// bindEnv makes viper read every key of the configuration from the environment. Viper only looks up the
+// variables of keys it knows about, so the keys that aren't in the configuration file have to be bound.
+func bindEnv() error {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	for _, key := range configKeys(reflect.TypeOf(Configuration{}), "") {
+		if err := viper.BindEnv(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configKeys lists the keys of the mapstructure tags of t, nested structs are walked into. Slices and maps are
+// single keys, their environment variables hold JSON.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(f.Type, prefix+tag+".")...)
+			continue
+		}
+		keys = append(keys, prefix+tag)
+	}
+	return keys
+}
+
+// jsonHook decodes strings into maps and slices of structs as JSON, which is how environment variables set them.
+// Slices of strings are left to be split on commas.
+func jsonHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	if to.Kind() != reflect.Map && (to.Kind() != reflect.Slice || to.Elem().Kind() != reflect.Struct) {
+		return data, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(data.(string)), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
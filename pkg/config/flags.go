@@ -0,0 +1,61 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	// commandLine is the flag set of RegisterFlags, overrides lists the names of its flags that set keys.
+	commandLine *pflag.FlagSet
+	overrides   []string
+	configFile  string
+	configFmt   string
+)
+
+// RegisterFlags adds the flags that override the configuration to fs, they take precedence over the environment
+// and the configuration file once fs is parsed. The key of a flag is its name with underscores.
+func RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "configuration file to use instead of looking for "+ConfigName)
+	fs.StringVar(&configFmt, "config-format", "",
+		"format of the configuration file, yaml, toml or json, by default the extension of --config tells")
+	fs.String("remote", "", "URL of the remote")
+	fs.String("username", "", "user name for the remote")
+	fs.String("password", "", "password for the remote, other users can see it, prefer MEDIASYNC_PASSWORD")
+	fs.Int("concurrency", 0, "amount of files that are downloaded in parallel")
+	fs.Bool("dry-run", false, "only list the files that would be transferred")
+	commandLine = fs
+	overrides = []string{"remote", "username", "password", "concurrency", "dry-run"}
+}
+
+// bindFlags makes viper use the flags of RegisterFlags, viper only takes the ones that are set on the command line.
+func bindFlags() error {
+	if commandLine == nil {
+		return nil
+	}
+	for _, name := range overrides {
+		key := strings.Replace(name, "-", "_", -1)
+		if err := viper.BindPFlag(key, commandLine.Lookup(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
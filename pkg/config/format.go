@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Format is the format of a configuration file.
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+	FormatJSON Format = "json"
+)
+
+// Formats are the supported formats, in the order a directory is searched for ConfigName.
+var Formats = []Format{FormatYAML, FormatTOML, FormatJSON}
+
+// extensions are the file extensions of f.
+func (f Format) extensions() []string {
+	if f == FormatYAML {
+		return []string{".yaml", ".yml"}
+	}
+	return []string{"." + string(f)}
+}
+
+// ParseFormat parses the name of a format, "yml" is accepted for YAML.
+func ParseFormat(s string) (Format, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "yml" {
+		return FormatYAML, nil
+	}
+	for _, f := range Formats {
+		if s == string(f) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown configuration format %q, expected yaml, toml or json", s)
+}
+
+// FormatOf returns the format of the file at p from its extension.
+func FormatOf(p string) (Format, error) {
+	ext := strings.ToLower(filepath.Ext(p))
+	for _, f := range Formats {
+		for _, e := range f.extensions() {
+			if ext == e {
+				return f, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("can't tell the format of %s from its extension, set --config-format", p)
+}
+
+// findConfig looks for ConfigName in dirs, with the extensions of formats, and returns the first file that exists.
+func findConfig(dirs []string, formats []Format) (string, Format, bool) {
+	for _, dir := range dirs {
+		for _, f := range formats {
+			for _, ext := range f.extensions() {
+				p := filepath.Join(dir, ConfigName+ext)
+				if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+					return p, f, true
+				}
+			}
+		}
+	}
+	return "", "", false
+}
+
+// readConfig reads a configuration in format f from r into v.
+func readConfig(v *viper.Viper, r io.Reader, f Format) error {
+	v.SetConfigType(string(f))
+	if err := v.ReadConfig(r); err != nil {
+		return fmt.Errorf("couldn't parse %s configuration: %w", f, err)
+	}
+	return nil
+}
+
+// Parse reads a configuration in format f from r. Unlike GetConfig, it doesn't look at the environment or the
+// flags, and doesn't expand paths or read secrets.
+func Parse(r io.Reader, f Format) (*Configuration, error) {
+	v := viper.New()
+	if err := readConfig(v, r, f); err != nil {
+		return nil, err
+	}
+	var c Configuration
+	if err := v.Unmarshal(&c, decodeHook()); err != nil {
+		return nil, err
+	}
+	c.mappingDefaults()
+	return &c, nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// formatFixtures are the same configuration in every format.
+var formatFixtures = map[Format]string{
+	FormatYAML: `remote: https://dl.example.org
+username: user
+concurrency: 3
+watch: true
+interval: 1h30m
+chunk_size: 8MB
+include: ["*.mkv", "*.srt"]
+telegram:
+  token: "123:abc"
+  chat_id: 42
+root_mapping:
+  - remote_path: /tv
+    local_path: /media/tv
+    priority: 2
+  - remote_path: /films
+    local_path: /media/films
+    max_bandwidth: 5MB/s
+`,
+	FormatTOML: `remote = "https://dl.example.org"
+username = "user"
+concurrency = 3
+watch = true
+interval = "1h30m"
+chunk_size = "8MB"
+include = ["*.mkv", "*.srt"]
+
+[telegram]
+token = "123:abc"
+chat_id = 42
+
+[[root_mapping]]
+remote_path = "/tv"
+local_path = "/media/tv"
+priority = 2
+
+[[root_mapping]]
+remote_path = "/films"
+local_path = "/media/films"
+max_bandwidth = "5MB/s"
+`,
+	FormatJSON: `{
+  "remote": "https://dl.example.org",
+  "username": "user",
+  "concurrency": 3,
+  "watch": true,
+  "interval": "1h30m",
+  "chunk_size": "8MB",
+  "include": ["*.mkv", "*.srt"],
+  "telegram": {"token": "123:abc", "chat_id": 42},
+  "root_mapping": [
+    {"remote_path": "/tv", "local_path": "/media/tv", "priority": 2},
+    {"remote_path": "/films", "local_path": "/media/films", "max_bandwidth": "5MB/s"}
+  ]
+}
+`,
+}
+
+func fixtureConfig() *Configuration {
+	return &Configuration{
+		Remote:   "https://dl.example.org",
+		UserName: "user",
+		RootMapping: []FilePath{
+			{RemotePath: "/tv", LocalPath: "/media/tv", Priority: 2},
+			{RemotePath: "/films", LocalPath: "/media/films", MaxBandwidth: 5000000},
+		},
+		Telegram:    TelegramConfig{Token: "123:abc", ChatID: 42},
+		Concurrency: 3,
+		Watch:       true,
+		Interval:    90 * time.Minute,
+		ChunkSize:   8000000,
+		Include:     []string{"*.mkv", "*.srt"},
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	want := fixtureConfig()
+	for _, f := range Formats {
+		c, err := Parse(strings.NewReader(formatFixtures[f]), f)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: got %+v, want %+v", f, c, want)
+		}
+	}
+	if _, err := Parse(strings.NewReader(formatFixtures[FormatJSON]), FormatTOML); err == nil {
+		t.Error("JSON was parsed as TOML")
+	}
+}
+
+func TestRoundTripFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := fixtureConfig()
+	for _, f := range Formats {
+		v := viper.New()
+		if err := readConfig(v, strings.NewReader(formatFixtures[f]), f); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		p := filepath.Join(dir, ConfigName+f.extensions()[0])
+		if err := v.WriteConfigAs(p); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := Parse(strings.NewReader(string(b)), f)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", f, err, b)
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: got %+v, want %+v", f, c, want)
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]Format{
+		"sync.yaml": FormatYAML,
+		"sync.YML":  FormatYAML,
+		"sync.toml": FormatTOML,
+		"sync.json": FormatJSON,
+		"sync.conf": "",
+		"sync":      "",
+	}
+	for p, want := range tests {
+		f, err := FormatOf(p)
+		if f != want || (err == nil) != (want != "") {
+			t.Errorf("FormatOf(%q) = %q, %v, want %q", p, f, err, want)
+		}
+	}
+	if _, err := ParseFormat("ini"); err == nil {
+		t.Error("unsupported format ini was accepted")
+	}
+}
+
+func TestGetConfigFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "sync.conf")
+	if err := ioutil.WriteFile(p, []byte(formatFixtures[FormatTOML]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	defer viper.Reset()
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(fs)
+	defer func() { commandLine, configFile, configFmt = nil, "", "" }()
+	if err := fs.Parse([]string{"--config", p}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetConfig(); err == nil {
+		t.Error("configuration without a known extension was read without -config-format")
+	}
+	if err := fs.Parse([]string{"--config", p, "--config-format", "toml"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Remote != "https://dl.example.org" || len(c.RootMapping) != 2 {
+		t.Errorf("TOML configuration wasn't read: %+v", c)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{ConfigName + ".json", ConfigName + ".yml"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p, f, ok := findConfig([]string{dir}, Formats); !ok || f != FormatYAML || filepath.Base(p) != ConfigName+".yml" {
+		t.Errorf("got %s, %s, %v, want the YAML file", p, f, ok)
+	}
+	if p, f, ok := findConfig([]string{dir}, []Format{FormatJSON}); !ok || f != FormatJSON {
+		t.Errorf("got %s, %s, %v, want the JSON file", p, f, ok)
+	}
+	if _, _, ok := findConfig([]string{dir}, []Format{FormatTOML}); ok {
+		t.Error("found a TOML file that doesn't exist")
+	}
+}
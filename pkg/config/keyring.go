@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ainmosni/mediasync-client/pkg/keyring"
+)
+
+// TelegramAccount is the keyring account of the Telegram bot token.
+const TelegramAccount = "telegram"
+
+// keyringGet looks up secrets, tests replace it.
+var keyringGet = keyring.Get
+
+// PasswordAccount is the keyring account of the password of the user name of c on its remote.
+func (c *Configuration) PasswordAccount() string {
+	return c.UserName + "@" + c.Remote
+}
+
+// UsesPassword tells whether the remote authenticates with the user name and password.
+func (c *Configuration) UsesPassword() bool {
+	return usesPassword(c.UserName, c.Auth.Type)
+}
+
+func usesPassword(user, authType string) bool {
+	switch authType {
+	case "", AuthBasic, AuthDigest:
+		return user != ""
+	default:
+		return false
+	}
+}
+
+// ReadKeyring fills in the password and the Telegram token that aren't set from the keyring of the OS, if
+// Keyring is set.
+func (c *Configuration) ReadKeyring() error {
+	if !c.Keyring {
+		return nil
+	}
+	if c.Password == "" && c.Remote != "" && c.UsesPassword() {
+		p, err := readKeyring(c.PasswordAccount())
+		if err != nil {
+			return err
+		}
+		c.Password = p
+	}
+	if c.Telegram.Token == "" {
+		t, err := readKeyring(TelegramAccount)
+		if err != nil {
+			return err
+		}
+		c.Telegram.Token = t
+	}
+	return nil
+}
+
+func readKeyring(account string) (string, error) {
+	s, err := keyringGet(account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("the keyring has no secret for %s, store it with mediasync-client login", account)
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the secret for %s from the keyring: %w", account, err)
+	}
+	return s, nil
+}
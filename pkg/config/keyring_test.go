@@ -0,0 +1,53 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/keyring"
+)
+
+func TestReadKeyring(t *testing.T) {
+	secrets := map[string]string{"user@https://dl.example.org": "pass", TelegramAccount: "123:token"}
+	keyringGet = func(account string) (string, error) {
+		if s, ok := secrets[account]; ok {
+			return s, nil
+		}
+		return "", keyring.ErrNotFound
+	}
+	defer func() { keyringGet = keyring.Get }()
+
+	c := &Configuration{Remote: "https://dl.example.org", UserName: "user", Keyring: true}
+	if err := c.ReadKeyring(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "pass" || c.Telegram.Token != "123:token" {
+		t.Errorf("secrets weren't read: %q %q", c.Password, c.Telegram.Token)
+	}
+
+	c = &Configuration{Remote: "https://other.example.org", UserName: "user", Keyring: true}
+	if err := c.ReadKeyring(); err == nil {
+		t.Error("missing password wasn't reported")
+	}
+
+	c = &Configuration{Remote: "https://other.example.org", UserName: "user", Auth: AuthConfig{Type: AuthBearer},
+		Keyring: true}
+	if err := c.ReadKeyring(); err != nil || c.Password != "" {
+		t.Errorf("password was read for bearer auth: %v", err)
+	}
+}
@@ -18,6 +18,9 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/spf13/viper"
 )
 
@@ -25,29 +28,84 @@ const (
 	ConfigName = "clientconfig"
 )
 
-var ConfigPaths = [...]string{
-	".",
-	"/etc/mediasync",
-	"~/.config/mediasync",
-}
-
+// GetConfig reads the configuration file, with the environment variables of EnvPrefix and the flags of
+// RegisterFlags on top. The file may be missing if the environment or the flags hold the whole configuration,
+// unless it was passed with --config. The mappings get the defaults of the top level, see mappingDefaults. Paths
+// are expanded, see expandPaths, and secrets with a _file variant are read from their files. Encrypted secrets are
+// decrypted last, see decryptSecrets. Every call starts from scratch, so a reload doesn't keep settings of a file
+// that is gone.
 func GetConfig() (*Configuration, error) {
-	viper.SetConfigName(ConfigName)
-	for _, cp := range ConfigPaths {
-		viper.AddConfigPath(cp)
+	viper.Reset()
+	if err := bindEnv(); err != nil {
+		return &Configuration{}, err
 	}
-
-	err := viper.ReadInConfig()
-	if err != nil {
+	if err := bindFlags(); err != nil {
+		return &Configuration{}, err
+	}
+	if err := readConfigFile(); err != nil {
 		return &Configuration{}, err
 	}
 
 	var c Configuration
-	err = viper.Unmarshal(&c)
-
-	if err != nil {
+	if err := viper.Unmarshal(&c, decodeHook()); err != nil {
+		return &Configuration{}, err
+	}
+	c.mappingDefaults()
+	if err := c.expandPaths(); err != nil {
+		return &Configuration{}, err
+	}
+	if err := c.readSecretFiles(); err != nil {
+		return &Configuration{}, err
+	}
+	if err := c.decryptSecrets(); err != nil {
 		return &Configuration{}, err
 	}
 
 	return &c, nil
 }
+
+// readConfigFile reads the file of --config, in the format of --config-format or its extension. Without --config, the
+// first ConfigName in ConfigPaths is read, in the order of Formats or only in --config-format. It's no error if
+// there is no such file.
+func readConfigFile() error {
+	var formats []Format
+	if configFmt != "" {
+		f, err := ParseFormat(configFmt)
+		if err != nil {
+			return err
+		}
+		formats = []Format{f}
+	}
+
+	var p string
+	var f Format
+	if configFile != "" {
+		p = ExpandHome(configFile)
+		if formats != nil {
+			f = formats[0]
+		} else {
+			var err error
+			if f, err = FormatOf(p); err != nil {
+				return err
+			}
+		}
+	} else {
+		if formats == nil {
+			formats = Formats
+		}
+		var ok bool
+		if p, f, ok = findConfig(ConfigPaths(), formats); !ok {
+			return nil
+		}
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("couldn't open configuration: %w", err)
+	}
+	defer file.Close()
+	if err := readConfig(viper.GetViper(), file, f); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestGetConfigEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	yaml := "remote: https://file.example.org\nusername: user\nhttp:\n  keep_alive: 10s\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ConfigName+".yaml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	env := map[string]string{
+		"MEDIASYNC_REMOTE":         "https://env.example.org",
+		"MEDIASYNC_PASSWORD":       "secret",
+		"MEDIASYNC_TELEGRAM_TOKEN": "bot",
+		"MEDIASYNC_HTTP_PROXY_URL": "http://proxy:3128",
+		"MEDIASYNC_HEADERS":        `{"X-Tenant": "home"}`,
+		"MEDIASYNC_ROOT_MAPPING":   `[{"remote_path": "/tv", "local_path": "/media/tv", "priority": 2}]`,
+		"MEDIASYNC_INCLUDE":        "*.mkv,*.srt",
+	}
+	for k, v := range env {
+		_ = os.Setenv(k, v)
+		defer func(k string) { _ = os.Unsetenv(k) }(k)
+	}
+	viper.Reset()
+	defer viper.Reset()
+
+	c, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Remote != "https://env.example.org" || c.UserName != "user" || c.Password != "secret" {
+		t.Errorf("unexpected remote: %+v", c)
+	}
+	if c.Telegram.Token != "bot" || c.HTTP.ProxyURL != "http://proxy:3128" || c.HTTP.KeepAlive.String() != "10s" {
+		t.Errorf("unexpected nested keys: %+v %+v", c.Telegram, c.HTTP)
+	}
+	if c.Headers["X-Tenant"] != "home" {
+		t.Errorf("unexpected headers: %v", c.Headers)
+	}
+	if len(c.RootMapping) != 1 || c.RootMapping[0].LocalPath != "/media/tv" || c.RootMapping[0].Priority != 2 {
+		t.Errorf("unexpected mappings: %+v", c.RootMapping)
+	}
+	if len(c.Include) != 2 || c.Include[1] != "*.srt" {
+		t.Errorf("unexpected include: %v", c.Include)
+	}
+}
+
+func TestGetConfigFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "sync.yaml")
+	if err := ioutil.WriteFile(p, []byte("remote: https://file.example.org\nconcurrency: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv("MEDIASYNC_USERNAME", "env")
+	defer func() { _ = os.Unsetenv("MEDIASYNC_USERNAME") }()
+	viper.Reset()
+	defer viper.Reset()
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(fs)
+	defer func() { commandLine, configFile, configFmt = nil, "", "" }()
+	if err := fs.Parse([]string{"--config", p, "--username", "flag", "--concurrency", "4", "--dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Remote != "https://file.example.org" || c.UserName != "flag" || c.Concurrency != 4 || !c.DryRun {
+		t.Errorf("flags weren't applied: %+v", c)
+	}
+}
+
+func TestGetConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, ConfigName+".yaml")
+	if err := ioutil.WriteFile(p, []byte("remote: https://file.example.org\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+	defer func() { _ = os.Unsetenv("XDG_CONFIG_HOME") }()
+	defer viper.Reset()
+
+	c, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Remote != "https://file.example.org" {
+		t.Fatalf("configuration wasn't read: %+v", c)
+	}
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = GetConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Remote != "" {
+		t.Errorf("removed configuration is still used: %q", c.Remote)
+	}
+}
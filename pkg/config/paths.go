@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appDir is the directory of the client in the XDG base directories.
+const appDir = "mediasync"
+
+// ExpandHome replaces a leading ~ in p with the home directory of the user, other paths are returned as is.
+func ExpandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
+
+// xdgDir returns the directory of the client in the base directory of the XDG environment variable env, or in
+// fallback under the home directory if it isn't set. Relative paths in the variable are ignored, as the
+// specification says.
+func xdgDir(env, fallback string) string {
+	if d := os.Getenv(env); filepath.IsAbs(d) {
+		return filepath.Join(d, appDir)
+	}
+	return ExpandHome(filepath.Join("~", fallback, appDir))
+}
+
+// ConfigDir is where the configuration of the user is, $XDG_CONFIG_HOME/mediasync or ~/.config/mediasync.
+func ConfigDir() string {
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// StateDir is where state files with relative paths are kept, $XDG_STATE_HOME/mediasync or
+// ~/.local/state/mediasync.
+func StateDir() string {
+	return xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+}
+
+// CacheDir is where cache files with relative paths are kept, $XDG_CACHE_HOME/mediasync or ~/.cache/mediasync.
+func CacheDir() string {
+	return xdgDir("XDG_CACHE_HOME", ".cache")
+}
+
+// ConfigPaths are the directories that are searched for the configuration file, in order.
+func ConfigPaths() []string {
+	return []string{".", "/etc/mediasync", ConfigDir()}
+}
+
+// expandPaths expands ~ in all paths of the configuration, and puts the state and cache files with relative paths
+// in StateDir and CacheDir, which are created if needed. Files that already exist relative to the working
+// directory, where earlier versions kept them, stay there with a notice.
+func (c *Configuration) expandPaths() error {
+	paths := []*string{
+		&c.PasswordFile, &c.StagingDir, &c.ResultFile, &c.EncryptionKey, &c.SFTP.IdentityFile,
+		&c.TLS.CertFile, &c.TLS.KeyFile, &c.TLS.CAFile, &c.Telegram.TokenFile,
+		&c.Vault.CAFile, &c.Vault.TokenFile, &c.Vault.SecretIDFile,
+	}
+	paths = append(paths, c.Auth.paths()...)
+	paths = append(paths, c.S3.paths()...)
+	paths = append(paths, mappingPaths(c.RootMapping)...)
+	state := []*string{&c.Journal, &c.MirrorState, &c.QueueState, &c.DedupeIndex}
+	cache := []*string{&c.Auth.TokenCache}
+	for i := range c.Remotes {
+		r := &c.Remotes[i]
+		paths = append(paths, &r.PasswordFile, &r.SFTP.IdentityFile)
+		paths = append(paths, r.Auth.paths()...)
+		paths = append(paths, r.S3.paths()...)
+		paths = append(paths, mappingPaths(r.RootMapping)...)
+		cache = append(cache, &r.Auth.TokenCache)
+	}
+
+	for _, p := range paths {
+		*p = ExpandHome(*p)
+	}
+	if err := c.inDir(StateDir(), state); err != nil {
+		return err
+	}
+	return c.inDir(CacheDir(), cache)
+}
+
+// inDir expands ~ in paths and puts the relative ones in dir, unless only the file relative to the working
+// directory exists.
+func (c *Configuration) inDir(dir string, paths []*string) error {
+	for _, p := range paths {
+		if *p == "" {
+			continue
+		}
+		*p = ExpandHome(*p)
+		if filepath.IsAbs(*p) {
+			continue
+		}
+		old := *p
+		*p = filepath.Join(dir, old)
+		if exists(old) && !exists(*p) {
+			if abs, err := filepath.Abs(old); err == nil {
+				old = abs
+			}
+			c.Notices = append(c.Notices, fmt.Sprintf("using %s from the working directory, move it to %s", old, *p))
+			*p = old
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(*p), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exists reports whether there is a file at p.
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func (a *AuthConfig) paths() []*string {
+	return []*string{&a.TokenFile, &a.SecretFile, &a.ClientSecretFile, &a.RefreshTokenFile}
+}
+
+func (s *S3Config) paths() []*string {
+	return []*string{&s.SecretAccessKeyFile, &s.SessionTokenFile}
+}
+
+func mappingPaths(mappings []FilePath) []*string {
+	paths := make([]*string, 0, len(mappings))
+	for i := range mappings {
+		paths = append(paths, &mappings[i].LocalPath)
+	}
+	return paths
+}
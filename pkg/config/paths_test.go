@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	home, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	state := filepath.Join(home, "state")
+	for k, v := range map[string]string{"HOME": home, "XDG_STATE_HOME": state, "XDG_CACHE_HOME": "relative"} {
+		old, ok := os.LookupEnv(k)
+		_ = os.Setenv(k, v)
+		defer func(k, old string, ok bool) {
+			if ok {
+				_ = os.Setenv(k, old)
+			} else {
+				_ = os.Unsetenv(k)
+			}
+		}(k, old, ok)
+	}
+
+	// Earlier versions kept relative state files in the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	if err := os.Chdir(home); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("digests.json", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Configuration{
+		DedupeIndex:   "digests.json",
+		EncryptionKey: "~/key",
+		Journal:       "journal.log",
+		MirrorState:   "/var/lib/mediasync/mirrored.json",
+		QueueState:    "~/queue.json",
+		Auth:          AuthConfig{TokenCache: "token.json"},
+		RootMapping:   []FilePath{{RemotePath: "/tv", LocalPath: "~/media/tv"}},
+		Remotes: []RemoteConfig{
+			{Name: "two", RootMapping: []FilePath{{RemotePath: "/tv", LocalPath: "~"}}},
+		},
+	}
+	if err := c.expandPaths(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []struct{ got, want string }{
+		{c.EncryptionKey, filepath.Join(home, "key")},
+		{c.Journal, filepath.Join(state, "mediasync", "journal.log")},
+		{c.DedupeIndex, filepath.Join(home, "digests.json")},
+		{c.MirrorState, "/var/lib/mediasync/mirrored.json"},
+		{c.QueueState, filepath.Join(home, "queue.json")},
+		{c.Auth.TokenCache, filepath.Join(home, ".cache", "mediasync", "token.json")},
+		{c.RootMapping[0].LocalPath, filepath.Join(home, "media", "tv")},
+		{c.Remotes[0].RootMapping[0].LocalPath, home},
+	} {
+		if p.got != p.want {
+			t.Errorf("got %s, expected %s", p.got, p.want)
+		}
+	}
+	if len(c.Notices) != 1 || !strings.Contains(c.Notices[0], filepath.Join(state, "mediasync", "digests.json")) {
+		t.Errorf("unexpected notices: %v", c.Notices)
+	}
+	if fi, err := os.Stat(filepath.Join(state, "mediasync")); err != nil || !fi.IsDir() {
+		t.Errorf("state directory wasn't created: %v", err)
+	}
+	if ConfigPaths()[2] != filepath.Join(home, ".config", "mediasync") {
+		t.Errorf("unexpected config paths: %v", ConfigPaths())
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// RemoteConfig is a further remote with its own credentials and mappings, the settings it doesn't have come from
+// the top level of the configuration.
+type RemoteConfig struct {
+	// Name is required, it is appended to the paths of the state files of the remote.
+	Name        string     `mapstructure:"name"`
+	Remote      string     `mapstructure:"remote"`
+	Scheme      string     `mapstructure:"scheme"`
+	UserName    string     `mapstructure:"username"`
+	Password    string     `mapstructure:"password"`
+	Auth        AuthConfig `mapstructure:"auth"`
+	SFTP        SFTPConfig `mapstructure:"sftp"`
+	S3          S3Config   `mapstructure:"s3"`
+	Mirrors     []string   `mapstructure:"mirrors"`
+	RootMapping []FilePath `mapstructure:"root_mapping"`
+	// Headers replace the headers of the top level when there are any.
+	Headers map[string]string `mapstructure:"headers"`
+	// PasswordFile is a file the password is read from instead.
+	PasswordFile string `mapstructure:"password_file"`
+	// ProxyURL replaces http.proxy_url of the top level for the requests to this remote when it is set.
+	ProxyURL string `mapstructure:"proxy_url"`
+}
+
+// Split returns a configuration per remote, the top level remote first if there is one. The configurations of
+// the further remotes get their name appended to the paths of the journal, the state files, the result file and
+// the staging dir, so every remote keeps its own state. Credentials, headers, mirrors and mappings aren't
+// inherited, every remote only uses its own, the rest of the top level settings is.
+func (c *Configuration) Split() ([]*Configuration, error) {
+	var cs []*Configuration
+	if c.Remote != "" || len(c.Remotes) == 0 {
+		top := *c
+		top.Remotes = nil
+		cs = append(cs, &top)
+	}
+
+	names := map[string]bool{c.Name: c.Remote != ""}
+	for _, r := range c.Remotes {
+		if r.Name == "" {
+			return nil, fmt.Errorf("remote %s has no name", r.Remote)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("there are several remotes named %q", r.Name)
+		}
+		names[r.Name] = true
+
+		rc := *c
+		rc.Remotes = nil
+		rc.Name, rc.Remote, rc.Mirrors, rc.RootMapping = r.Name, r.Remote, r.Mirrors, r.RootMapping
+		if r.Scheme != "" {
+			rc.Scheme = r.Scheme
+		}
+		rc.UserName, rc.Password, rc.PasswordFile = r.UserName, r.Password, r.PasswordFile
+		rc.Auth, rc.Headers, rc.SFTP, rc.S3 = r.Auth, r.Headers, r.SFTP, r.S3
+		for _, p := range []*string{&rc.Journal, &rc.MirrorState, &rc.QueueState, &rc.DedupeIndex, &rc.ResultFile} {
+			if *p != "" {
+				*p += "." + r.Name
+			}
+		}
+		if rc.StagingDir != "" {
+			rc.StagingDir = filepath.Join(rc.StagingDir, r.Name)
+		}
+		if r.ProxyURL != "" {
+			rc.HTTP.ProxyURL = r.ProxyURL
+		}
+		rc.StateSuffix = r.Name
+		cs = append(cs, &rc)
+	}
+	return cs, nil
+}
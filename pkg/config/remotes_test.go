@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	c := &Configuration{
+		Remote:      "https://one.example.org",
+		UserName:    "user",
+		Password:    "pass",
+		QueueState:  "/var/lib/mediasync/queue.json",
+		HTTP:        HTTPConfig{ProxyURL: "http://proxy.example.org:3128"},
+		Mirrors:     []string{"https://mirror.example.org"},
+		RootMapping: []FilePath{{RemotePath: "/tv", LocalPath: "/media/tv"}},
+		Remotes: []RemoteConfig{
+			{
+				Name:        "two",
+				Remote:      "https://two.example.org",
+				ProxyURL:    "socks5://localhost:1080",
+				Auth:        AuthConfig{Type: AuthBearer, Token: "token"},
+				RootMapping: []FilePath{{RemotePath: "/movies", LocalPath: "/media/movies"}},
+			},
+			{
+				Name:     "three",
+				Remote:   "three.example.org:/srv",
+				Scheme:   SchemeSFTP,
+				UserName: "sync",
+			},
+		},
+	}
+
+	cs, err := c.Split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 3 {
+		t.Fatalf("expected 3 configurations, got %d", len(cs))
+	}
+	if cs[0].Remote != c.Remote || len(cs[0].Remotes) != 0 || cs[0].QueueState != c.QueueState {
+		t.Errorf("unexpected top level configuration: %+v", cs[0])
+	}
+
+	two := cs[1]
+	if two.Remote != "https://two.example.org" || two.Auth.Type != AuthBearer || two.UserName != "" {
+		t.Errorf("unexpected credentials for two: %+v", two)
+	}
+	if len(two.Mirrors) != 0 || len(two.RootMapping) != 1 || two.RootMapping[0].RemotePath != "/movies" {
+		t.Errorf("two inherited mirrors or mappings: %+v", two)
+	}
+	if two.HTTP.ProxyURL != "socks5://localhost:1080" || cs[0].HTTP.ProxyURL != c.HTTP.ProxyURL {
+		t.Errorf("unexpected proxies %q and %q", cs[0].HTTP.ProxyURL, two.HTTP.ProxyURL)
+	}
+	if two.QueueState != "/var/lib/mediasync/queue.json.two" || two.Journal != "" {
+		t.Errorf("unexpected state files for two: %q and %q", two.QueueState, two.Journal)
+	}
+
+	three := cs[2]
+	if three.HTTP.ProxyURL != c.HTTP.ProxyURL {
+		t.Errorf("three didn't inherit the proxy: %q", three.HTTP.ProxyURL)
+	}
+	if three.Scheme != SchemeSFTP || three.UserName != "sync" || three.Password != "" {
+		t.Errorf("unexpected credentials for three: %+v", three)
+	}
+
+	c.Remotes[1].Name = "two"
+	if _, err := c.Split(); err == nil {
+		t.Error("expected an error for remotes with the same name")
+	}
+}
+
+func TestSplitCredentials(t *testing.T) {
+	c := &Configuration{
+		Remote:     "https://a.example.org",
+		UserName:   "a",
+		Password:   "secret-a",
+		Auth:       AuthConfig{Type: AuthBearer, Token: "token-a"},
+		Headers:    map[string]string{"X-Api-Key": "key-a"},
+		S3:         S3Config{AccessKeyID: "id-a", SecretAccessKey: "s3-a"},
+		SFTP:       SFTPConfig{IdentityFile: "/home/a/.ssh/id_ed25519"},
+		StagingDir: "/var/tmp/staging",
+		ResultFile: "/var/lib/mediasync/result.json",
+		Remotes: []RemoteConfig{
+			{Name: "b", Remote: "https://b.example.org"},
+			{Name: "c", Remote: "https://c.example.org", Password: "secret-c"},
+		},
+	}
+	cs, err := c.Split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range cs[1:] {
+		if rc.UserName != "" || rc.Password == c.Password || rc.Auth.Type != "" || rc.Auth.Token != "" || rc.Headers != nil ||
+			rc.S3 != (S3Config{}) || rc.SFTP != (SFTPConfig{}) {
+			t.Errorf("remote %s got the credentials of the top level remote: %+v", rc.Name, rc)
+		}
+	}
+	b, cc := cs[1], cs[2]
+	if cc.Password != "secret-c" {
+		t.Errorf("password of c was dropped: %q", cc.Password)
+	}
+	if b.StagingDir != filepath.Join("/var/tmp/staging", "b") || b.ResultFile != "/var/lib/mediasync/result.json.b" {
+		t.Errorf("unexpected staging dir or result file for b: %q and %q", b.StagingDir, b.ResultFile)
+	}
+	if b.StateSuffix != "b" || cs[0].StateSuffix != "" {
+		t.Errorf("unexpected state suffixes %q and %q", cs[0].StateSuffix, b.StateSuffix)
+	}
+}
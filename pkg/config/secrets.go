@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// secretFile is a secret and the file it can be read from, field is its key in the configuration file.
+type secretFile struct {
+	field string
+	value *string
+	file  string
+}
+
+// readSecretFiles sets the secrets that have a _file variant from their files. A trailing newline is dropped, as
+// editors and echo add one. It is an error to set both a secret and its file.
+func (c *Configuration) readSecretFiles() error {
+	for _, s := range c.secrets() {
+		if s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("%s and %s_file are both set", s.field, s.field)
+		}
+		b, err := ioutil.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s_file: %w", s.field, err)
+		}
+		*s.value = strings.TrimRight(string(b), "\r\n")
+	}
+	return nil
+}
+
+// secrets lists all secrets of the configuration, including those of the remotes.
+func (c *Configuration) secrets() []secretFile {
+	secrets := []secretFile{
+		{"password", &c.Password, c.PasswordFile},
+		{"telegram.token", &c.Telegram.Token, c.Telegram.TokenFile},
+		{"vault.token", &c.Vault.Token, c.Vault.TokenFile},
+		{"vault.secret_id", &c.Vault.SecretID, c.Vault.SecretIDFile},
+	}
+	secrets = append(secrets, c.Auth.secretFiles("auth.")...)
+	secrets = append(secrets, c.S3.secretFiles("s3.")...)
+	for i := range c.Remotes {
+		r := &c.Remotes[i]
+		prefix := fmt.Sprintf("remotes[%d].", i)
+		secrets = append(secrets, secretFile{prefix + "password", &r.Password, r.PasswordFile})
+		secrets = append(secrets, r.Auth.secretFiles(prefix+"auth.")...)
+		secrets = append(secrets, r.S3.secretFiles(prefix+"s3.")...)
+	}
+	return secrets
+}
+
+func (a *AuthConfig) secretFiles(prefix string) []secretFile {
+	return []secretFile{
+		{prefix + "token", &a.Token, a.TokenFile},
+		{prefix + "secret", &a.Secret, a.SecretFile},
+		{prefix + "client_secret", &a.ClientSecret, a.ClientSecretFile},
+		{prefix + "refresh_token", &a.RefreshToken, a.RefreshTokenFile},
+	}
+}
+
+func (s *S3Config) secretFiles(prefix string) []secretFile {
+	return []secretFile{
+		{prefix + "secret_access_key", &s.SecretAccessKey, s.SecretAccessKeyFile},
+		{prefix + "session_token", &s.SessionToken, s.SessionTokenFile},
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	c := &Configuration{
+		PasswordFile: secret("password", "pass\n"),
+		Telegram:     TelegramConfig{TokenFile: secret("telegram", "123:token")},
+		S3:           S3Config{SecretAccessKeyFile: secret("s3", "key\r\n")},
+		Remotes: []RemoteConfig{
+			{Name: "two", Auth: AuthConfig{Type: AuthBearer, TokenFile: secret("bearer", "bearer\n")}},
+		},
+	}
+	if err := c.readSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "pass" || c.Telegram.Token != "123:token" || c.S3.SecretAccessKey != "key" {
+		t.Errorf("secrets weren't read: %+v", c)
+	}
+	if c.Remotes[0].Auth.Token != "bearer" {
+		t.Errorf("secret of remote wasn't read: %+v", c.Remotes[0].Auth)
+	}
+
+	c = &Configuration{Password: "pass", PasswordFile: secret("password", "other")}
+	if err := c.readSecretFiles(); err == nil {
+		t.Error("password and password_file were both accepted")
+	}
+	c = &Configuration{Auth: AuthConfig{SecretFile: filepath.Join(dir, "missing")}}
+	if err := c.readSecretFiles(); err == nil {
+		t.Error("missing file wasn't reported")
+	}
+}
@@ -16,20 +16,406 @@ limitations under the License.
 
 package config
 
+import (
+	"time"
+)
+
 type Configuration struct {
-	Remote      string         `mapstructure:"remote"`
+	// Name identifies the remote in reports and state files when there are several.
+	Name   string `mapstructure:"name"`
+	Remote string `mapstructure:"remote"`
+	// Scheme is how the remote is reached, SchemeHTTP (the default) for the HTTP API of a mediasync server,
+	// SchemeWebDAV, SchemeS3 or SchemeSFTP, in which case Remote is [user@]host:/path.
+	Scheme      string         `mapstructure:"scheme"`
+	SFTP        SFTPConfig     `mapstructure:"sftp"`
+	S3          S3Config       `mapstructure:"s3"`
 	UserName    string         `mapstructure:"username"`
 	Password    string         `mapstructure:"password"`
 	RootMapping []FilePath     `mapstructure:"root_mapping"`
 	Telegram    TelegramConfig `mapstructure:"telegram"`
+	HTTP        HTTPConfig     `mapstructure:"http"`
+	TLS         TLSConfig      `mapstructure:"tls"`
+	Auth        AuthConfig     `mapstructure:"auth"`
+	// Headers are added to every request to the remote and its mirrors, for proxies in front of it.
+	Headers map[string]string `mapstructure:"headers"`
+	// Keyring reads the password and the Telegram token from the keyring of the OS when they aren't set, see
+	// ReadKeyring.
+	Keyring bool `mapstructure:"keyring"`
+	// Vault reads the password and the Telegram token from HashiCorp Vault when they aren't set, see ReadVault.
+	Vault VaultConfig `mapstructure:"vault"`
+	// PasswordFile is a file the password is read from, like a mounted Docker or Kubernetes secret. All secrets
+	// have such a _file variant, see readSecretFiles.
+	PasswordFile string `mapstructure:"password_file"`
+	// HealthCheck is the path of an endpoint of the remote that is requested before the listing, the run is
+	// aborted if it doesn't answer with a 2xx status. There is no health check if it is empty.
+	HealthCheck string `mapstructure:"health_check"`
+	// DryRun only lists the files a run would transfer, without changing anything locally or on the remote.
+	DryRun bool `mapstructure:"dry_run"`
+	// Deadline is a wall clock time (15:04) by which the run has to be finished.
+	Deadline string `mapstructure:"deadline"`
+	// PrewarmConnections is the amount of connections to the remote that are opened before downloading.
+	PrewarmConnections int `mapstructure:"prewarm_connections"`
+	// DurableWrites fsyncs downloads and their directory before the remote file is deleted.
+	DurableWrites bool `mapstructure:"durable_writes"`
+	// StagingDir is where downloads are kept until they are complete, by default that is the destination.
+	StagingDir string `mapstructure:"staging_dir"`
+	// Journal is the path of the crash recovery journal, no journal is kept if it is empty.
+	Journal string `mapstructure:"journal"`
+	// IdleTimeout aborts downloads that didn't receive any data for this long, zero disables it.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// FileTimeout is the time a single file may take, including its retries and the delete, zero means no limit.
+	FileTimeout time.Duration `mapstructure:"file_timeout"`
+	// ChunkSize enables downloading files larger than it in segments of this size.
+	ChunkSize ByteSize `mapstructure:"chunk_size"`
+	// SegmentWorkers is the amount of segments of a file that are downloaded in parallel, one if it isn't set.
+	SegmentWorkers int `mapstructure:"segment_workers"`
+	// ResumeThreshold makes segmented downloads of at least this size resumable after a crash.
+	ResumeThreshold ByteSize `mapstructure:"resume_threshold"`
+	// DeltaTransfer updates local files that differ from the remote by only fetching the blocks that changed,
+	// if the remote provides the checksums of the blocks of the file.
+	DeltaTransfer bool `mapstructure:"delta_transfer"`
+	// Interval makes the client keep running, syncing every interval, instead of syncing once.
+	Interval time.Duration `mapstructure:"interval"`
+	// Watch keeps a connection to the remote and starts a run as soon as it announces a new file. The
+	// interval still applies, to catch files that were announced while the connection was down.
+	Watch bool `mapstructure:"watch"`
+	// WatchProtocol is how the remote announces files, WatchWebSocket (the default) or WatchSSE.
+	WatchProtocol string `mapstructure:"watch_protocol"`
+	// ListingCache is how long entries of the listing that were already seen are ignored in daemon mode.
+	ListingCache time.Duration `mapstructure:"listing_cache"`
+	// ListingPageSize makes the client get the listing in pages of this many files, for remotes that support it.
+	// Downloads start as soon as the first page is in.
+	ListingPageSize int `mapstructure:"listing_page_size"`
+	// ResultFile is where the result of the last run is written to as JSON, if set.
+	ResultFile string `mapstructure:"result_file"`
+	// DeferDeletes deletes the downloaded files from the remote in one go after all downloads are done.
+	DeferDeletes bool `mapstructure:"defer_deletes"`
+	// DeleteWorkers is the amount of deletes that run in parallel when they are deferred.
+	DeleteWorkers int `mapstructure:"delete_workers"`
+	// Transactional only deletes remote files if every file of the run was synchronised, it implies DeferDeletes.
+	Transactional bool `mapstructure:"transactional"`
+	// Mirrors are other base URLs of the remote that file bodies can be downloaded from, the listing and
+	// deletes always go to Remote.
+	Mirrors []string `mapstructure:"mirrors"`
+	// EncryptionKey is the path of a file with a hex encoded 256 bit key, downloads are encrypted with it if set.
+	EncryptionKey string `mapstructure:"encryption_key"`
+	// PreserveMetadata sets the metadata the server supplies for files as user.* extended attributes.
+	PreserveMetadata bool `mapstructure:"preserve_metadata"`
+	// Provenance stamps the source, sync time and run ID on every download as user.mediasync.* attributes.
+	Provenance bool `mapstructure:"provenance"`
+	// Concurrency is the amount of files that are downloaded in parallel, one if it isn't set.
+	Concurrency int `mapstructure:"concurrency"`
+	// MaxAttempts is how often a file is tried when it fails with a transient error, three times by default.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// RetryBackoff is the wait before the first retry, it doubles for every retry up to MaxBackoff.
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff"`
+	// MaxBandwidth limits the bandwidth all downloads share, zero means no limit.
+	MaxBandwidth Bandwidth `mapstructure:"max_bandwidth"`
+	// Include and Exclude are patterns for the files to synchronise, see the filter package for their syntax.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	// DeleteRemote, Completion, FileMode, DirMode, Owner and Group are the defaults of the mappings that don't set
+	// them, see FilePath.
+	DeleteRemote *bool  `mapstructure:"delete_remote"`
+	Completion   string `mapstructure:"completion"`
+	FileMode     string `mapstructure:"file_mode"`
+	DirMode      string `mapstructure:"dir_mode"`
+	Owner        string `mapstructure:"owner"`
+	Group        string `mapstructure:"group"`
+	// MinAge defers remote files that were modified less than this long ago, MaxAge defers files that were
+	// modified longer ago. Zero disables them.
+	MinAge time.Duration `mapstructure:"min_age"`
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MirrorState is where the downloads of mappings that keep remote files and the uploads of push mappings
+	// are tracked, so they aren't transferred again. Without it they are only tracked for as long as the client
+	// runs.
+	MirrorState string `mapstructure:"mirror_state"`
+	// MaxFilesPerRun limits the amount of files a run downloads, the rest is left for later runs.
+	MaxFilesPerRun int `mapstructure:"max_files_per_run"`
+	// MaxBytesPerRun stops a run from starting new downloads once it downloaded this much, the rest is left for
+	// later runs.
+	MaxBytesPerRun ByteSize `mapstructure:"max_bytes_per_run"`
+	// SkipExisting doesn't download files that already exist locally, compared by SkipExistingSize or
+	// SkipExistingHash. Their remote copies are completed like those of downloads.
+	SkipExisting string `mapstructure:"skip_existing"`
+	// DedupeIndex is where the sha-256 digests of downloads are tracked. Files the listing has a digest for are
+	// hardlinked to an earlier download with the same content instead of downloaded, if it is set.
+	DedupeIndex string `mapstructure:"dedupe_index"`
+	// DownloadOrder is the order of files with the same priority, one of the Order constants. By default it
+	// is the order of the listing.
+	DownloadOrder string `mapstructure:"download_order"`
+	// Remotes are further remotes that are synchronised in the same run, see Split.
+	Remotes []RemoteConfig `mapstructure:"remotes"`
+	// QueueState is where the files of a run are tracked, so a run that crashed or was interrupted is resumed
+	// by the next one. Without it every run starts from a fresh listing.
+	QueueState string `mapstructure:"queue_state"`
+	// StateSuffix is the name of a further remote, which is added to the names of the files it keeps next to the
+	// downloads, like resume state, see Split.
+	StateSuffix string `mapstructure:"-"`
+	// Notices are things about the configuration the user should know about, but that don't stop the client.
+	Notices []string `mapstructure:"-"`
 }
 
 type FilePath struct {
 	RemotePath string `mapstructure:"remote_path"`
 	LocalPath  string `mapstructure:"local_path"`
+	// Priority of files in this mapping, files with a higher priority are downloaded first.
+	Priority int `mapstructure:"priority"`
+	// DirPolicy is either DirPolicyCreate (the default) or DirPolicyExisting.
+	DirPolicy string `mapstructure:"dir_policy"`
+	// WriteStrategy is either WriteStrategyLocal (the default) or WriteStrategyNetwork.
+	WriteStrategy string `mapstructure:"write_strategy"`
+	// WriteThrough opens downloads with O_SYNC, so every write goes straight to the storage.
+	WriteThrough bool `mapstructure:"write_through"`
+	// Preallocate reserves the full size of downloads on disk before they start, where the filesystem supports
+	// it. That reduces fragmentation and fails early when the space runs out. It is ignored for
+	// WriteStrategyNetwork.
+	Preallocate bool `mapstructure:"preallocate"`
+	// Include and Exclude are patterns that apply to this mapping, on top of the global ones.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	// DeleteRemote deletes files from the remote once they are downloaded, it is true if it isn't set.
+	DeleteRemote *bool `mapstructure:"delete_remote"`
+	// Completion is what happens to remote files once they are downloaded, CompletionMove, CompletionCopy or
+	// CompletionArchive. It takes precedence over DeleteRemote.
+	Completion string `mapstructure:"completion"`
+	// MinFreeSpace defers downloads that would leave less free space than this on the destination.
+	MinFreeSpace ByteSize `mapstructure:"min_free_space"`
+	// MaxBandwidth limits the bandwidth the downloads of this mapping share, on top of the global limit.
+	MaxBandwidth Bandwidth `mapstructure:"max_bandwidth"`
+	// Concurrency limits the amount of files of this mapping that are downloaded in parallel, on top of the
+	// global concurrency. Zero means no limit of its own.
+	Concurrency int `mapstructure:"concurrency"`
+	// Direction is either DirectionPull (the default) or DirectionPush.
+	Direction string `mapstructure:"direction"`
+	// Conflict is what happens when the local file already exists: ConflictOverwrite (the default),
+	// ConflictSkip, ConflictRename or ConflictKeepNewer.
+	Conflict string `mapstructure:"conflict"`
+	// FileMode and DirMode are octal modes, like "0644", for the files and directories the mapping creates.
+	FileMode string `mapstructure:"file_mode"`
+	DirMode  string `mapstructure:"dir_mode"`
+	// Owner and Group are names or numeric IDs the files and directories the mapping creates are given.
+	Owner string `mapstructure:"owner"`
+	Group string `mapstructure:"group"`
+}
+
+const (
+	// DirPolicyCreate creates any missing local directories.
+	DirPolicyCreate = "create"
+	// DirPolicyExisting only allows files in local directories that already exist.
+	DirPolicyExisting = "existing"
+
+	// WriteStrategyLocal is meant for local filesystems.
+	WriteStrategyLocal = "local"
+	// WriteStrategyNetwork is meant for NFS and SMB mounts, it uses larger writes and retries EIO errors.
+	WriteStrategyNetwork = "network"
+
+	// DirectionPull downloads the files of the mapping from the remote.
+	DirectionPull = "pull"
+	// DirectionPush uploads new files in the local path of the mapping to the remote.
+	DirectionPush = "push"
+
+	// CompletionMove deletes downloaded files from the remote.
+	CompletionMove = "move"
+	// CompletionCopy leaves downloaded files on the remote, they aren't downloaded again.
+	CompletionCopy = "copy"
+	// CompletionArchive asks the remote to archive downloaded files, which takes them out of the listing.
+	CompletionArchive = "archive"
+
+	// OrderSmallestFirst downloads small files before large ones.
+	OrderSmallestFirst = "smallest-first"
+	// OrderLargestFirst downloads large files before small ones.
+	OrderLargestFirst = "largest-first"
+	// OrderOldestFirst downloads the files with the oldest modification time first.
+	OrderOldestFirst = "oldest-first"
+	// OrderAlphabetical downloads files in the order of their remote path.
+	OrderAlphabetical = "alphabetical"
+
+	// ConflictOverwrite replaces existing local files.
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip leaves existing local files alone, the remote file stays on the remote.
+	ConflictSkip = "skip"
+	// ConflictRename downloads to a free name, with a numbered suffix before the extension.
+	ConflictRename = "rename"
+	// ConflictKeepNewer only replaces existing local files that are older than the remote file.
+	ConflictKeepNewer = "keep-newer"
+
+	// SkipExistingSize considers local files with the same size as the remote file identical.
+	SkipExistingSize = "size"
+	// SkipExistingHash also compares the sha-256 digest, which the server has to supply.
+	SkipExistingHash = "hash"
+)
+
+// HTTPConfig configures the connections to the remote.
+type HTTPConfig struct {
+	// ConnectTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout limit how long it may take to set up a
+	// connection and to get the headers of a response, IdleConnTimeout is how long unused connections are kept.
+	// Zero means the default of the engine.
+	ConnectTimeout        time.Duration `mapstructure:"connect_timeout"`
+	TLSHandshakeTimeout   time.Duration `mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `mapstructure:"idle_conn_timeout"`
+	// ProxyURL is the HTTP or SOCKS5 (socks5://) proxy all requests go through, with the credentials for the proxy
+	// in its user info. Without it the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+	ProxyURL string `mapstructure:"proxy_url"`
+	// DisableCompression stops asking for a zstd or gzip compressed listing, CompressDownloads asks for compressed
+	// file bodies as well, which only helps for files that compress well. Resumed downloads are never compressed.
+	DisableCompression bool `mapstructure:"disable_compression"`
+	CompressDownloads  bool `mapstructure:"compress_downloads"`
+	// CompressPatterns asks for compressed bodies of the files that match one of these patterns only, like
+	// subtitles and nfo files. The patterns work like those of the filters.
+	CompressPatterns []string `mapstructure:"compress_patterns"`
+	// MaxIdleConnsPerHost is how many idle connections to the remote are kept for reuse, by default enough for
+	// all parallel transfers.
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// KeepAlive is the interval of TCP keep-alive probes, 30s by default and negative to disable them.
+	KeepAlive time.Duration `mapstructure:"keep_alive"`
+	// DisableKeepAlives uses a new connection for every request, DisableHTTP2 sticks to HTTP/1.1 for TLS remotes.
+	DisableKeepAlives bool `mapstructure:"disable_keep_alives"`
+	DisableHTTP2      bool `mapstructure:"disable_http2"`
+	// IPFamily restricts connections to IPv4 or IPv6 addresses, by default both are used.
+	IPFamily string `mapstructure:"ip_family"`
+	// DNSServer is the address of the DNS server host names are resolved with, instead of the one of the system.
+	DNSServer string `mapstructure:"dns_server"`
+	// Hosts pins host names to addresses, which are used without resolving the names.
+	Hosts map[string]string `mapstructure:"hosts"`
+}
+
+const (
+	// IPv4 only connects to IPv4 addresses.
+	IPv4 = "ipv4"
+	// IPv6 only connects to IPv6 addresses.
+	IPv6 = "ipv6"
+)
+
+// AuthConfig configures how the client authenticates to the remote.
+type AuthConfig struct {
+	// Type is AuthBasic (the default) or AuthDigest, which use UserName and Password, AuthBearer, AuthAPIKey,
+	// AuthOAuth2 or AuthHMAC.
+	Type string `mapstructure:"type"`
+	// Token is the bearer token or API key.
+	Token string `mapstructure:"token"`
+	// Header is the header the API key is sent in, X-API-Key by default.
+	Header string `mapstructure:"header"`
+	// Secret is the key requests are signed with for AuthHMAC.
+	Secret string `mapstructure:"secret"`
+	// TokenURL is the OAuth2 token endpoint, ClientID and ClientSecret are the credentials of the client.
+	TokenURL     string   `mapstructure:"token_url"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	Scopes       []string `mapstructure:"scopes"`
+	// RefreshToken selects the refresh token flow, without it the client credentials flow is used.
+	RefreshToken string `mapstructure:"refresh_token"`
+	// TokenCache is a file the access token is kept in between runs.
+	TokenCache string `mapstructure:"token_cache"`
+
+	// The secrets above can be read from files instead.
+	TokenFile        string `mapstructure:"token_file"`
+	SecretFile       string `mapstructure:"secret_file"`
+	ClientSecretFile string `mapstructure:"client_secret_file"`
+	RefreshTokenFile string `mapstructure:"refresh_token_file"`
+}
+
+const (
+	// AuthBasic uses basic auth with the user name and password.
+	AuthBasic = "basic"
+	// AuthDigest uses digest auth with the user name and password.
+	AuthDigest = "digest"
+	// AuthBearer sends the token as a bearer token.
+	AuthBearer = "bearer"
+	// AuthAPIKey sends the token in an API key header.
+	AuthAPIKey = "api_key"
+	// AuthOAuth2 sends access tokens from an OAuth2 token endpoint as bearer tokens.
+	AuthOAuth2 = "oauth2"
+	// AuthHMAC signs requests with a shared secret instead of sending credentials.
+	AuthHMAC = "hmac"
+)
+
+const (
+	// SchemeHTTP pulls files from the HTTP API of a mediasync server.
+	SchemeHTTP = "http"
+	// SchemeSFTP pulls files from an SSH server with the sftp subsystem.
+	SchemeSFTP = "sftp"
+	// SchemeWebDAV pulls files from a WebDAV share, Remote is the URL of the shared directory.
+	SchemeWebDAV = "webdav"
+	// SchemeS3 pulls objects from an S3 compatible bucket, Remote is the endpoint with the bucket and an optional
+	// prefix as path, as in path style addressing.
+	SchemeS3 = "s3"
+)
+
+// S3Config holds the credentials for an S3 remote, the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables are used for the ones that aren't set.
+type S3Config struct {
+	// Region is the region requests are signed for, us-east-1 by default.
+	Region          string `mapstructure:"region"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	SessionToken    string `mapstructure:"session_token"`
+
+	// The secrets above can be read from files instead.
+	SecretAccessKeyFile string `mapstructure:"secret_access_key_file"`
+	SessionTokenFile    string `mapstructure:"session_token_file"`
+}
+
+// SFTPConfig configures how the ssh command connects to an SFTP remote, anything else comes from the ssh
+// configuration of the user.
+type SFTPConfig struct {
+	// Port is the port of the SSH server, the one of the ssh configuration if it isn't set.
+	Port int `mapstructure:"port"`
+	// IdentityFile is the private key to log in with.
+	IdentityFile string `mapstructure:"identity_file"`
+	// Command is the ssh command to run, "ssh" if it isn't set.
+	Command string `mapstructure:"command"`
+}
+
+const (
+	// WatchWebSocket watches for new files over a WebSocket connection to /notifications.
+	WatchWebSocket = "websocket"
+	// WatchSSE subscribes to the file-added server-sent events of /events.
+	WatchSSE = "sse"
+)
+
+// TLSConfig configures TLS for the connections to the remote.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files with a client certificate and its key, for servers that require one. They
+	// are loaded again when they change.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// CAFile is a PEM file with certificate authorities that are trusted on top of those of the system.
+	CAFile string `mapstructure:"ca_file"`
+	// InsecureSkipVerify doesn't verify the certificate of the remote at all, only use it for testing.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
 }
 
 type TelegramConfig struct {
 	Token  string `mapstructure:"token"`
 	ChatID int64  `mapstructure:"chat_id"`
+	// TokenFile is a file the token is read from instead.
+	TokenFile string `mapstructure:"token_file"`
+}
+
+// VaultConfig is the secret in the KV version 2 secrets engine of HashiCorp Vault that holds the password and the
+// Telegram token, it is only used when Path is set.
+type VaultConfig struct {
+	// Address is the URL of the Vault server, VAULT_ADDR by default.
+	Address   string `mapstructure:"address"`
+	Namespace string `mapstructure:"namespace"`
+	// CAFile is a PEM file with certificate authorities that are trusted on top of those of the system.
+	CAFile string `mapstructure:"ca_file"`
+	// Token is used for the requests, VAULT_TOKEN by default, unless RoleID is set to log in with AppRole.
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+	// RoleID and SecretID log in with the AppRole auth method mounted at AppRoleMount, "approle" by default.
+	RoleID       string `mapstructure:"role_id"`
+	SecretID     string `mapstructure:"secret_id"`
+	SecretIDFile string `mapstructure:"secret_id_file"`
+	AppRoleMount string `mapstructure:"approle_mount"`
+	// Mount is where the KV engine is mounted, "secret" by default, Path is the secret in it.
+	Mount string `mapstructure:"mount"`
+	Path  string `mapstructure:"path"`
+	// PasswordKey and TelegramTokenKey are the fields of the secret, "password" and "telegram_token" by default.
+	// The password of a further remote is in the field of PasswordKey, a dot and the name of the remote.
+	PasswordKey      string `mapstructure:"password_key"`
+	TelegramTokenKey string `mapstructure:"telegram_token_key"`
 }
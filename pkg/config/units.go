@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// ByteSize is an amount of bytes, in the configuration it can be written as a plain number or with a unit,
+// like "64MB" or "1.5GiB".
+type ByteSize int64
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a size like "64MB", units are case insensitive.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid unit in size %q", s)
+	}
+	return ByteSize(n * unit), nil
+}
+
+// String formats b with the largest decimal unit that keeps it at least one, like "34.2GB".
+func (b ByteSize) String() string {
+	units := []string{"TB", "GB", "MB", "kB"}
+	for i, u := range units {
+		size := byteUnits[strings.ToLower(u)]
+		if float64(b) >= size {
+			return strconv.FormatFloat(float64(b)/size, 'f', 1, 64) + units[i]
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// Bandwidth is an amount of bytes per second, in the configuration it is written as a size with an optional
+// "/s" suffix, like "5MB/s".
+type Bandwidth int64
+
+// ParseBandwidth parses a bandwidth like "5MB/s".
+func ParseBandwidth(s string) (Bandwidth, error) {
+	b, err := ParseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return Bandwidth(b), nil
+}
+
+// decodeHook makes viper parse the custom types of the configuration.
+func decodeHook() viper.DecoderConfigOption {
+	return viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		jsonHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		func(from, to reflect.Type, data interface{}) (interface{}, error) {
+			if from.Kind() != reflect.String {
+				return data, nil
+			}
+			switch to {
+			case reflect.TypeOf(ByteSize(0)):
+				return ParseByteSize(data.(string))
+			case reflect.TypeOf(Bandwidth(0)):
+				return ParseBandwidth(data.(string))
+			default:
+				return data, nil
+			}
+		},
+	))
+}
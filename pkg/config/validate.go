@@ -0,0 +1,194 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// telegramToken is the format of the tokens of the Telegram bot API, the ID of the bot and a secret.
+var telegramToken = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]+$`)
+
+// Problem is an invalid setting, Field is its key in the configuration file.
+type Problem struct {
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Field + ": " + p.Message
+}
+
+// ValidationError lists all problems of the configuration of a remote.
+type ValidationError struct {
+	// Remote is the name of the remote, empty for the top level one.
+	Remote   string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration")
+	if e.Remote != "" {
+		fmt.Fprintf(&b, " of remote %s", e.Remote)
+	}
+	b.WriteString(":")
+	for _, p := range e.Problems {
+		b.WriteString("\n\t" + p.String())
+	}
+	return b.String()
+}
+
+// Validate checks the settings the client can't run without, so mistakes show up all at once before the run
+// instead of as errors halfway through it. It returns a *ValidationError if there are problems.
+func (c *Configuration) Validate() error {
+	v := &ValidationError{Remote: c.Name}
+	v.checkOneOf("scheme", c.Scheme, SchemeHTTP, SchemeSFTP, SchemeWebDAV, SchemeS3)
+	v.checkRemote("remote", c.Remote, c.Scheme)
+	for i, m := range c.Mirrors {
+		v.checkRemote(fmt.Sprintf("mirrors[%d]", i), m, c.Scheme)
+	}
+	if c.Password != "" && c.UserName == "" && (c.Auth.Type == "" || c.Auth.Type == AuthBasic ||
+		c.Auth.Type == AuthDigest) {
+		v.add("password", "is set without a username")
+	}
+	v.checkOneOf("auth.type", c.Auth.Type, AuthBasic, AuthDigest, AuthBearer, AuthAPIKey, AuthOAuth2, AuthHMAC)
+	v.checkOneOf("watch_protocol", c.WatchProtocol, WatchWebSocket, WatchSSE)
+	v.checkOneOf("download_order", c.DownloadOrder,
+		OrderSmallestFirst, OrderLargestFirst, OrderOldestFirst, OrderAlphabetical)
+	v.checkOneOf("skip_existing", c.SkipExisting, SkipExistingSize, SkipExistingHash)
+	v.checkOneOf("completion", c.Completion, CompletionMove, CompletionCopy, CompletionArchive)
+
+	if len(c.RootMapping) == 0 {
+		v.add("root_mapping", "needs at least one mapping")
+	}
+	for i, m := range c.RootMapping {
+		field := fmt.Sprintf("root_mapping[%d]", i)
+		if m.RemotePath == "" {
+			v.add(field+".remote_path", "must be set")
+		}
+		v.checkOneOf(field+".direction", m.Direction, DirectionPull, DirectionPush)
+		// Mappings inherit the top level completion, which is already reported.
+		if m.Completion != c.Completion {
+			v.checkOneOf(field+".completion", m.Completion, CompletionMove, CompletionCopy, CompletionArchive)
+		}
+		v.checkOneOf(field+".conflict", m.Conflict, ConflictOverwrite, ConflictSkip, ConflictRename, ConflictKeepNewer)
+		v.checkOneOf(field+".dir_policy", m.DirPolicy, DirPolicyCreate, DirPolicyExisting)
+		v.checkOneOf(field+".write_strategy", m.WriteStrategy, WriteStrategyLocal, WriteStrategyNetwork)
+		if m.LocalPath == "" {
+			v.add(field+".local_path", "must be set")
+			continue
+		}
+		if err := checkLocalPath(m); err != nil {
+			v.add(field+".local_path", err.Error())
+		}
+	}
+
+	switch {
+	case c.Telegram.Token == "":
+		v.add("telegram.token", "must be set")
+	case !telegramToken.MatchString(c.Telegram.Token):
+		v.add("telegram.token", "isn't a bot token, those look like 123456789:AAE...")
+	}
+	if c.Telegram.ChatID == 0 {
+		v.add("telegram.chat_id", "must be set")
+	}
+
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+func (v *ValidationError) add(field, msg string) {
+	v.Problems = append(v.Problems, Problem{Field: field, Message: msg})
+}
+
+// checkOneOf checks that value is empty, for the default, or one of values.
+func (v *ValidationError) checkOneOf(field, value string, values ...string) {
+	if value == "" {
+		return
+	}
+	for _, allowed := range values {
+		if value == allowed {
+			return
+		}
+	}
+	v.add(field, fmt.Sprintf("is %q, expected one of %s", value, strings.Join(values, ", ")))
+}
+
+// checkRemote checks that remote is a URL the scheme can use, SFTP remotes aren't URLs.
+func (v *ValidationError) checkRemote(field, remote, scheme string) {
+	if remote == "" {
+		v.add(field, "must be set")
+		return
+	}
+	if scheme == SchemeSFTP {
+		return
+	}
+	u, err := url.Parse(remote)
+	if err != nil {
+		v.add(field, fmt.Sprintf("isn't a valid URL: %v", err))
+		return
+	}
+	switch {
+	case u.Scheme == "unix" && (scheme == "" || scheme == SchemeHTTP):
+		if u.Path == "" {
+			v.add(field, "needs the path of the socket, like unix:///run/mediasync.sock")
+		}
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.add(field, fmt.Sprintf("has to be an http or https URL, not %q", remote))
+	case u.Host == "":
+		v.add(field, fmt.Sprintf("has no host: %q", remote))
+	}
+}
+
+// checkLocalPath checks that the client can write to the local path of a pull mapping, or read that of a push
+// mapping. Missing directories are fine if the mapping creates them.
+func checkLocalPath(m FilePath) error {
+	fi, err := os.Stat(m.LocalPath)
+	switch {
+	case err == nil && !fi.IsDir():
+		return fmt.Errorf("%s isn't a directory", m.LocalPath)
+	case err != nil && !os.IsNotExist(err):
+		return err
+	case err != nil && (m.Direction == DirectionPush || m.DirPolicy == DirPolicyExisting):
+		return fmt.Errorf("%s doesn't exist", m.LocalPath)
+	case m.Direction == DirectionPush:
+		return nil
+	}
+
+	dir := m.LocalPath
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := ioutil.TempFile(dir, ".mediasync-check")
+	if err != nil {
+		return fmt.Errorf("%s isn't writable", dir)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Configuration{
+		Remote: "https://dl.example.org",
+		RootMapping: []FilePath{
+			{RemotePath: "/tv", LocalPath: filepath.Join(dir, "media", "tv")},
+			{RemotePath: "/push", LocalPath: dir, Direction: DirectionPush},
+		},
+		Telegram: TelegramConfig{Token: "123456789:AAE-abc_def", ChatID: 42},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("valid configuration was rejected: %v", err)
+	}
+	c.Auth.Type = "baerer"
+	var v *ValidationError
+	if err := c.Validate(); !errors.As(err, &v) || len(v.Problems) != 1 || v.Problems[0].Field != "auth.type" {
+		t.Errorf("unknown auth type wasn't rejected: %v", err)
+	}
+
+	c = &Configuration{
+		Name:          "two",
+		Scheme:        "ftp",
+		Remote:        "dl.example.org/files",
+		WatchProtocol: "mqtt",
+		DownloadOrder: "newest-first",
+		SkipExisting:  "mtime",
+		Completion:    "delete",
+		Mirrors:       []string{"https://"},
+		Password:      "pass",
+		RootMapping: []FilePath{
+			{RemotePath: "/tv", LocalPath: file, Completion: "delete", Conflict: "replace"},
+			{RemotePath: "/movies", LocalPath: filepath.Join(dir, "missing"), DirPolicy: DirPolicyExisting},
+			{RemotePath: "/music", Completion: "archve", DirPolicy: "existng", WriteStrategy: "nfs"},
+			{RemotePath: "/upload", LocalPath: dir, Direction: "upload"},
+		},
+		Telegram: TelegramConfig{Token: "secret"},
+	}
+	if err := c.Validate(); !errors.As(err, &v) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	expected := []string{
+		"scheme", "remote", "mirrors[0]", "password", "watch_protocol", "download_order", "skip_existing",
+		"completion", "root_mapping[0].conflict", "root_mapping[0].local_path", "root_mapping[1].local_path",
+		"root_mapping[2].completion", "root_mapping[2].dir_policy", "root_mapping[2].write_strategy",
+		"root_mapping[2].local_path", "root_mapping[3].direction", "telegram.token",
+		"telegram.chat_id",
+	}
+	if len(v.Problems) != len(expected) {
+		t.Fatalf("unexpected problems: %v", v)
+	}
+	for i, p := range v.Problems {
+		if p.Field != expected[i] {
+			t.Errorf("problem %d is about %s, expected %s", i, p.Field, expected[i])
+		}
+	}
+	if v.Remote != "two" {
+		t.Errorf("unexpected remote %q", v.Remote)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/vault"
+)
+
+// vaultTimeout limits the requests to Vault.
+const vaultTimeout = 30 * time.Second
+
+// ReadVault fills in the passwords and the Telegram token that aren't set from the secret in Vault, if its path is
+// set. Vault is only asked when there is a secret to fill in.
+func (c *Configuration) ReadVault(ctx context.Context) error {
+	v := c.Vault
+	if v.Path == "" {
+		return nil
+	}
+	passwordKey := orDefault(v.PasswordKey, "password")
+	wants := make(map[string]*string)
+	if c.Password == "" && c.Remote != "" && c.UsesPassword() {
+		wants[passwordKey] = &c.Password
+	}
+	if c.Telegram.Token == "" {
+		wants[orDefault(v.TelegramTokenKey, "telegram_token")] = &c.Telegram.Token
+	}
+	for i := range c.Remotes {
+		r := &c.Remotes[i]
+		authType := r.Auth.Type
+		if authType == "" {
+			authType = c.Auth.Type
+		}
+		if r.Password == "" && usesPassword(r.UserName, authType) {
+			wants[passwordKey+"."+r.Name] = &r.Password
+		}
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+
+	cl, err := v.client(ctx)
+	if err != nil {
+		return err
+	}
+	mount := orDefault(v.Mount, "secret")
+	data, err := cl.ReadKV(ctx, mount, v.Path)
+	if errors.Is(err, vault.ErrNotFound) {
+		return fmt.Errorf("vault has no secret %s in %s", v.Path, mount)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read secret from vault: %w", err)
+	}
+	for key, p := range wants {
+		s, ok := data[key].(string)
+		if !ok {
+			return fmt.Errorf("secret %s in vault has no text field %s", v.Path, key)
+		}
+		*p = s
+	}
+	return nil
+}
+
+// client returns a Vault client that is logged in with AppRole or has the token.
+func (v VaultConfig) client(ctx context.Context) (*vault.Client, error) {
+	addr := orDefault(v.Address, os.Getenv("VAULT_ADDR"))
+	if addr == "" {
+		return nil, errors.New("vault.address or VAULT_ADDR must be set to read secrets from vault")
+	}
+	hc := &http.Client{Timeout: vaultTimeout}
+	if v.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(v.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read vault CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in vault CA file %s", v.CAFile)
+		}
+		hc.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	cl := vault.New(addr, v.Namespace, hc)
+	if v.RoleID != "" {
+		if err := cl.LoginAppRole(ctx, orDefault(v.AppRoleMount, "approle"), v.RoleID, v.SecretID); err != nil {
+			return nil, err
+		}
+		return cl, nil
+	}
+	token := orDefault(v.Token, os.Getenv("VAULT_TOKEN"))
+	if token == "" {
+		return nil, errors.New("vault.token, VAULT_TOKEN or vault.role_id must be set to read secrets from vault")
+	}
+	cl.SetToken(token)
+	return cl, nil
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
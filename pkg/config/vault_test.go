@@ -0,0 +1,76 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadVault(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v1/kv/data/mediasync" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"data": {"password": "pass", "password.two": "other", "bot": "123:abc"}}}`))
+	}))
+	defer srv.Close()
+
+	vc := VaultConfig{Address: srv.URL, Token: "token", Mount: "kv", Path: "mediasync", TelegramTokenKey: "bot"}
+	c := &Configuration{
+		Remote:   "https://dl.example.org",
+		UserName: "user",
+		Vault:    vc,
+		Remotes: []RemoteConfig{
+			{Name: "two", UserName: "user"},
+			{Name: "three", UserName: "user", Password: "set"},
+			{Name: "four", Auth: AuthConfig{Type: AuthBearer}},
+		},
+	}
+	if err := c.ReadVault(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "pass" || c.Telegram.Token != "123:abc" {
+		t.Errorf("secrets weren't read from vault: %+v", c)
+	}
+	if c.Remotes[0].Password != "other" || c.Remotes[1].Password != "set" {
+		t.Errorf("passwords of remotes weren't read from vault: %+v", c.Remotes)
+	}
+
+	c = &Configuration{Remote: "https://dl.example.org", Password: "set", Telegram: TelegramConfig{Token: "1:a"}}
+	c.Vault = vc
+	if err := c.ReadVault(context.Background()); err != nil || requests != 1 {
+		t.Errorf("vault was asked for nothing: %v, %d requests", err, requests)
+	}
+
+	c = &Configuration{Remote: "https://dl.example.org", UserName: "user", Telegram: TelegramConfig{Token: "1:a"}}
+	c.Vault = vc
+	c.Vault.PasswordKey = "missing"
+	if err := c.ReadVault(context.Background()); err == nil {
+		t.Error("missing field in vault was accepted")
+	}
+	c.Vault.PasswordKey = ""
+	c.Vault.Path = "other"
+	if err := c.ReadVault(context.Background()); err == nil {
+		t.Error("missing secret in vault was accepted")
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crypt encrypts files at rest, and secrets in the configuration, with AES-256-GCM.
+//
+// Files are encrypted in chunks, so they can be streamed. Every chunk is sealed with a nonce made of a random
+// prefix, the number of the chunk and a flag marking the last chunk, which protects against chunks being
+// reordered, dropped or the file being truncated.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+const (
+	// Ext is appended to the names of encrypted files.
+	Ext = ".enc"
+	// KeySize is the size of the key in bytes.
+	KeySize = 32
+
+	chunkSize = 64 << 10
+	// gcmOverhead is the size of the authentication tag added to every chunk.
+	gcmOverhead = 16
+	prefixLen   = 7
+	counterAt   = prefixLen
+	lastAt      = prefixLen + 4
+)
+
+var (
+	magic = []byte("MSENC\x00\x01\n")
+
+	// ErrInvalid is returned for data that wasn't encrypted with the key, or was tampered with.
+	ErrInvalid = errors.New("invalid or corrupted encrypted data")
+)
+
+// LoadKey reads a hex encoded key from the file at p.
+func LoadKey(p string) ([]byte, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode key in %s: %w", p, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key in %s has %d bytes, expected %d", p, len(key), KeySize)
+	}
+	return key, nil
+}
+
+// EncryptedSize returns the size of a file of n bytes once it is encrypted.
+func EncryptedSize(n int64) int64 {
+	chunks := (n + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(len(magic)) + prefixLen + n + chunks*int64(gcmOverhead)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonces generates the nonces for the chunks of a file.
+type nonces struct {
+	nonce   [12]byte
+	counter uint32
+}
+
+func (n *nonces) next(last bool) ([]byte, error) {
+	if n.counter == math.MaxUint32 {
+		return nil, errors.New("file is too large to encrypt")
+	}
+	binary.BigEndian.PutUint32(n.nonce[counterAt:], n.counter)
+	n.nonce[lastAt] = 0
+	if last {
+		n.nonce[lastAt] = 1
+	}
+	n.counter++
+	return n.nonce[:], nil
+}
+
+// Writer encrypts everything written to it, Close has to be called to write the last chunk.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonces nonces
+	buf    []byte
+}
+
+// NewWriter returns a Writer that writes the encrypted data to w.
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &Writer{w: w, aead: aead, buf: make([]byte, 0, chunkSize+aead.Overhead())}
+	if _, err := rand.Read(cw.nonces.nonce[:prefixLen]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, magic...), cw.nonces.nonce[:prefixLen]...)); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as it might be the last one.
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last chunk, it doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.seal(true)
+}
+
+func (w *Writer) seal(last bool) error {
+	nonce, err := w.nonces.next(last)
+	if err != nil {
+		return err
+	}
+	out := w.aead.Seal(w.buf[:0], nonce, w.buf, nil)
+	w.buf = w.buf[:0]
+	_, err = w.w.Write(out)
+	return err
+}
+
+// Reader decrypts data written by a Writer.
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	nonces nonces
+	buf    []byte
+	plain  []byte
+	done   bool
+}
+
+// NewReader returns a Reader that decrypts the data in r.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &Reader{r: bufio.NewReader(r), aead: aead, buf: make([]byte, chunkSize+aead.Overhead())}
+	header := make([]byte, len(magic)+prefixLen)
+	if _, err := io.ReadFull(cr.r, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return nil, fmt.Errorf("not an encrypted file: %w", ErrInvalid)
+	}
+	copy(cr.nonces.nonce[:prefixLen], header[len(magic):])
+	return cr, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next chunk.
+func (r *Reader) open() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("file is truncated: %w", ErrInvalid)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one if nothing follows it.
+		if _, err := r.r.Peek(1); errors.Is(err, io.EOF) {
+			r.done = true
+		}
+	}
+
+	nonce, err := r.nonces.next(r.done)
+	if err != nil {
+		return err
+	}
+	r.plain, err = r.aead.Open(r.buf[:0], nonce, r.buf[:n], nil)
+	if err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
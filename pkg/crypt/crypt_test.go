@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		if _, err := rand.Read(plain); err != nil {
+			t.Fatal(err)
+		}
+
+		enc := encrypt(t, key, plain)
+		if int64(len(enc)) != EncryptedSize(int64(size)) {
+			t.Errorf("%d bytes: encrypted to %d bytes, expected %d", size, len(enc), EncryptedSize(int64(size)))
+		}
+
+		got, err := decrypt(key, enc)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: round trip failed: %v", size, err)
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	key := make([]byte, KeySize)
+	enc := encrypt(t, key, make([]byte, 2*chunkSize+1))
+
+	flipped := append([]byte{}, enc...)
+	flipped[len(flipped)/2] ^= 1
+	truncated := enc[:len(magic)+prefixLen+chunkSize+gcmOverhead]
+
+	for name, data := range map[string][]byte{"flipped": flipped, "truncated": truncated} {
+		if _, err := decrypt(key, data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ValuePrefix marks encrypted configuration values.
+	ValuePrefix = "enc:"
+
+	saltLen = 16
+	// valueIterations is the PBKDF2 work factor for the keys of values, which matters for passphrases.
+	valueIterations = 100000
+)
+
+// IsSealed tells whether v is a value sealed by SealValue.
+func IsSealed(v string) bool {
+	return strings.HasPrefix(v, ValuePrefix)
+}
+
+// SealValue encrypts the configuration value v with a key derived from secret, which is a key or a passphrase.
+// The result is ValuePrefix followed by the base64 encoded salt, nonce and sealed value.
+func SealValue(secret []byte, v string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(pbkdf2(secret, salt, valueIterations, KeySize))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b := append(salt, nonce...)
+	b = aead.Seal(b, nonce, []byte(v), nil)
+	return ValuePrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// OpenValue decrypts a value sealed by SealValue with the same secret.
+func OpenValue(secret []byte, v string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, ValuePrefix))
+	if err != nil {
+		return "", fmt.Errorf("couldn't decode encrypted value: %w", err)
+	}
+	if len(b) < saltLen {
+		return "", ErrInvalid
+	}
+	aead, err := newAEAD(pbkdf2(secret, b[:saltLen], valueIterations, KeySize))
+	if err != nil {
+		return "", err
+	}
+	b = b[saltLen:]
+	if len(b) < aead.NonceSize() {
+		return "", ErrInvalid
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(plain), nil
+}
+
+// pbkdf2 derives a key of keyLen bytes from password with PBKDF2-HMAC-SHA256, as in RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+	key := make([]byte, 0, (keyLen+size-1)/size*size)
+	var counter [4]byte
+	u := make([]byte, size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+		t := key[len(key)-size:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return key[:keyLen]
+}
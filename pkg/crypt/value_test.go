@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 4096, 40))
+	want := "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134af7ad98c1b458ce3f"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSealValue(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	sealed, err := SealValue(secret, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) {
+		t.Fatalf("%q doesn't have the %q prefix", sealed, ValuePrefix)
+	}
+	again, err := SealValue(secret, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing the same value twice gave the same result")
+	}
+	plain, err := OpenValue(secret, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "hunter2" {
+		t.Errorf("got %q, want %q", plain, "hunter2")
+	}
+	if _, err := OpenValue([]byte("wrong"), sealed); !errors.Is(err, ErrInvalid) {
+		t.Errorf("opening with the wrong secret gave %v, want %v", err, ErrInvalid)
+	}
+	if _, err := OpenValue(secret, ValuePrefix+"c2hvcnQ="); !errors.Is(err, ErrInvalid) {
+		t.Errorf("opening a short value gave %v, want %v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// checkAge defers files that are younger than the minimum age, as the server may still be writing them, or older
+// than the maximum age. Files whose modification time is unknown pass.
+func (e *Engine) checkAge(ctx context.Context, f wp) error {
+	if e.c.MinAge <= 0 && e.c.MaxAge <= 0 {
+		return nil
+	}
+
+	modified := f.Modified
+	if modified.IsZero() {
+		modified = e.remoteModified(ctx, f.WebPath)
+	}
+	if modified.IsZero() {
+		return nil
+	}
+
+	age := time.Since(modified)
+	if e.c.MinAge > 0 && age < e.c.MinAge {
+		return fmt.Errorf("%s is only %s old: %w", f.WebPath, age.Round(time.Second), errAge)
+	}
+	if e.c.MaxAge > 0 && age > e.c.MaxAge {
+		return fmt.Errorf("%s is older than %s: %w", f.WebPath, e.c.MaxAge, errAge)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// defaultAPIKeyHeader is the header API keys are sent in if the configuration doesn't name one.
+const defaultAPIKeyHeader = "X-API-Key"
+
+// authorize adds the configured credentials to req.
+func (e *Engine) authorize(req *http.Request) error {
+	if e.c.Scheme == config.SchemeS3 {
+		e.signS3(req)
+		return nil
+	}
+	switch e.c.Auth.Type {
+	case config.AuthOAuth2:
+		token, err := e.tokens.accessToken(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case config.AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+e.c.Auth.Token)
+	case config.AuthHMAC:
+		signHMAC(req, e.c.Auth.Secret, time.Now())
+	case config.AuthDigest:
+		return e.digest.authorize(req)
+	case config.AuthAPIKey:
+		header := e.c.Auth.Header
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		req.Header.Set(header, e.c.Auth.Token)
+	case "", config.AuthBasic:
+		req.SetBasicAuth(e.c.UserName, e.c.Password)
+	default:
+		// Sending the password to a remote that expects something else would leak it.
+		return fmt.Errorf("%w: unknown auth type %q", ErrAuth, e.c.Auth.Type)
+	}
+	return nil
+}
+
+// signHMAC signs the method, path and the time of req with secret. The signature is the hex encoded HMAC-SHA256 of
+// the three separated by newlines, it is sent in the X-Signature header and the time, in seconds since the epoch,
+// in the X-Timestamp header.
+func signHMAC(req *http.Request, secret string, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.Method + "\n" + req.URL.EscapedPath() + "\n" + ts))
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
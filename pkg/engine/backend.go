@@ -0,0 +1,188 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// backend is where files are pulled from. The locations it opens and describes are URLs for the HTTP API and
+// remote paths for the other backends.
+type backend interface {
+	// list hands the files on the remote to add, a page at a time for remotes that list in pages. It stops with the
+	// error of add.
+	list(ctx context.Context, add func(page []wp) error) error
+	// stat describes the file at remote.
+	stat(ctx context.Context, remote string) (remoteMeta, error)
+	// open opens the file at remote for reading, reads fail once ctx is done.
+	open(ctx context.Context, remote string) (io.ReadCloser, remoteMeta, error)
+	// remove deletes or archives webPath, depending on the completion of its mapping.
+	remove(ctx context.Context, webPath string) error
+	// close releases the connections of the backend, it is used again after closing.
+	close() error
+}
+
+// newBackend returns the backend for the scheme of the remote.
+func newBackend(e *Engine) backend {
+	switch e.c.Scheme {
+	case config.SchemeSFTP:
+		b := &sftpBackend{c: e.c}
+		b.dial = b.dialSSH
+		return b
+	case config.SchemeWebDAV:
+		return &webdavBackend{httpBackend{e: e}}
+	case config.SchemeS3:
+		return &s3Backend{httpBackend{e: e}}
+	default:
+		return &httpBackend{e: e}
+	}
+}
+
+// CheckBackend returns an error if the scheme of the remote is unknown, or if the configuration asks for things
+// its backend can't do.
+func CheckBackend(c *config.Configuration) error {
+	if err := checkUnix(c); err != nil {
+		return err
+	}
+	switch c.Scheme {
+	case "", config.SchemeHTTP:
+		return nil
+	case config.SchemeSFTP:
+		if _, _, err := parseSFTPRemote(c.Remote, c.UserName); err != nil {
+			return err
+		}
+		if len(c.Mirrors) > 0 {
+			return errors.New("mirrors need an HTTP remote")
+		}
+	case config.SchemeWebDAV:
+	case config.SchemeS3:
+		if err := checkS3(c); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid scheme %q", c.Scheme)
+	}
+
+	// Only a mediasync server announces files, archives them and accepts uploads.
+	if c.Watch {
+		return errors.New("watching for new files needs a mediasync remote")
+	}
+	for _, m := range c.RootMapping {
+		if m.Direction == config.DirectionPush {
+			return fmt.Errorf("mapping %s: push mappings need a mediasync remote", m.RemotePath)
+		}
+		if m.Completion == config.CompletionArchive {
+			return fmt.Errorf("mapping %s: archiving needs a mediasync remote", m.RemotePath)
+		}
+	}
+	return nil
+}
+
+// httpRemote reports whether files are downloaded over HTTP, which ranges, mirrors and warming up connections need.
+func (e *Engine) httpRemote() bool {
+	switch e.backend.(type) {
+	case *httpBackend, *webdavBackend, *s3Backend:
+		return true
+	default:
+		return false
+	}
+}
+
+// location returns where the backend finds webPath.
+func (e *Engine) location(webPath string) (string, error) {
+	if !e.httpRemote() {
+		return webPath, nil
+	}
+	u, err := e.createURL(webPath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse remote: %w", err)
+	}
+	return u.String(), nil
+}
+
+// httpBackend pulls files from the HTTP API of a mediasync server.
+type httpBackend struct {
+	e *Engine
+}
+
+func (b *httpBackend) list(ctx context.Context, add func(page []wp) error) error {
+	return b.e.listMirrored(ctx, add)
+}
+
+func (b *httpBackend) stat(ctx context.Context, remote string) (remoteMeta, error) {
+	resp, err := b.e.reqWithAuth(ctx, "HEAD", remote)
+	if err != nil {
+		return remoteMeta{size: -1}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return remoteMeta{size: -1}, err
+	}
+	return metaOf(resp), nil
+}
+
+func (b *httpBackend) open(ctx context.Context, remote string) (io.ReadCloser, remoteMeta, error) {
+	req, err := b.e.newRequest(ctx, "GET", remote)
+	if err != nil {
+		return nil, remoteMeta{size: -1}, err
+	}
+	if b.e.compressible(req.URL.Path) {
+		acceptCompression(req)
+	}
+	resp, err := b.e.do(req)
+	if err != nil {
+		return nil, remoteMeta{size: -1}, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, remoteMeta{size: -1}, err
+	}
+	if err := decodeBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, remoteMeta{size: -1}, err
+	}
+	return resp.Body, metaOf(resp), nil
+}
+
+func (b *httpBackend) remove(ctx context.Context, webPath string) error {
+	archive := b.e.completion(webPath) == config.CompletionArchive
+	endpoint := webPath
+	if archive {
+		endpoint = path.Join("/archive", webPath)
+	}
+	fileURL, err := b.e.createURL(endpoint)
+	if err != nil {
+		return fmt.Errorf("couldn't parse remote: %w", err)
+	}
+
+	if archive {
+		return b.e.archiveFile(ctx, fileURL)
+	}
+	return b.e.delFile(ctx, fileURL)
+}
+
+func (b *httpBackend) close() error {
+	return nil
+}
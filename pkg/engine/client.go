@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// Defaults of the HTTP timeouts, a zero timeout in the configuration means the default is used.
+const (
+	defaultConnectTimeout        = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = time.Minute
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+)
+
+// orDefault returns d if it is set, and def otherwise.
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// newTransport returns the transport for the requests to the remote, it keeps enough idle connections for
+// all parallel transfers. All requests go through the configured proxy. The engine uses a single transport, so
+// connections, and their TLS sessions, are reused between requests.
+func newTransport(c *config.Configuration) *http.Transport {
+	// A negative keep-alive interval disables keep-alive probes, so it can't go through orDefault.
+	keepAlive := c.HTTP.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = defaultKeepAlive
+	}
+	dialer := &net.Dialer{
+		Timeout:   orDefault(c.HTTP.ConnectTimeout, defaultConnectTimeout),
+		KeepAlive: keepAlive,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialFunc(c.HTTP, dialer)
+	transport.Proxy = ProxyFunc(c.HTTP.ProxyURL)
+	// Compression is requested per request, see acceptCompression.
+	transport.DisableCompression = true
+	transport.TLSHandshakeTimeout = orDefault(c.HTTP.TLSHandshakeTimeout, defaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = orDefault(c.HTTP.ResponseHeaderTimeout, defaultResponseHeaderTimeout)
+	transport.IdleConnTimeout = orDefault(c.HTTP.IdleConnTimeout, defaultIdleConnTimeout)
+
+	dialUnix(transport, c.Remote)
+
+	transport.DisableKeepAlives = c.HTTP.DisableKeepAlives
+	if c.HTTP.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if c.HTTP.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.HTTP.MaxIdleConnsPerHost
+		return transport
+	}
+	if c.PrewarmConnections > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = c.PrewarmConnections
+	}
+	if c.Concurrency > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = c.Concurrency
+	}
+	files := c.Concurrency
+	if files < 1 {
+		files = 1
+	}
+	if n := files * c.SegmentWorkers; n > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = n
+	}
+	return transport
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptCompression asks the remote to compress the response to req, with zstd or gzip. The transport doesn't do
+// this by itself, as it would also do it for file bodies, and it only knows gzip.
+func acceptCompression(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "zstd, gzip")
+}
+
+// compressible reports whether the body of the file at p is requested compressed.
+func (e *Engine) compressible(p string) bool {
+	if e.c.HTTP.CompressDownloads {
+		return true
+	}
+	return e.compress != nil && e.compress.Allows(p)
+}
+
+// decodedBody is a decompressing response body that closes the original body as well.
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	_ = d.ReadCloser.Close()
+	return d.body.Close()
+}
+
+// decodeBody replaces the body of resp by its decompressed content if the remote compressed it. The content length
+// is unknown after that.
+func decodeBody(resp *http.Response) error {
+	var (
+		zr  io.ReadCloser
+		err error
+	)
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "":
+		return nil
+	case "gzip":
+		zr, err = gzip.NewReader(resp.Body)
+	case "zstd":
+		// The body is read as a stream, decoding in the background wouldn't make that any faster.
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(resp.Body, zstd.WithDecoderConcurrency(1)); err == nil {
+			zr = d.IOReadCloser()
+		}
+	default:
+		return fmt.Errorf("unsupported content encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't decompress response: %w", err)
+	}
+	resp.Body = &decodedBody{ReadCloser: zr, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
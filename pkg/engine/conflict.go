@@ -0,0 +1,86 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+// maxConflictSuffix is the highest suffix tried for ConflictRename, before giving up on the file.
+const maxConflictSuffix = 1000
+
+// resolveConflict applies the conflict policy of the mapping of f when local already exists. It returns where
+// f has to be downloaded to, or a skip error if the local file has to stay as it is.
+func (e *Engine) resolveConflict(ctx context.Context, f wp, local string) (string, error) {
+	fi, err := os.Stat(local)
+	if err != nil {
+		return local, nil
+	}
+
+	m, _ := e.mapper.Find(f.WebPath)
+	switch m.Conflict {
+	case config.ConflictSkip:
+		return local, skip(fmt.Errorf("%s already exists", local))
+	case config.ConflictRename:
+		for n := 1; n <= maxConflictSuffix; n++ {
+			p := suffixed(local, n)
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				return p, nil
+			}
+		}
+		return local, skip(fmt.Errorf("%s and %d renamed copies already exist", local, maxConflictSuffix))
+	case config.ConflictKeepNewer:
+		modified := f.Modified
+		if modified.IsZero() {
+			modified = e.remoteModified(ctx, f.WebPath)
+		}
+		if !modified.After(fi.ModTime()) {
+			return local, skip(fmt.Errorf("%s is not older than the remote file", local))
+		}
+		return local, nil
+	default:
+		return local, nil
+	}
+}
+
+// suffixed inserts " (n)" before the extension of p, an encryption extension is kept at the end.
+func suffixed(p string, n int) string {
+	base := strings.TrimSuffix(p, crypt.Ext)
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s (%d)%s%s", strings.TrimSuffix(base, ext), n, ext, p[len(base):])
+}
+
+// remoteModified asks the remote for the modification time of webPath, it is zero if the remote doesn't say.
+func (e *Engine) remoteModified(ctx context.Context, webPath string) time.Time {
+	remote, err := e.location(webPath)
+	if err != nil {
+		return time.Time{}
+	}
+	meta, err := e.backend.stat(ctx, remote)
+	if err != nil {
+		return time.Time{}
+	}
+	return meta.modified
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+)
+
+// hashIndex maps the sha-256 digests of downloads to where they are stored, so files with the same content are
+// hardlinked instead of downloaded again. It is safe for concurrent use.
+type hashIndex struct {
+	mu sync.Mutex
+	// path is where the index is stored, deduplication is disabled if it is empty.
+	path  string
+	files map[string]string
+}
+
+func loadHashIndex(p string) (*hashIndex, error) {
+	idx := &hashIndex{path: p, files: make(map[string]string)}
+	if p == "" {
+		return idx, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read dedupe index: %w", err)
+	}
+	if err := json.Unmarshal(b, &idx.files); err != nil {
+		return nil, fmt.Errorf("couldn't parse dedupe index %s: %w", p, err)
+	}
+	return idx, nil
+}
+
+// lookup returns where a file with digest is stored, if it is still there with the expected size.
+func (idx *hashIndex) lookup(digest string, size int64) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.path == "" || digest == "" {
+		return "", false
+	}
+	p, ok := idx.files[digest]
+	if !ok {
+		return "", false
+	}
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() || size > 0 && fi.Size() != size {
+		return "", false
+	}
+	return p, true
+}
+
+// add records that the file with digest is stored at p.
+func (idx *hashIndex) add(digest, p string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.path == "" || digest == "" {
+		return nil
+	}
+	idx.files[digest] = p
+	b, err := json.Marshal(idx.files)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(idx.path, b); err != nil {
+		return fmt.Errorf("couldn't store dedupe index: %w", err)
+	}
+	return nil
+}
+
+// contentDigest returns the hex encoded sha-256 digest of the content of f, as far as it is known.
+func contentDigest(f wp, t transfer) string {
+	switch {
+	case f.SHA256 != "":
+		return f.SHA256
+	case t.sum != nil:
+		return hex.EncodeToString(t.sum)
+	default:
+		return hex.EncodeToString(t.digest)
+	}
+}
+
+// linkDuplicate hardlinks an earlier download with the same content as f to local, it reports whether it did.
+// Failing to link isn't an error, the file is downloaded instead.
+func (e *Engine) linkDuplicate(f wp, local string) bool {
+	size := f.Size
+	if size > 0 && e.key != nil {
+		size = crypt.EncryptedSize(size)
+	}
+	src, ok := e.index.lookup(f.SHA256, size)
+	if !ok {
+		return false
+	}
+
+	dir, name := filepath.Split(local)
+	if err := e.ensureDir(f.WebPath, dir); err != nil {
+		return false
+	}
+	tmp := filepath.Join(dir, fmt.Sprintf(".%s.link", name))
+	_ = os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		e.emit(Event{Type: Warning, File: f.WebPath, Err: fmt.Errorf("couldn't link %s to %s: %w", src, local, err)})
+		return false
+	}
+	if err := os.Rename(tmp, local); err != nil {
+		_ = os.Remove(tmp)
+		return false
+	}
+	if err := e.record(f.WebPath, local, "", journal.Renamed); err != nil {
+		e.emit(Event{Type: Warning, File: f.WebPath, Err: err})
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+)
+
+// defaultDeleteWorkers is used when delete_workers isn't configured.
+const defaultDeleteWorkers = 4
+
+func (e *Engine) deferDeletes() bool {
+	return e.c.DeferDeletes || e.c.Transactional
+}
+
+// deletePhase deletes the downloaded files in pending from the remote in parallel. In transactional mode
+// nothing is deleted if runErr is set or any file failed, the files are then left on the remote for the next run.
+// The same goes for a cancelled run, which should stop as soon as possible.
+func (e *Engine) deletePhase(ctx context.Context, res *Result, pending []FileResult, runErr error) {
+	if len(pending) == 0 {
+		return
+	}
+
+	if ctx.Err() != nil || e.c.Transactional && (runErr != nil || len(res.Filter(Failed)) > 0) {
+		e.keepRemote(pending)
+		return
+	}
+
+	workers := e.c.DeleteWorkers
+	if workers <= 0 {
+		workers = defaultDeleteWorkers
+	}
+
+	files := make(chan FileResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fr := range files {
+				e.deleteDownloaded(ctx, res, fr)
+			}
+		}()
+	}
+
+	for _, fr := range pending {
+		files <- fr
+	}
+	close(files)
+	wg.Wait()
+}
+
+func (e *Engine) deleteDownloaded(ctx context.Context, res *Result, fr FileResult) {
+	_, err := e.retry(ctx, fr.File, func() error {
+		return e.removeRemote(ctx, fr.File, fr.Local)
+	})
+	if err != nil {
+		res.fail(fr.File, err)
+		e.emit(Event{Type: FileFailed, File: fr.File, Err: err})
+		return
+	}
+	e.emit(Event{Type: FileDone, File: fr.File, Bytes: fr.Bytes, Total: fr.Bytes})
+}
+
+// keepRemote leaves the files in pending on the remote, they are marked as kept in the journal so the next
+// run doesn't delete them while recovering.
+func (e *Engine) keepRemote(pending []FileResult) {
+	for _, fr := range pending {
+		if err := e.record(fr.File, fr.Local, "", journal.Kept); err != nil {
+			e.emit(Event{Type: Warning, File: fr.File, Err: err})
+		}
+	}
+	e.emit(Event{
+		Type: Warning,
+		Err:  fmt.Errorf("not all files were synchronised, kept %d downloaded files on the remote", len(pending)),
+	})
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ainmosni/mediasync-client/pkg/rollsum"
+)
+
+// blockSums are the checksums of the blocks of a remote file, as served by /blocksums.
+type blockSums struct {
+	BlockSize int64  `json:"block_size"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Blocks    []struct {
+		// Weak is the rolling checksum of the block and Strong its hex encoded sha-256 digest.
+		Weak   uint32 `json:"weak"`
+		Strong string `json:"strong"`
+	} `json:"blocks"`
+}
+
+// deltaPlan describes how a file is assembled from an older local copy and the blocks that changed.
+type deltaPlan struct {
+	local     string
+	size      int64
+	blockSize int64
+	digest    []byte
+	// found holds the offset of every block of the remote file in the local copy, -1 if it has to be fetched.
+	found []int64
+}
+
+// planDelta plans a delta transfer of remote over the existing file at local. It returns nil if delta transfers
+// are disabled or impossible, in which case the file is downloaded as a whole.
+func (e *Engine) planDelta(ctx context.Context, webPath, remote, local string) *deltaPlan {
+	if !e.c.DeltaTransfer || e.key != nil {
+		return nil
+	}
+	if _, ok := e.backend.(*httpBackend); !ok {
+		return nil
+	}
+	fi, err := os.Stat(local)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return nil
+	}
+
+	sums, err := e.blockSums(ctx, webPath)
+	if err != nil {
+		return nil
+	}
+	if _, ok := e.rangeSupport(ctx, remote); !ok {
+		return nil
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	found, err := matchBlocks(f, sums)
+	if err != nil {
+		e.emit(Event{Type: Warning, File: webPath, Err: fmt.Errorf("couldn't compare %s: %w", local, err)})
+		return nil
+	}
+	digest, _ := hex.DecodeString(sums.SHA256)
+	return &deltaPlan{local: local, size: sums.Size, blockSize: sums.BlockSize, digest: digest, found: found}
+}
+
+// blockSums gets the checksums of the blocks of webPath.
+func (e *Engine) blockSums(ctx context.Context, webPath string) (*blockSums, error) {
+	u, err := e.createURL("/blocksums" + webPath)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.reqWithAuth(ctx, "GET", u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var sums blockSums
+	if err := json.NewDecoder(resp.Body).Decode(&sums); err != nil {
+		return nil, fmt.Errorf("couldn't parse json: %w", err)
+	}
+	if sums.BlockSize <= 0 || sums.Size <= 0 {
+		return nil, fmt.Errorf("invalid block checksums")
+	}
+	if want := (sums.Size + sums.BlockSize - 1) / sums.BlockSize; int64(len(sums.Blocks)) != want {
+		return nil, fmt.Errorf("got %d block checksums for %d bytes", len(sums.Blocks), sums.Size)
+	}
+	return &sums, nil
+}
+
+// matchBlocks finds the full blocks of sums in r at any offset, by rolling the weak checksum over r a byte at a
+// time and confirming its matches with the strong one. It returns the offset of every block in r, -1 for the
+// blocks that weren't found. The last block of the remote file is only found if it is a full block.
+func matchBlocks(r io.Reader, sums *blockSums) ([]int64, error) {
+	n := sums.BlockSize
+	found := make([]int64, len(sums.Blocks))
+	byWeak := make(map[uint32][]int)
+	for i, b := range sums.Blocks {
+		found[i] = -1
+		if int64(i+1)*n <= sums.Size {
+			byWeak[b.Weak] = append(byWeak[b.Weak], i)
+		}
+	}
+
+	br := bufio.NewReaderSize(r, 1<<20)
+	window := make([]byte, n)
+	var (
+		offset int64
+		head   int64
+		rs     *rollsum.Rollsum
+	)
+	// fill reads a new window at the current offset, it reports whether there was a full block left.
+	fill := func() (bool, error) {
+		if _, err := io.ReadFull(br, window); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, err
+		}
+		head, rs = 0, rollsum.New(window)
+		return true, nil
+	}
+
+	ok, err := fill()
+	for ok && err == nil {
+		if matched := matchWindow(byWeak[rs.Sum()], sums, window, head, found, offset); matched {
+			offset += n
+			ok, err = fill()
+			continue
+		}
+
+		c, rerr := br.ReadByte()
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		rs.Roll(window[head], c)
+		window[head] = c
+		head = (head + 1) % n
+		offset++
+	}
+	return found, err
+}
+
+// matchWindow checks the window, which starts at head in the ring buffer, against the blocks with its weak
+// checksum. Blocks with the same content are all found at offset.
+func matchWindow(candidates []int, sums *blockSums, window []byte, head int64, found []int64, offset int64) bool {
+	if len(candidates) == 0 {
+		return false
+	}
+	h := sha256.New()
+	h.Write(window[head:])
+	h.Write(window[:head])
+	strong := hex.EncodeToString(h.Sum(nil))
+
+	matched := false
+	for _, i := range candidates {
+		if sums.Blocks[i].Strong == strong {
+			matched = true
+			if found[i] < 0 {
+				found[i] = offset
+			}
+		}
+	}
+	return matched
+}
+
+// fetchDelta assembles the file of d in w, copying the blocks that were found locally and fetching the others
+// in ranges.
+func (e *Engine) fetchDelta(
+	ctx context.Context, webPath, remote string, w destination, d *deltaPlan, bufSize int,
+) (remoteMeta, error) {
+	local, err := os.Open(d.local)
+	if err != nil {
+		return remoteMeta{size: -1}, fmt.Errorf("couldn't open %s: %w", d.local, err)
+	}
+	defer local.Close()
+
+	progress := &progressWriter{e: e, file: webPath, total: d.size}
+	buf := make([]byte, d.blockSize)
+	var missing []segment
+	for i, off := range d.found {
+		seg := segment{start: int64(i) * d.blockSize, end: int64(i+1)*d.blockSize - 1}
+		if seg.end >= d.size {
+			seg.end = d.size - 1
+		}
+		if off < 0 {
+			// Neighbouring blocks are fetched in a single range.
+			if last := len(missing) - 1; last >= 0 && missing[last].end+1 == seg.start {
+				missing[last].end = seg.end
+			} else {
+				missing = append(missing, seg)
+			}
+			continue
+		}
+
+		b := buf[:seg.length()]
+		if _, err := local.ReadAt(b, off); err != nil {
+			return remoteMeta{size: -1}, fmt.Errorf("couldn't read %s: %w", d.local, err)
+		}
+		if _, err := w.WriteAt(b, seg.start); err != nil {
+			return remoteMeta{size: -1}, fmt.Errorf("couldn't write %s: %w", webPath, diskError(err))
+		}
+		progress.add(seg.length())
+	}
+
+	for _, seg := range missing {
+		if err := e.fetchSegmentRetrying(ctx, remote, "", w, seg, progress, bufSize, nil); err != nil {
+			return remoteMeta{size: -1}, err
+		}
+	}
+	return remoteMeta{size: d.size, digest: d.digest}, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// CheckDialing returns an error if the IP family, DNS server or the pinned hosts in h are invalid.
+func CheckDialing(h config.HTTPConfig) error {
+	switch h.IPFamily {
+	case "", config.IPv4, config.IPv6:
+	default:
+		return fmt.Errorf("invalid ip_family %q", h.IPFamily)
+	}
+	if h.DNSServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServer(h.DNSServer)); err != nil {
+			return fmt.Errorf("invalid dns_server: %w", err)
+		}
+	}
+	for host, ip := range h.Hosts {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid address %q for host %s", ip, host)
+		}
+	}
+	return nil
+}
+
+// dnsServer adds the default port to addr if it doesn't have one.
+func dnsServer(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil && net.ParseIP(strings.Trim(addr, "[]")) != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+	}
+	return addr
+}
+
+// newResolver returns the resolver for the host names of the remote and its mirrors, which asks the configured
+// DNS server if there is one.
+func newResolver(h config.HTTPConfig, dialer *net.Dialer) *net.Resolver {
+	if h.DNSServer == "" {
+		return net.DefaultResolver
+	}
+	server := dnsServer(h.DNSServer)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// dialContext is the signature of net.Dialer.DialContext.
+type dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialFunc returns the function connections to the remote and its mirrors are made with. It connects to the
+// pinned address of hosts that have one, and only to addresses of the configured IP family.
+func dialFunc(h config.HTTPConfig, dialer *net.Dialer) dialContext {
+	if err := CheckDialing(h); err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+
+	dialer.Resolver = newResolver(h, dialer)
+	hosts := make(map[string]string, len(h.Hosts))
+	for host, ip := range h.Hosts {
+		hosts[strings.ToLower(host)] = ip
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !strings.HasPrefix(network, "tcp") {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		switch h.IPFamily {
+		case config.IPv4:
+			network = "tcp4"
+		case config.IPv6:
+			network = "tcp6"
+		}
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := hosts[strings.ToLower(host)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// resolveHost looks host up the way connections to it are made.
+func (e *Engine) resolveHost(ctx context.Context, host string) ([]string, error) {
+	for h, ip := range e.c.HTTP.Hosts {
+		if strings.EqualFold(h, host) {
+			return []string{ip}, nil
+		}
+	}
+	dialer := &net.Dialer{Timeout: orDefault(e.c.HTTP.ConnectTimeout, defaultConnectTimeout)}
+	return newResolver(e.c.HTTP, dialer).LookupHost(ctx, host)
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"crypto/md5" //nolint:gosec // Digest auth is defined with MD5.
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestAuth answers the digest challenges of the remote, as described in RFC 7616. It is safe for concurrent use.
+type digestAuth struct {
+	user     string
+	password string
+
+	mu sync.Mutex
+	// params holds the parameters of the last challenge, it is nil until the remote sent one.
+	params map[string]string
+	// nc counts the requests made with the current nonce.
+	nc int
+}
+
+// parseChallenge returns the parameters of the digest challenge among the WWW-Authenticate headers h, it reports
+// whether there was one.
+func parseChallenge(h []string) (map[string]string, bool) {
+	for _, c := range h {
+		if len(c) < 7 || !strings.EqualFold(c[:7], "digest ") {
+			continue
+		}
+		return parseAuthParams(c[7:]), true
+	}
+	return nil, false
+}
+
+// parseAuthParams parses a comma separated list of key=value pairs, where the values may be quoted strings.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var val strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				val.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+		params[key] = val.String()
+	}
+}
+
+// challenge takes the digest challenge from resp, it reports whether resp had one.
+func (d *digestAuth) challenge(resp *http.Response) bool {
+	params, ok := parseChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.params = params
+	d.nc = 0
+	return true
+}
+
+// ready reports whether the remote sent a challenge that requests can be authorized with.
+func (d *digestAuth) ready() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.params != nil
+}
+
+// authorize sets the digest Authorization header on req, it does nothing until the remote sent a challenge.
+func (d *digestAuth) authorize(req *http.Request) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.params == nil {
+		return nil
+	}
+	p := d.params
+
+	var newHash func() hash.Hash
+	algorithm := p["algorithm"]
+	sess := strings.HasSuffix(strings.ToUpper(algorithm), "-SESS")
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	cnonce, err := randomString(16)
+	if err != nil {
+		return fmt.Errorf("couldn't create cnonce: %w", err)
+	}
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	uri := req.URL.RequestURI()
+
+	ha1 := h(d.user + ":" + p["realm"] + ":" + d.password)
+	if sess {
+		ha1 = h(ha1 + ":" + p["nonce"] + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	qop := ""
+	for _, q := range strings.Split(p["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username="%s", realm="%s", nonce="%s", uri="%s"`, d.user, p["realm"], p["nonce"], uri)
+	if algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", algorithm)
+	}
+	if qop == "" {
+		// RFC 2069 compatibility, for remotes that don't offer a qop.
+		fmt.Fprintf(&b, `, response="%s"`, h(ha1+":"+p["nonce"]+":"+ha2))
+	} else {
+		fmt.Fprintf(&b, `, response="%s", qop=%s, nc=%s, cnonce="%s"`,
+			h(ha1+":"+p["nonce"]+":"+nc+":"+cnonce+":"+qop+":"+ha2), qop, nc, cnonce)
+	}
+	if opaque, ok := p["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque="%s"`, opaque)
+	}
+	req.Header.Set("Authorization", b.String())
+	return nil
+}
+
+// replayable reports whether req can be sent again, which requests with a body can only if it can be recreated.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// doDigest sends req, answering the challenge of the remote and sending req again if it hasn't got a valid
+// nonce yet. Requests that can't be sent again are preceded by a HEAD request for the challenge.
+func (e *Engine) doDigest(req *http.Request) (*http.Response, error) {
+	if !e.digest.ready() && !replayable(req) {
+		head, err := e.newRequest(req.Context(), http.MethodHead, req.URL.String())
+		if err != nil {
+			return nil, err
+		}
+		resp, err := e.client.Do(head)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		e.digest.challenge(resp)
+		if err := e.digest.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !replayable(req) || !e.digest.challenge(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.digest.authorize(retry); err != nil {
+		return nil, err
+	}
+	return e.client.Do(retry)
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+	"github.com/ainmosni/mediasync-client/pkg/fsutil"
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+	"github.com/ainmosni/mediasync-client/pkg/ratelimit"
+)
+
+const (
+	postfixLen = 8
+)
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", b), nil
+}
+
+// downloadFile downloads remote to local and describes the transfer. The file is verified against size, or the
+// size reported by the remote if that is zero, and against digest, see verifyChecksum, before it is moved into
+// place.
+func (e *Engine) downloadFile(
+	ctx context.Context, webPath, remote, local string, size int64, digest []byte,
+) (transfer, error) {
+	dir, fName := filepath.Split(local)
+	if err := e.ensureDir(webPath, dir); err != nil {
+		return transfer{}, err
+	}
+
+	stagingDir := e.stagingDir(webPath, dir)
+	if stagingDir != dir {
+		if err := os.MkdirAll(stagingDir, 0775); err != nil {
+			return transfer{}, fmt.Errorf("couldn't create staging dir: %w", diskError(err))
+		}
+	}
+
+	ws := e.writeStrategy(webPath)
+	p := e.plan(ctx, remote)
+	if d := e.planDelta(ctx, webPath, remote, local); d != nil {
+		p.size, p.delta = d.size, d
+		p.segmented, p.resumable = false, false
+	}
+	need := size
+	if need <= 0 {
+		need = p.size
+	}
+	if need <= 0 {
+		need, _ = e.remoteInfo(ctx, remote)
+	}
+	if err := e.checkSpace(webPath, stagingDir, need); err != nil {
+		return transfer{}, err
+	}
+	var (
+		sf  stagedFile
+		rf  *resumableFile
+		rs  *resumeState
+		err error
+	)
+	if p.resumable {
+		partial := fName
+		if e.c.StateSuffix != "" {
+			// Further remotes may download files with the same name into the same directory.
+			partial += "." + e.c.StateSuffix
+		}
+		rf, err = stageResumable(stagingDir, partial, ws.openFlags())
+		if err != nil {
+			return transfer{}, err
+		}
+		defer rf.Abort()
+		if rs, err = loadResume(rf.File(), rf.statePath, p.size, int64(e.c.ChunkSize), p.validator); err != nil {
+			return transfer{}, err
+		}
+		sf = rf
+	} else {
+		sf, err = stage(stagingDir, fName, ws)
+		if err != nil {
+			return transfer{}, err
+		}
+		defer sf.Abort()
+	}
+	output := sf.File()
+	if ws.preallocate && need > 0 {
+		if e.key != nil {
+			need = crypt.EncryptedSize(need)
+		}
+		if err := preallocate(output, need); err != nil {
+			return transfer{}, fmt.Errorf("couldn't preallocate %s: %w", local, diskError(err))
+		}
+	}
+
+	// Failed downloads are cleaned up right away, so there's nothing left to recover.
+	committed := false
+	defer func() {
+		if !committed {
+			_ = e.record(webPath, local, "", journal.RolledBack)
+		}
+	}()
+
+	if err := e.record(webPath, local, sf.Name(), journal.Downloading); err != nil {
+		return transfer{}, err
+	}
+
+	dst := &countingDestination{destination: ws.wrap(output)}
+	start := time.Now()
+	meta, err := e.fetch(ctx, webPath, remote, dst, p, rs)
+	t := transfer{
+		transferred: atomic.LoadInt64(&dst.n),
+		elapsed:     time.Since(start),
+		modified:    meta.modified,
+		digest:      meta.digest,
+		sum:         meta.sum,
+	}
+	if err != nil {
+		return transfer{}, err
+	}
+	if size <= 0 {
+		size = meta.size
+	}
+	if e.key != nil && size >= 0 {
+		size = crypt.EncryptedSize(size)
+	}
+	fi, err := output.Stat()
+	if err != nil {
+		return transfer{}, fmt.Errorf("couldn't stat download of %s: %w", local, err)
+	}
+	if err := verifySize(local, fi.Size(), size); err != nil {
+		rf.discard()
+		return transfer{}, err
+	}
+	if err := e.verifyChecksum(digest, t, output, fi.Size(), local); err != nil {
+		rf.discard()
+		return transfer{}, err
+	}
+	if e.c.DurableWrites {
+		if err := output.Sync(); err != nil {
+			return transfer{}, fmt.Errorf("failed to sync %s: %w", local, diskError(err))
+		}
+	}
+	if err := e.record(webPath, local, sf.Name(), journal.Downloaded); err != nil {
+		return transfer{}, err
+	}
+	if err := sf.Commit(local); err != nil {
+		return transfer{}, err
+	}
+	committed = true
+
+	if e.c.DurableWrites {
+		if err := fsutil.SyncDir(dir); err != nil {
+			return transfer{}, fmt.Errorf("failed to sync %s: %w", dir, diskError(err))
+		}
+	}
+
+	t.size = fi.Size()
+	return t, e.record(webPath, local, "", journal.Renamed)
+}
+
+// transfer describes a completed download.
+type transfer struct {
+	// size is the size of the local file.
+	size int64
+	// transferred is the amount of bytes that were written in this attempt, less than size if it was resumed.
+	transferred int64
+	elapsed     time.Duration
+	// modified is the modification time of the remote file, zero if the remote didn't supply it.
+	modified time.Time
+	// digest and sum are the sha-256 digests of the remote file as claimed by the remote and as computed
+	// during the transfer, nil if they are unknown.
+	digest []byte
+	sum    []byte
+}
+
+// remoteMeta is what is learned about a remote file while fetching it.
+type remoteMeta struct {
+	// size is -1 if it is unknown.
+	size     int64
+	modified time.Time
+	// digest is the sha-256 digest the remote claims the file has, sum is the one that was computed while
+	// fetching it. Either is nil if it is unknown.
+	digest []byte
+	sum    []byte
+	// validator identifies the version of the file for If-Range, it is empty if the remote has no strong one.
+	validator string
+}
+
+func metaOf(resp *http.Response) remoteMeta {
+	m := remoteMeta{size: resp.ContentLength, digest: headerDigest(resp.Header)}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		m.modified = t
+	}
+	// Weak ETags can't be used in If-Range, a Last-Modified date can.
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		m.validator = etag
+	} else {
+		m.validator = resp.Header.Get("Last-Modified")
+	}
+	return m
+}
+
+// countingDestination counts the bytes written to a destination, it is safe for concurrent use.
+type countingDestination struct {
+	destination
+	n int64
+}
+
+func (c *countingDestination) Write(p []byte) (int, error) {
+	n, err := c.destination.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingDestination) WriteAt(p []byte, off int64) (int, error) {
+	n, err := c.destination.WriteAt(p, off)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// transferPlan describes how a file will be downloaded.
+type transferPlan struct {
+	size     int64
+	modified time.Time
+	// validator is sent as If-Range with the requests for segments, so they never mix versions of the file.
+	validator string
+	// segmented files are downloaded in chunks of the configured chunk size.
+	segmented bool
+	// resumable files are segmented files that keep track of their progress on disk.
+	resumable bool
+	// delta is set if the file is assembled from an older local copy and the blocks that changed.
+	delta *deltaPlan
+}
+
+// plan decides how remote is downloaded, segmenting is only possible if the remote supports range requests.
+func (e *Engine) plan(ctx context.Context, remote string) transferPlan {
+	p := transferPlan{size: -1}
+	// Encrypted files can only be written as a stream, and only the HTTP API supports ranges.
+	if e.c.ChunkSize <= 0 || e.key != nil || !e.httpRemote() {
+		return p
+	}
+
+	meta, ok := e.rangeSupport(ctx, remote)
+	if !ok {
+		return p
+	}
+	p.size, p.modified, p.validator = meta.size, meta.modified, meta.validator
+	p.segmented = p.size > int64(e.c.ChunkSize)
+	p.resumable = p.segmented && e.c.ResumeThreshold > 0 && p.size >= int64(e.c.ResumeThreshold)
+	return p
+}
+
+// fetch downloads remote into output according to p, rs is only used for resumable downloads. It returns what
+// the remote told about the file.
+func (e *Engine) fetch(
+	ctx context.Context, webPath, remote string, output destination, p transferPlan, rs *resumeState,
+) (remoteMeta, error) {
+	bufSize := e.writeStrategy(webPath).bufferSize()
+	if p.delta != nil {
+		meta, err := e.fetchDelta(ctx, webPath, remote, output, p.delta, bufSize)
+		meta.modified = p.modified
+		return meta, err
+	}
+	if p.segmented {
+		meta := remoteMeta{size: p.size, modified: p.modified}
+		return meta, e.fetchSegments(ctx, webPath, remote, output, p, bufSize, rs)
+	}
+	if e.key != nil {
+		return e.fetchEncrypted(ctx, webPath, remote, output, bufSize)
+	}
+	return e.fetchStream(ctx, webPath, remote, output, bufSize)
+}
+
+// fetchEncrypted downloads remote into w, encrypting it on the way.
+func (e *Engine) fetchEncrypted(
+	ctx context.Context, webPath, remote string, w io.Writer, bufSize int,
+) (remoteMeta, error) {
+	cw, err := crypt.NewWriter(w, e.key)
+	if err != nil {
+		return remoteMeta{size: -1}, fmt.Errorf("couldn't encrypt %s: %w", remote, diskError(err))
+	}
+	meta, err := e.fetchStream(ctx, webPath, remote, cw, bufSize)
+	if err != nil {
+		return remoteMeta{size: -1}, err
+	}
+	if err := cw.Close(); err != nil {
+		return remoteMeta{size: -1}, fmt.Errorf("couldn't encrypt %s: %w", remote, diskError(err))
+	}
+	return meta, nil
+}
+
+// fetchStream downloads remote into w in a single request, returning what the response told about the file.
+func (e *Engine) fetchStream(
+	ctx context.Context, webPath, remote string, w io.Writer, bufSize int,
+) (remoteMeta, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body, meta, err := e.backend.open(ctx, remote)
+	if err != nil {
+		return remoteMeta{size: -1}, fmt.Errorf("couldn't download %s: %w", remote, err)
+	}
+	defer body.Close()
+
+	progress := &progressWriter{e: e, file: webPath, total: meta.size}
+	h := sha256.New()
+	_, err = e.copyBody(ctx, cancel, webPath, io.MultiWriter(w, progress, h), body, bufSize)
+	if err != nil {
+		return remoteMeta{size: -1}, fmt.Errorf("failed downloading %s: %w", remote, diskError(err))
+	}
+	meta.sum = h.Sum(nil)
+	return meta, nil
+}
+
+// copyBody copies the response body of webPath to w, enforcing the idle timeout and the bandwidth limits. The
+// cancel function has to cancel the request the body belongs to. If bufSize is set, writes are collected into
+// chunks of that size.
+func (e *Engine) copyBody(
+	ctx context.Context, cancel func(), webPath string, w io.Writer, body io.Reader, bufSize int,
+) (int64, error) {
+	if e.c.IdleTimeout > 0 {
+		ir := newIdleReader(body, e.c.IdleTimeout, cancel)
+		defer ir.Stop()
+		body = ir
+	}
+	body = ratelimit.NewReader(ctx, body, e.limiter, e.mappingLimiter(webPath))
+
+	if bufSize <= 0 {
+		return io.Copy(w, body)
+	}
+
+	bw := bufio.NewWriterSize(w, bufSize)
+	n, err := io.Copy(bw, body)
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// ensureDir makes sure dir exists, creating it if the directory policy of the mapping allows it.
+func (e *Engine) ensureDir(webPath, dir string) error {
+	m, _ := e.mapper.Find(webPath)
+	switch m.DirPolicy {
+	case "", config.DirPolicyCreate:
+		if err := e.mkdirAll(webPath, dir); err != nil {
+			return fmt.Errorf("couldn't create dir: %w", diskError(err))
+		}
+		return nil
+	case config.DirPolicyExisting:
+	default:
+		return fmt.Errorf("unknown directory policy %q", m.DirPolicy)
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory %s is not allowed to be created: %w", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// verifySize checks that the download of p has the expected size, a negative size can't be verified.
+func verifySize(p string, got, size int64) error {
+	if size < 0 || got == size {
+		return nil
+	}
+	return fmt.Errorf("%s has %d bytes, expected %d: %w", p, got, size, ErrSizeMismatch)
+}
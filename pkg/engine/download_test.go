@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+func benchmarkDownload(b *testing.B, size int, handlers ...Handler) {
+	data := make([]byte, size)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	e := New(&config.Configuration{Remote: srv.URL})
+	for _, h := range handlers {
+		e.Subscribe(h)
+	}
+	local := filepath.Join(b.TempDir(), "bench")
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.downloadFile(context.Background(), "/bench", srv.URL+"/bench", local, 0, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDownloadFile(b *testing.B) {
+	benchmarkDownload(b, 16<<20)
+}
+
+func BenchmarkDownloadFileWithHandler(b *testing.B) {
+	benchmarkDownload(b, 16<<20, func(Event) {})
+}
+
+func TestDownloadFileVerifiesSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("episode"))
+	}))
+	defer srv.Close()
+
+	e := New(&config.Configuration{Remote: srv.URL})
+	dir := t.TempDir()
+	local := filepath.Join(dir, "s01e01.mkv")
+	if err := ioutil.WriteFile(local, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := srv.URL + "/tv/s01e01.mkv"
+	// A truncated download must not replace the local file.
+	_, err := e.downloadFile(context.Background(), "/tv/s01e01.mkv", remote, local, 10, nil)
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("download of 7 bytes with a listed size of 10: %v", err)
+	}
+	if b, err := ioutil.ReadFile(local); err != nil || string(b) != "old" {
+		t.Errorf("local file was replaced by %q: %v", b, err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 1 {
+		t.Errorf("the failed download left files behind: %v", names)
+	}
+
+	if _, err := e.downloadFile(context.Background(), "/tv/s01e01.mkv", remote, local, 7, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(local); err != nil || string(b) != "episode" {
+		t.Errorf("local file is %q: %v", b, err)
+	}
+}
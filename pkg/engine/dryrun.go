@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// dryRun lists the files a run would transfer as Planned, and the files it would leave alone as Skipped or
+// Deferred, without downloading, deleting or uploading anything. The files are put through the same decisions as
+// in a real run, in the same mode, so files that are excluded by the filters aren't listed at all. The listing
+// cache and the queue state are left alone.
+func (e *Engine) dryRun(ctx context.Context, res *Result) error {
+	if e.mode != ModeUpload {
+		var files []wp
+		err := e.backend.list(ctx, func(page []wp) error {
+			files = append(files, page...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't get file list: %w", err)
+		}
+
+		q := e.queue(e.allowed(e.pulled(e.notMirrored(files))))
+		for {
+			v, ok := q.Pop()
+			if !ok {
+				break
+			}
+			res.add(e.planFile(ctx, v.(wp)))
+		}
+	}
+	return e.planPush(res)
+}
+
+// planFile returns what syncFile would do with f.
+func (e *Engine) planFile(ctx context.Context, f wp) FileResult {
+	local, err := e.target(ctx, f)
+	fr := FileResult{File: f.WebPath, Local: local, Outcome: Planned, Bytes: f.Size, Err: err}
+	switch {
+	case err == nil:
+	case isSkip(err):
+		fr.Outcome = Skipped
+	case isDeferral(ctx, err):
+		fr.Outcome = Deferred
+	default:
+		fr.Outcome = Failed
+	}
+	return fr
+}
+
+// planPush lists the files the push mappings would upload as Planned, see pushPhase.
+func (e *Engine) planPush(res *Result) error {
+	for _, m := range e.c.RootMapping {
+		if m.Direction != config.DirectionPush {
+			continue
+		}
+		files, err := e.localFiles(m)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			res.add(FileResult{File: f.WebPath, Local: f.local, Outcome: Planned, Bytes: f.Size})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,577 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package engine synchronises files from a mediasync server to the local filesystem.
+package engine
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+	"github.com/ainmosni/mediasync-client/pkg/filter"
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+	"github.com/ainmosni/mediasync-client/pkg/mapping"
+	"github.com/ainmosni/mediasync-client/pkg/queue"
+	"github.com/ainmosni/mediasync-client/pkg/ratelimit"
+)
+
+type Engine struct {
+	c         *config.Configuration
+	client    *http.Client
+	transport *http.Transport
+	backend   backend
+	limiter   *ratelimit.Bucket
+	// limiters maps the remote path of mappings with a bandwidth limit of their own to their bucket.
+	limiters map[string]*ratelimit.Bucket
+	// slots maps the remote path of mappings with a concurrency of their own to a semaphore of that size. Slots are
+	// only taken with slotMu held, see nextFile.
+	slots  map[string]chan struct{}
+	slotMu sync.Mutex
+	// tokens hands out access tokens when the remote uses OAuth2.
+	tokens *tokenSource
+	// digest answers the challenges of the remote when it uses digest auth.
+	digest   *digestAuth
+	journal  *journal.Journal
+	mapper   *mapping.Mapper
+	mirrors  *mirrorSet
+	handlers []Handler
+	// key encrypts downloads at rest, if set.
+	key     []byte
+	filters *filter.Set
+	// compress matches the files whose bodies are requested compressed, it is nil if there are no such patterns.
+	compress *filter.Filter
+	// perms maps the remote path of mappings to the permissions of the files they create.
+	perms map[string]permissions
+	// mirrored holds the downloads of mappings that keep remote files and the uploads of push mappings.
+	mirrored *mirrorState
+	// index holds the digests of earlier downloads, for deduplication.
+	index *hashIndex
+	// queued holds the files of the current run, or of an unfinished previous one.
+	queued *queueState
+	mode   Mode
+	// runID is the ID of the current run.
+	runID string
+	// staging maps the remote path of mappings to the directory their downloads are staged in.
+	staging map[string]string
+	// pausedUntil is when retries may start again after the remote asked to wait.
+	pauseMu     sync.Mutex
+	pausedUntil time.Time
+	// lastEventID is the ID of the last server-sent event, to resume the subscription with.
+	lastEventID string
+	// listing is the last listing, for conditional requests.
+	listing *cachedListing
+	// seen holds the files of the previous listing, until seenExpires.
+	seen        map[string]bool
+	seenExpires time.Time
+	// listed is closed once the listing of the current run is complete, see settle.
+	listed chan struct{}
+}
+
+func New(c *config.Configuration) *Engine {
+	transport := newTransport(c)
+	e := &Engine{
+		c:         c,
+		client:    &http.Client{Transport: transport},
+		transport: transport,
+		mapper:    mapping.New(c.RootMapping),
+		mirrors:   newMirrorSet(httpBase(c.Remote), c.Mirrors),
+		handlers:  make([]Handler, 0),
+		limiters:  make(map[string]*ratelimit.Bucket),
+		slots:     make(map[string]chan struct{}),
+		mode:      ModeSync,
+		listed:    make(chan struct{}),
+	}
+	close(e.listed)
+	e.backend = newBackend(e)
+
+	switch c.Auth.Type {
+	case config.AuthOAuth2:
+		e.tokens = &tokenSource{c: c.Auth, client: e.client}
+	case config.AuthDigest:
+		e.digest = &digestAuth{user: c.UserName, password: c.Password}
+	}
+	if c.MaxBandwidth > 0 {
+		e.limiter = ratelimit.New(int64(c.MaxBandwidth))
+	}
+	for _, m := range c.RootMapping {
+		if m.MaxBandwidth > 0 {
+			e.limiters[mapping.Clean(m.RemotePath)] = ratelimit.New(int64(m.MaxBandwidth))
+		}
+		if m.Concurrency > 0 {
+			e.slots[mapping.Clean(m.RemotePath)] = make(chan struct{}, m.Concurrency)
+		}
+	}
+	return e
+}
+
+// mappingLimiter returns the bucket of the mapping of webPath, nil if the mapping has no limit of its own.
+func (e *Engine) mappingLimiter(webPath string) *ratelimit.Bucket {
+	m, ok := e.mapper.Find(webPath)
+	if !ok {
+		return nil
+	}
+	return e.limiters[m.RemotePath]
+}
+
+// mappingSlots returns the semaphore of the mapping of webPath, nil if the mapping has no concurrency of its own.
+func (e *Engine) mappingSlots(webPath string) chan struct{} {
+	m, ok := e.mapper.Find(webPath)
+	if !ok {
+		return nil
+	}
+	return e.slots[m.RemotePath]
+}
+
+// Subscribe registers a handler that receives all events of all subsequent runs.
+func (e *Engine) Subscribe(h Handler) {
+	e.handlers = append(e.handlers, h)
+}
+
+// SetLimiter makes all downloads share the bandwidth of b, a nil bucket removes the limit. The limits of the
+// mappings still apply.
+func (e *Engine) SetLimiter(b *ratelimit.Bucket) {
+	e.limiter = b
+}
+
+// SetEncryptionKey makes the engine encrypt downloads with key, they get crypt.Ext appended to their name.
+func (e *Engine) SetEncryptionKey(key []byte) {
+	e.key = key
+}
+
+func (e *Engine) emit(ev Event) {
+	for _, h := range e.handlers {
+		h(ev)
+	}
+}
+
+// Run fetches the list of files from the remote and synchronises them. The returned error is only set if the
+// run couldn't happen at all, was cancelled via ctx or was aborted because of a fatal error, the outcome of the
+// separate files is in the result.
+func (e *Engine) Run(ctx context.Context) (*Result, error) {
+	res := newResult()
+	res.Mode = e.mode
+	e.runID = res.ID
+	err := e.run(ctx, res)
+	if cerr := e.backend.close(); cerr != nil {
+		e.emit(Event{Type: Warning, Err: cerr})
+	}
+	if e.mirrored != nil {
+		if ferr := e.mirrored.flush(); ferr != nil {
+			e.emit(Event{Type: Warning, Err: ferr})
+		}
+	}
+	if e.c.Name != "" {
+		res.label(e.c.Name)
+	}
+	res.Failovers = e.mirrors.takeFailovers()
+	res.finish(err)
+	e.emit(Event{Type: RunDone, Err: err})
+	return res, err
+}
+
+func (e *Engine) run(ctx context.Context, res *Result) error {
+	if err := CheckBackend(e.c); err != nil {
+		return err
+	}
+	if e.staging == nil {
+		e.prepareStaging()
+	}
+	if e.perms == nil {
+		if err := e.preparePermissions(); err != nil {
+			return fmt.Errorf("invalid permissions: %w", err)
+		}
+	}
+	if e.filters == nil {
+		filters, err := filter.FromConfig(e.c)
+		if err != nil {
+			return fmt.Errorf("invalid filters: %w", err)
+		}
+		e.filters = filters
+	}
+	if e.compress == nil && len(e.c.HTTP.CompressPatterns) > 0 {
+		compress, err := filter.New(e.c.HTTP.CompressPatterns, nil)
+		if err != nil {
+			return fmt.Errorf("invalid compress_patterns: %w", err)
+		}
+		e.compress = compress
+	}
+
+	if e.mirrored == nil {
+		if e.c.MirrorState == "" && e.HasPushMappings() {
+			return errors.New("push mappings need a mirror_state to keep track of their uploads")
+		}
+		mirrored, err := loadMirrorState(e.c.MirrorState)
+		if err != nil {
+			return err
+		}
+		e.mirrored = mirrored
+	}
+	if e.index == nil {
+		index, err := loadHashIndex(e.c.DedupeIndex)
+		if err != nil {
+			return err
+		}
+		e.index = index
+	}
+	if e.queued == nil {
+		queued, err := loadQueueState(e.c.QueueState)
+		if err != nil {
+			return err
+		}
+		e.queued = queued
+	}
+
+	if err := e.checkHealth(ctx); err != nil {
+		return err
+	}
+
+	if e.c.DryRun {
+		return e.dryRun(ctx, res)
+	}
+	if e.mode == ModeUpload {
+		return e.pushPhase(ctx, res)
+	}
+
+	if e.journal != nil {
+		if err := e.recoverJournal(ctx, res); err != nil {
+			return fmt.Errorf("couldn't recover from journal: %w", err)
+		}
+	}
+
+	pending, err := e.process(ctx, res, func(add func([]wp) error) error {
+		return e.listFiles(ctx, add)
+	})
+	if e.deferDeletes() {
+		e.deletePhase(ctx, res, pending, err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.pushPhase(ctx, res); err != nil {
+		return err
+	}
+
+	if e.journal != nil {
+		if err := e.journal.Compact(); err != nil {
+			e.emit(Event{Type: Warning, Err: err})
+		}
+	}
+
+	return nil
+}
+
+// listFiles hands the files of the run to add, which are the unfinished files of the previous run if it didn't
+// finish. Otherwise the listing is handed to add a page at a time, so the downloads can start before it is
+// complete.
+func (e *Engine) listFiles(ctx context.Context, add func([]wp) error) error {
+	if files := e.queued.pending(); len(files) > 0 {
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("resuming %d files of an unfinished run", len(files))})
+		_ = e.prewarm(ctx)
+		return add(files)
+	}
+
+	if err := e.queued.reset(nil); err != nil {
+		return err
+	}
+	previous := e.previousListing()
+	warm := false
+	err := e.backend.list(ctx, func(page []wp) error {
+		page = e.allowed(e.pulled(e.notMirrored(e.unseen(previous, page))))
+		if err := e.queued.add(page); err != nil {
+			return err
+		}
+		// Warming up is best effort, any real problems will show up on the actual downloads.
+		if !warm && len(page) > 0 {
+			warm = true
+			_ = e.prewarm(ctx)
+		}
+		return add(page)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't get file list: %w", err)
+	}
+	return nil
+}
+
+// fileContext returns the context for synchronising a single file, which expires after the file timeout.
+func (e *Engine) fileContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.c.FileTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, e.c.FileTimeout)
+}
+
+// syncFile synchronises a single file, emitting its events.
+func (e *Engine) syncFile(ctx context.Context, f wp) FileResult {
+	start := time.Now()
+	e.emit(Event{Type: FileStarted, File: f.WebPath, Total: -1})
+
+	fctx, cancel := e.fileContext(ctx)
+	defer cancel()
+
+	local, t, retries, err := e.getFileRetrying(fctx, f)
+	if err == nil {
+		var deleteRetries int
+		deleteRetries, err = e.settle(fctx, f, local)
+		retries += deleteRetries
+	}
+	fr := FileResult{
+		File:        f.WebPath,
+		Local:       local,
+		Outcome:     Downloaded,
+		Bytes:       t.size,
+		Transferred: t.transferred,
+		Duration:    time.Since(start),
+		Retries:     retries,
+	}
+	if isSkip(err) {
+		fr.Outcome = Skipped
+		fr.Err = err
+		e.emit(Event{Type: FileSkipped, File: f.WebPath, Err: err})
+		return fr
+	}
+	// Files that were interrupted by the end of the run are left for the next one, like files that don't fit yet.
+	if isDeferral(ctx, err) {
+		fr.Outcome = Deferred
+		fr.Err = err
+		e.emit(Event{Type: FileSkipped, File: f.WebPath, Err: err})
+		return fr
+	}
+	if err != nil {
+		fr.Outcome = Failed
+		fr.Err = err
+		e.emit(Event{Type: FileFailed, File: f.WebPath, Err: err})
+		return fr
+	}
+
+	// Deferred deletes send FileDone once the file is gone from the remote.
+	if !e.deferredDelete(f.WebPath) {
+		e.emit(Event{Type: FileDone, File: f.WebPath, Bytes: t.size, Total: t.size})
+	}
+	return fr
+}
+
+// deferQueue marks everything that is left in q as deferred.
+func deferQueue(res *Result, q *queue.Queue, reason string) {
+	for {
+		v, ok := q.Pop()
+		if !ok {
+			return
+		}
+		res.add(FileResult{File: v.(wp).WebPath, Outcome: Deferred, Reason: reason})
+	}
+}
+
+// previousListing starts a new listing and returns the files of the previous one, which unseen filters out. Every
+// now and then the cache expires so that files that failed before get another chance.
+func (e *Engine) previousListing() map[string]bool {
+	if e.c.ListingCache <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	previous := e.seen
+	if now.After(e.seenExpires) {
+		previous = nil
+		e.seenExpires = now.Add(e.c.ListingCache)
+	}
+	e.seen = make(map[string]bool)
+	return previous
+}
+
+// unseen filters out the files that were in the previous listing, so that runs in short succession only look
+// at new files.
+func (e *Engine) unseen(previous map[string]bool, files []wp) []wp {
+	if e.c.ListingCache <= 0 {
+		return files
+	}
+
+	fresh := make([]wp, 0, len(files))
+	for _, f := range files {
+		e.seen[f.WebPath] = true
+		if !previous[f.WebPath] {
+			fresh = append(fresh, f)
+		}
+	}
+	return fresh
+}
+
+// allowed filters out the files that are excluded by the filters, they aren't part of the run at all.
+func (e *Engine) allowed(files []wp) []wp {
+	kept := make([]wp, 0, len(files))
+	for _, f := range files {
+		if m, _ := e.mapper.Find(f.WebPath); e.filters.Allows(f.WebPath, m) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// prepareStaging decides per mapping whether the staging dir can be used, downloads can only be moved into
+// place cheaply and atomically if the staging dir is on the same filesystem as the destination.
+func (e *Engine) prepareStaging() {
+	e.staging = make(map[string]string)
+	if e.c.StagingDir == "" {
+		return
+	}
+	// The staging dir of a further remote is a directory in the configured one, see config.Split.
+	if err := os.MkdirAll(e.c.StagingDir, 0755); err != nil {
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("couldn't create staging dir, staging in destination: %w", err)})
+		return
+	}
+
+	for _, m := range e.c.RootMapping {
+		same, err := sameFilesystem(e.c.StagingDir, m.LocalPath)
+		if err != nil {
+			err = fmt.Errorf("can't compare filesystems of %s and %s, staging in destination: %w",
+				e.c.StagingDir, m.LocalPath, err)
+			e.emit(Event{Type: Warning, Err: err})
+			continue
+		}
+		if !same {
+			err = fmt.Errorf("%s is not on the same filesystem as %s, staging in destination",
+				e.c.StagingDir, m.LocalPath)
+			e.emit(Event{Type: Warning, Err: err})
+			continue
+		}
+		e.staging[mapping.Clean(m.RemotePath)] = e.c.StagingDir
+	}
+}
+
+// stagingDir returns the directory to stage a download in that will end up in dir.
+func (e *Engine) stagingDir(webPath, dir string) string {
+	if m, ok := e.mapper.Find(webPath); ok {
+		if sd, ok := e.staging[m.RemotePath]; ok {
+			return sd
+		}
+	}
+	return dir
+}
+
+// queue orders the files by the priority of their mapping plus the priority hint of the server, files with the
+// same priority are in the download order.
+func (e *Engine) queue(files []wp) *queue.Queue {
+	q := queue.New()
+	e.enqueue(q, make(map[string]bool, len(files)), files)
+	return q
+}
+
+// enqueue adds files to q, see queue. The download order only applies within files, files that are added later
+// come after the earlier ones with the same priority. The server can list a file more than once, only the first
+// entry is queued, seen holds the files that were queued before.
+func (e *Engine) enqueue(q *queue.Queue, seen map[string]bool, files []wp) {
+	for _, f := range e.ordered(files) {
+		if seen[f.WebPath] {
+			continue
+		}
+		seen[f.WebPath] = true
+
+		prio := f.Priority
+		if m, ok := e.mapper.Find(f.WebPath); ok {
+			prio += m.Priority
+		}
+		q.Push(f, prio)
+	}
+}
+
+// target decides whether f is downloaded and returns where to, without changing anything. A skip error means
+// the file is left alone, see getFile.
+func (e *Engine) target(ctx context.Context, f wp) (string, error) {
+	localFile, ok := e.mapper.Local(f.WebPath)
+	if !ok {
+		return "", skip(fmt.Errorf("couldn't find config for remote file %s: %w", f.WebPath, ErrNoMapping))
+	}
+	if m, _ := e.mapper.Find(f.WebPath); !e.filters.Allows(f.WebPath, m) {
+		return "", skip(fmt.Errorf("%s is excluded by the filters", f.WebPath))
+	}
+	if err := e.checkAge(ctx, f); err != nil {
+		return "", err
+	}
+	if e.key != nil {
+		localFile += crypt.Ext
+	}
+	if err := e.checkExisting(ctx, f, localFile); err != nil {
+		return localFile, err
+	}
+	return e.resolveConflict(ctx, f, localFile)
+}
+
+// getFile downloads and verifies f, and returns where it was stored with a description of the transfer. It
+// leaves the remote file alone, see settle. A file that is already in place counts as downloaded, so that it
+// is settled instead of being compared again on every run.
+func (e *Engine) getFile(ctx context.Context, f wp) (string, transfer, error) {
+	localFile, err := e.target(ctx, f)
+	if errors.Is(err, errIdentical) {
+		return localFile, transfer{size: f.Size}, nil
+	}
+	if err != nil {
+		return localFile, transfer{}, err
+	}
+	if e.linkDuplicate(f, localFile) {
+		return localFile, transfer{size: f.Size}, nil
+	}
+
+	digest, _ := hex.DecodeString(f.SHA256)
+	t, err := e.downloadMirrored(ctx, f.WebPath, localFile, f.Size, digest)
+	if err != nil {
+		return localFile, t, err
+	}
+	if err := e.index.add(contentDigest(f, t), localFile); err != nil {
+		e.emit(Event{Type: Warning, File: f.WebPath, Err: err})
+	}
+	e.applyFilePermissions(f.WebPath, localFile)
+	e.applyModTime(localFile, f, t)
+	e.applyMetadata(localFile, f)
+	return localFile, t, nil
+}
+
+// settle deals with the remote copy of a file that is in place and verified at local. It is deleted with
+// retries, unless deletes are deferred or the mapping keeps remote files. It returns how often the delete was
+// retried.
+func (e *Engine) settle(ctx context.Context, f wp, local string) (int, error) {
+	if !e.deletesRemote(f.WebPath) {
+		return 0, e.keep(f, local)
+	}
+	if e.deferDeletes() {
+		return 0, nil
+	}
+	// Deleting files would shift the pages of the listing that are still to come, paging by offset would skip files.
+	select {
+	case <-e.listed:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return e.retry(ctx, f.WebPath, func() error {
+		return e.removeRemote(ctx, f.WebPath, local)
+	})
+}
+
+// removeRemote deletes or archives a file that is in place locally on the remote, depending on the completion
+// of its mapping. A file that is already gone counts as removed.
+func (e *Engine) removeRemote(ctx context.Context, webPath, local string) error {
+	if err := e.backend.remove(ctx, webPath); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return e.record(webPath, local, "", journal.Deleted)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// snippetLen is the amount of bytes of an error response that is included in errors.
+const snippetLen = 256
+
+// Errors returned by the engine are wrapped around one of these, use errors.Is to check for them.
+var (
+	ErrAuth              = errors.New("authentication failed")
+	ErrNotFound          = errors.New("not found")
+	ErrNoMapping         = errors.New("no mapping for remote path")
+	ErrDiskFull          = errors.New("disk full")
+	ErrChecksumMismatch  = errors.New("checksum mismatch")
+	ErrSizeMismatch      = errors.New("size mismatch")
+	ErrRemoteUnavailable = errors.New("remote unavailable")
+	// ErrRateLimited means the remote refused a request because too many were made.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInsufficientSpace means a file was skipped because it doesn't fit on the destination.
+	ErrInsufficientSpace = errors.New("insufficient space")
+	// ErrLowSpace means a file was deferred because the free space is below the watermark of its mapping.
+	ErrLowSpace = errors.New("free space below watermark")
+)
+
+// errRemoteChanged means the remote file changed during a segmented download, so it has to start over.
+var errRemoteChanged = errors.New("remote file changed during the download")
+
+// errAge means a file was deferred because it is younger than the minimum age or older than the maximum age.
+var errAge = errors.New("outside the age limits")
+
+// errIdentical means a file is already in place locally, it is settled without downloading it again.
+var errIdentical = errors.New("identical to the remote file")
+
+// Kinds lists all error kinds in the order they should be presented.
+var Kinds = []error{
+	ErrAuth,
+	ErrRemoteUnavailable,
+	ErrRateLimited,
+	ErrDiskFull,
+	ErrInsufficientSpace,
+	ErrLowSpace,
+	ErrNoMapping,
+	ErrNotFound,
+	ErrChecksumMismatch,
+	ErrSizeMismatch,
+}
+
+// KindOf returns the kind of err, or nil if it isn't of a known kind.
+func KindOf(err error) error {
+	for _, k := range Kinds {
+		if errors.Is(err, k) {
+			return k
+		}
+	}
+	return nil
+}
+
+// fatalKinds are the kinds of errors that will make every following file fail as well.
+var fatalKinds = []error{
+	ErrAuth,
+	ErrDiskFull,
+}
+
+// IsFatal reports whether err should abort the whole run instead of just skipping the file.
+func IsFatal(err error) bool {
+	for _, k := range fatalKinds {
+		if errors.Is(err, k) {
+			return true
+		}
+	}
+	return false
+}
+
+type classified struct {
+	kind error
+	err  error
+}
+
+func (c *classified) Error() string {
+	return fmt.Sprintf("%s: %s", c.kind, c.err)
+}
+
+func (c *classified) Unwrap() error {
+	return c.err
+}
+
+func (c *classified) Is(target error) bool {
+	return target == c.kind
+}
+
+// classify marks err as being of kind, while keeping err itself in the chain.
+func classify(kind, err error) error {
+	return &classified{kind: kind, err: err}
+}
+
+// HTTPError is returned when the remote responds with an unexpected status code.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Snippet is the start of the response body, which usually explains what went wrong.
+	Snippet string
+	// RetryAfter is how long the remote asked to wait before trying again, zero if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (h *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d %s", h.Method, h.URL, h.StatusCode, http.StatusText(h.StatusCode))
+	if h.Snippet != "" {
+		msg += fmt.Sprintf(" (%s)", h.Snippet)
+	}
+	return msg
+}
+
+// checkResponse returns an error if resp doesn't have a successful status code, the error is classified by the
+// status code.
+func checkResponse(resp *http.Response) error {
+	code := resp.StatusCode
+	if code >= http.StatusOK && code < http.StatusMultipleChoices {
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, snippetLen))
+	err := &HTTPError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: code,
+		Snippet:    strings.Join(strings.Fields(string(b)), " "),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return classify(ErrAuth, err)
+	case code == http.StatusTooManyRequests:
+		return classify(ErrRateLimited, err)
+	case code == http.StatusNotFound:
+		return classify(ErrNotFound, err)
+	case code >= http.StatusInternalServerError:
+		return classify(ErrRemoteUnavailable, err)
+	default:
+		return err
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, which has either a delay in seconds or a date. It returns zero if
+// the header is missing or invalid.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// skipError marks a file as deliberately not synchronised, it is reported as skipped instead of failed.
+type skipError struct {
+	err error
+}
+
+func (s *skipError) Error() string {
+	return s.err.Error()
+}
+
+func (s *skipError) Unwrap() error {
+	return s.err
+}
+
+// skip marks err as the reason a file is skipped.
+func skip(err error) error {
+	return &skipError{err: err}
+}
+
+// isDeferral reports whether err leaves the file for a later run.
+func isDeferral(ctx context.Context, err error) bool {
+	return errors.Is(err, ErrLowSpace) || errors.Is(err, errAge) || ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
+
+// isSkip reports whether err means the file was skipped.
+func isSkip(err error) bool {
+	var s *skipError
+	return errors.As(err, &s)
+}
+
+// diskError classifies errors that come from writing to the local filesystem.
+func diskError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return classify(ErrDiskFull, err)
+	}
+	return err
+}
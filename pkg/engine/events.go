@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import "sync/atomic"
+
+// EventType identifies what happened during a sync run.
+type EventType int
+
+const (
+	// FileStarted is sent when the engine starts working on a file.
+	FileStarted EventType = iota
+	// Progress is sent while a file is being downloaded.
+	Progress
+	// FileDone is sent when a file has been downloaded and removed from the remote.
+	FileDone
+	// FileFailed is sent when a file couldn't be synchronised, Err holds the reason.
+	FileFailed
+	// RunDone is sent once at the end of a run, Err is set if the run itself failed.
+	RunDone
+	// Warning is sent for problems the engine worked around, Err describes the problem.
+	Warning
+	// FileSkipped is sent when a file is deliberately not synchronised, Err holds the reason.
+	FileSkipped
+)
+
+// Event describes a single thing that happened during a sync run.
+type Event struct {
+	Type EventType
+	// File is the remote path of the file the event is about, empty for RunDone and Warning.
+	File string
+	// Bytes is the amount of bytes downloaded so far.
+	Bytes int64
+	// Total is the expected size of the file, or -1 if unknown.
+	Total int64
+	Err   error
+}
+
+// Handler receives events from the engine, it is called synchronously so it should not block.
+// It can be called from several goroutines at once.
+type Handler func(Event)
+
+// progressWriter emits Progress events for the bytes written to it, it is safe for concurrent use.
+type progressWriter struct {
+	e     *Engine
+	file  string
+	total int64
+	done  int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	done := p.add(int64(len(b)))
+	p.e.emit(Event{Type: Progress, File: p.file, Bytes: done, Total: p.total})
+	return len(b), nil
+}
+
+// add counts n bytes as done without emitting an event, it returns the new total.
+func (p *progressWriter) add(n int64) int64 {
+	return atomic.AddInt64(&p.done, n)
+}
+
+// attemptProgress passes writes on to p, counting the bytes of a single attempt.
+type attemptProgress struct {
+	p *progressWriter
+	n int64
+}
+
+func (a *attemptProgress) Write(b []byte) (int, error) {
+	a.n += int64(len(b))
+	return a.p.Write(b)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+// checkExisting skips f if local already exists and is identical to it, according to the configured
+// comparison. The skip wraps errIdentical, see getFile. Anything that can't be compared is downloaded.
+func (e *Engine) checkExisting(ctx context.Context, f wp, local string) error {
+	mode := e.c.SkipExisting
+	if mode != config.SkipExistingSize && mode != config.SkipExistingHash {
+		return nil
+	}
+	fi, err := os.Stat(local)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	size := f.Size
+	digest, _ := hex.DecodeString(f.SHA256)
+	if size <= 0 || mode == config.SkipExistingHash && len(digest) == 0 {
+		remote, err := e.location(f.WebPath)
+		if err != nil {
+			return nil
+		}
+		size, digest = e.remoteInfo(ctx, remote)
+	}
+	if size < 0 {
+		return nil
+	}
+	if e.key != nil {
+		size = crypt.EncryptedSize(size)
+	}
+	if fi.Size() != size {
+		return nil
+	}
+
+	if mode == config.SkipExistingHash {
+		if digest == nil {
+			return nil
+		}
+		sum, err := e.localDigest(local)
+		if err != nil || !bytes.Equal(sum, digest) {
+			return nil
+		}
+	}
+	return skip(fmt.Errorf("%s is %w", local, errIdentical))
+}
+
+// localDigest returns the sha-256 digest of the file at p, of its decrypted content if downloads are encrypted.
+func (e *Engine) localDigest(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return e.readerDigest(f)
+}
+
+// readerDigest returns the sha-256 digest of r, of its decrypted content if downloads are encrypted.
+func (e *Engine) readerDigest(r io.Reader) ([]byte, error) {
+	var err error
+	if e.key != nil {
+		if r, err = crypt.NewReader(r, e.key); err != nil {
+			return nil, err
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func device(p string) (uint64, error) {
+	fi, err := os.Stat(existingParent(p))
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("can't determine device of %s", p)
+	}
+	return uint64(st.Dev), nil //nolint:unconvert // Dev isn't an uint64 on all platforms.
+}
+
+// sameFilesystem reports whether a and b, or their closest existing parents, are on the same filesystem.
+func sameFilesystem(a, b string) (bool, error) {
+	da, err := device(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := device(b)
+	if err != nil {
+		return false, err
+	}
+	return da == db, nil
+}
+
+// freeSpace returns the amount of bytes available to unprivileged users on the filesystem of p.
+func freeSpace(p string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(existingParent(p), &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil //nolint:unconvert // The types differ per platform.
+}
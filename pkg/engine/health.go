@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout limits how long the remote may take to answer the health check.
+const healthCheckTimeout = 10 * time.Second
+
+// checkHealth requests the configured health check endpoint of the remote, and of its mirrors if the remote
+// doesn't answer. It fails with the error of the remote if none of them is healthy, so a remote that is down ends
+// the run with a single error instead of one per request.
+func (e *Engine) checkHealth(ctx context.Context) error {
+	if e.c.HealthCheck == "" || !e.httpRemote() {
+		return nil
+	}
+
+	var first error
+	for _, m := range e.mirrors.ordered() {
+		err := e.healthy(ctx, m.base)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		e.mirrors.failed(m)
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// healthy requests the health check endpoint below base.
+func (e *Engine) healthy(ctx context.Context, base string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	u, err := joinURL(base, e.c.HealthCheck)
+	if err != nil {
+		return fmt.Errorf("couldn't parse remote: %w", err)
+	}
+	resp, err := e.reqWithAuth(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return fmt.Errorf("health check of %s failed: %w", base, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("health check of %s failed: %w", base, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleReader cancels a transfer when no data has been read for longer than the timeout. Unlike a deadline on
+// the whole transfer this allows huge files to take as long as they need, as long as data keeps flowing.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+// newIdleReader wraps r, calling cancel when r is idle for timeout, which should abort the pending read.
+func newIdleReader(r io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&ir.expired, 1)
+		cancel()
+	})
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if atomic.LoadInt32(&ir.expired) == 1 {
+		return n, classify(ErrRemoteUnavailable, fmt.Errorf("no data received for %s", ir.timeout))
+	}
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// Stop stops the timer, it has to be called when the transfer is done.
+func (ir *idleReader) Stop() {
+	ir.timer.Stop()
+}
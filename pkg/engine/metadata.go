@@ -0,0 +1,80 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// xattrPrefix is the namespace of the extended attributes that are set on downloads.
+	xattrPrefix = "user."
+	// provenancePrefix is prepended to the names of the provenance attributes.
+	provenancePrefix = xattrPrefix + "mediasync."
+)
+
+// metadata returns the extended attributes to set on the download of f.
+func (e *Engine) metadata(f wp) map[string]string {
+	attrs := make(map[string]string)
+	if e.c.PreserveMetadata {
+		for k, v := range f.Metadata {
+			attrs[xattrPrefix+k] = v
+		}
+	}
+	if e.c.Provenance {
+		attrs[provenancePrefix+"source"] = e.c.Remote + f.WebPath
+		attrs[provenancePrefix+"synced"] = time.Now().UTC().Format(time.RFC3339)
+		attrs[provenancePrefix+"run"] = e.runID
+	}
+	return attrs
+}
+
+// applyModTime gives local the modification time of the remote file, from the listing or else from the
+// response, so it sorts by its original date.
+func (e *Engine) applyModTime(local string, f wp, t transfer) {
+	modified := f.Modified
+	if modified.IsZero() {
+		modified = t.modified
+	}
+	if modified.IsZero() {
+		return
+	}
+	if err := os.Chtimes(local, time.Now(), modified); err != nil {
+		e.emit(Event{Type: Warning, File: f.WebPath, Err: fmt.Errorf("couldn't set modification time: %w", err)})
+	}
+}
+
+// applyMetadata sets the metadata of f on local. Not every filesystem supports extended attributes, so
+// failures are only reported as warnings.
+func (e *Engine) applyMetadata(local string, f wp) {
+	attrs := e.metadata(f)
+	names := make([]string, 0, len(attrs))
+	for k := range attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := setXattr(local, name, attrs[name]); err != nil {
+			e.emit(Event{Type: Warning, File: f.WebPath, Err: fmt.Errorf("couldn't set %s on %s: %w", name, local, err)})
+			return
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mirrorDownTime is how long a mirror that failed is only used as a last resort.
+	mirrorDownTime = 5 * time.Minute
+	// rateWeight is the weight of the last download in the measured transfer rate of a mirror.
+	rateWeight = 0.3
+)
+
+// mirror is a base URL file bodies can be downloaded from.
+type mirror struct {
+	base string
+	// rate is the measured transfer rate in bytes per second, zero if the mirror hasn't been used yet.
+	rate      float64
+	downUntil time.Time
+}
+
+// mirrorSet keeps track of the health and speed of the mirrors.
+type mirrorSet struct {
+	mu      sync.Mutex
+	mirrors []*mirror
+	// failovers describes the requests that succeeded on another mirror after the one they were sent to failed.
+	failovers []string
+}
+
+// newMirrorSet returns the mirrors, with remote as the first one.
+func newMirrorSet(remote string, mirrors []string) *mirrorSet {
+	s := &mirrorSet{mirrors: []*mirror{{base: remote}}}
+	for _, m := range mirrors {
+		if m != remote {
+			s.mirrors = append(s.mirrors, &mirror{base: m})
+		}
+	}
+	return s
+}
+
+// ordered returns the mirrors in the order they should be tried. Healthy mirrors go first, mirrors that
+// haven't been measured before the others so they get measured, then the fastest ones.
+func (s *mirrorSet) ordered() []*mirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ms := make([]*mirror, len(s.mirrors))
+	copy(ms, s.mirrors)
+	sort.SliceStable(ms, func(i, j int) bool {
+		downI, downJ := ms[i].downUntil.After(now), ms[j].downUntil.After(now)
+		if downI != downJ {
+			return !downI
+		}
+		if (ms[i].rate == 0) != (ms[j].rate == 0) {
+			return ms[i].rate == 0
+		}
+		return ms[i].rate > ms[j].rate
+	})
+	return ms
+}
+
+func (s *mirrorSet) succeeded(m *mirror, n int64, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m.downUntil = time.Time{}
+	if d <= 0 || n <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	if m.rate == 0 {
+		m.rate = rate
+		return
+	}
+	m.rate = rateWeight*rate + (1-rateWeight)*m.rate
+}
+
+// failedOver records that what was served by m because the mirrors in down were unavailable.
+func (s *mirrorSet) failedOver(what string, m *mirror, down []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failovers = append(s.failovers, fmt.Sprintf("%s from %s, %s unavailable", what, m.base, strings.Join(down, ", ")))
+}
+
+// takeFailovers returns the failovers since the last call.
+func (s *mirrorSet) takeFailovers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.failovers
+	s.failovers = nil
+	return f
+}
+
+func (s *mirrorSet) failed(m *mirror) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m.downUntil = time.Now().Add(mirrorDownTime)
+}
+
+// downloadMirrored downloads webPath to local from the best mirror, failing over to the next one when a
+// mirror is unavailable.
+func (e *Engine) downloadMirrored(
+	ctx context.Context, webPath, local string, size int64, digest []byte,
+) (transfer, error) {
+	if !e.httpRemote() {
+		return e.downloadFile(ctx, webPath, webPath, local, size, digest)
+	}
+
+	var (
+		t    transfer
+		err  error
+		down []string
+	)
+	for _, m := range e.mirrors.ordered() {
+		u, perr := joinURL(m.base, webPath)
+		if perr != nil {
+			return transfer{}, fmt.Errorf("couldn't parse mirror %s: %w", m.base, perr)
+		}
+
+		t, err = e.downloadFile(ctx, webPath, u.String(), local, size, digest)
+		if err == nil {
+			e.mirrors.succeeded(m, t.transferred, t.elapsed)
+			if len(down) > 0 {
+				e.mirrors.failedOver(webPath, m, down)
+			}
+			return t, nil
+		}
+		if !errors.Is(err, ErrRemoteUnavailable) || ctx.Err() != nil {
+			return t, err
+		}
+
+		e.mirrors.failed(m)
+		down = append(down, m.base)
+		e.emit(Event{Type: Warning, File: webPath, Err: fmt.Errorf("mirror %s failed: %w", m.base, err)})
+	}
+	return t, err
+}
+
+// listMirrored gets the listing from the remote, failing over to the mirrors when it is unavailable. It returns
+// the error of the remote if none of the mirrors has a listing either. A mirror lists from the start again, add
+// can get files it already got from the remote.
+func (e *Engine) listMirrored(ctx context.Context, add func(page []wp) error) error {
+	remote := e.mirrors.mirrors[0]
+	err := e.getFiles(ctx, remote.base, add)
+	if err == nil || !errors.Is(err, ErrRemoteUnavailable) || ctx.Err() != nil {
+		return err
+	}
+	e.mirrors.failed(remote)
+	e.emit(Event{Type: Warning, Err: fmt.Errorf("remote %s failed: %w", remote.base, err)})
+
+	down := []string{remote.base}
+	for _, m := range e.mirrors.ordered() {
+		if m == remote {
+			continue
+		}
+		merr := e.getFiles(ctx, m.base, add)
+		if merr == nil {
+			e.mirrors.failedOver("listing", m, down)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return merr
+		}
+		if errors.Is(merr, ErrRemoteUnavailable) {
+			e.mirrors.failed(m)
+		}
+		down = append(down, m.base)
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("mirror %s failed: %w", m.base, merr)})
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+)
+
+// mirrorBatch is how many files are added to the mirror state before it is stored, storing it after every file
+// would take quadratic time on large mirrors. The files that weren't stored when the client crashes are
+// transferred once more.
+const mirrorBatch = 100
+
+// mirrorState keeps track of the downloads of mappings that don't delete remote files and the uploads of push
+// mappings, so that they aren't transferred again. It is stored in batches and at the end of every run, see
+// flush. It is safe for concurrent use.
+type mirrorState struct {
+	mu sync.Mutex
+	// path is where the state is stored, it is only kept in memory if it is empty.
+	path string
+	// files maps remote paths to their size on the remote, zero if the remote didn't supply it.
+	files map[string]int64
+	// unsaved is the amount of files that were added since the state was stored.
+	unsaved int
+}
+
+func loadMirrorState(p string) (*mirrorState, error) {
+	s := &mirrorState{path: p, files: make(map[string]int64)}
+	if p == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read mirror state: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.files); err != nil {
+		return nil, fmt.Errorf("couldn't parse mirror state %s: %w", p, err)
+	}
+	return s, nil
+}
+
+// has reports whether f was downloaded before, a changed size means the file was replaced on the remote.
+func (s *mirrorState) has(f wp) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size, ok := s.files[f.WebPath]
+	return ok && (size <= 0 || f.Size <= 0 || size == f.Size)
+}
+
+// add records f as downloaded, the state is stored once a batch of files was added.
+func (s *mirrorState) add(f wp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[f.WebPath] = f.Size
+	s.unsaved++
+	if s.unsaved < mirrorBatch {
+		return nil
+	}
+	return s.store()
+}
+
+// flush stores the files that were added since the state was stored last.
+func (s *mirrorState) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unsaved == 0 {
+		return nil
+	}
+	return s.store()
+}
+
+// store writes the state, s.mu has to be held.
+func (s *mirrorState) store() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.files)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, b); err != nil {
+		return fmt.Errorf("couldn't store mirror state: %w", err)
+	}
+	s.unsaved = 0
+	return nil
+}
+
+// writeFileAtomic replaces the file at p with b, readers see either the old or the new content.
+func writeFileAtomic(p string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// completion returns what happens to the remote file once webPath is downloaded.
+func (e *Engine) completion(webPath string) string {
+	m, _ := e.mapper.Find(webPath)
+	switch {
+	case m.Completion != "":
+		return m.Completion
+	case m.DeleteRemote != nil && !*m.DeleteRemote:
+		return config.CompletionCopy
+	default:
+		return config.CompletionMove
+	}
+}
+
+// deletesRemote reports whether downloads of webPath are removed from the remote, by deleting or archiving
+// them. That is the default.
+func (e *Engine) deletesRemote(webPath string) bool {
+	return e.completion(webPath) != config.CompletionCopy
+}
+
+// deferredDelete reports whether the download of webPath is deleted in the delete phase at the end of the run.
+func (e *Engine) deferredDelete(webPath string) bool {
+	return e.deferDeletes() && e.deletesRemote(webPath)
+}
+
+// notMirrored filters out the files of mappings that don't delete remote files, which were downloaded before.
+func (e *Engine) notMirrored(files []wp) []wp {
+	fresh := make([]wp, 0, len(files))
+	for _, f := range files {
+		if e.deletesRemote(f.WebPath) || !e.mirrored.has(f) {
+			fresh = append(fresh, f)
+		}
+	}
+	return fresh
+}
+
+// keep records that the download of f stays on the remote.
+func (e *Engine) keep(f wp, local string) error {
+	if err := e.mirrored.add(f); err != nil {
+		return err
+	}
+	return e.record(f.WebPath, local, "", journal.Kept)
+}
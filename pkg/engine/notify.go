@@ -0,0 +1,143 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/websocket"
+)
+
+// Watch keeps a connection to the remote to be notified of new files, a WebSocket connection to /notifications or
+// a subscription to the server-sent events of /events, and sends the remote path of every file the remote
+// announces on files. Announcements are dropped while files is full, as a single pending announcement is enough to
+// start a run. Dropped connections are reopened with backoff. Watch returns when ctx is done, or when the remote
+// refuses the connection.
+func (e *Engine) Watch(ctx context.Context, files chan<- string) error {
+	if _, ok := e.backend.(*httpBackend); !ok {
+		return errors.New("watching for new files needs a mediasync remote")
+	}
+	watch := e.watchWebSocket
+	if e.c.WatchProtocol == config.WatchSSE {
+		watch = e.watchEvents
+	}
+
+	for failures := 0; ; {
+		connected, err := watch(ctx, files)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if code := statusOf(err); code != 0 && code < http.StatusInternalServerError {
+			if code == http.StatusUnauthorized || code == http.StatusForbidden {
+				return classify(ErrAuth, err)
+			}
+			return fmt.Errorf("remote doesn't support notifications: %w", err)
+		}
+		if connected {
+			failures = 0
+		}
+
+		failures++
+		d := e.backoff(failures)
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("lost notifications connection, reconnecting in %s: %w", d, err)})
+		if sleep(ctx, d) != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// statusOf returns the status code the remote refused a notifications connection with, zero if it didn't.
+func statusOf(err error) int {
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		return herr.StatusCode
+	}
+	var wserr *websocket.HandshakeError
+	if errors.As(err, &wserr) {
+		return wserr.StatusCode
+	}
+	return 0
+}
+
+// announce passes the file of an announcement on to files, if there's room.
+func (e *Engine) announce(msg []byte, files chan<- string) {
+	var f wp
+	if err := json.Unmarshal(msg, &f); err != nil || f.WebPath == "" {
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("ignoring invalid announcement %q", msg)})
+		return
+	}
+	select {
+	case files <- f.WebPath:
+	default:
+	}
+}
+
+// watchWebSocket reads announcements from a single WebSocket connection until it fails, it reports whether the
+// connection was made.
+func (e *Engine) watchWebSocket(ctx context.Context, files chan<- string) (bool, error) {
+	u, err := e.createURL("/notifications")
+	if err != nil {
+		return false, fmt.Errorf("can't parse remote: %w", err)
+	}
+	req, err := e.newRequest(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return false, err
+	}
+	client := e.upgradeClient()
+	defer client.CloseIdleConnections()
+	conn, err := websocket.Dial(ctx, client, req)
+	if err != nil {
+		return false, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			return true, err
+		}
+		e.announce(msg, files)
+	}
+}
+
+// upgradeClient returns a client for protocol upgrades, which only exist in HTTP/1.1. The transport of the engine
+// negotiates HTTP/2 over TLS, this one uses the same settings but never does.
+func (e *Engine) upgradeClient() *http.Client {
+	t := e.transport.Clone()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	if t.TLSClientConfig != nil {
+		t.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+	return &http.Client{Transport: t}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/version"
+)
+
+// tokenMargin is how long before they expire access tokens are refreshed.
+const tokenMargin = 30 * time.Second
+
+// oauthToken is an OAuth2 access token.
+type oauthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// valid tells whether t can still be used. A token without an expiry is used until the remote refuses it.
+func (t *oauthToken) valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > tokenMargin)
+}
+
+// tokenCache is the format of the token cache, the tokens are keyed by the token URL and the client ID so clients
+// that share the file don't use each other's tokens.
+type tokenCache map[string]*oauthToken
+
+// tokenSource hands out OAuth2 access tokens, fetching new ones with the client credentials or the refresh token
+// when they expire. Tokens are cached in a file if the configuration names one. It is safe for concurrent use.
+type tokenSource struct {
+	mu     sync.Mutex
+	c      config.AuthConfig
+	client *http.Client
+	token  *oauthToken
+	loaded bool
+}
+
+// accessToken returns a valid access token, fetching a new one if needed.
+func (ts *tokenSource) accessToken(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.loaded {
+		ts.token = ts.loadCache()
+		ts.loaded = true
+	}
+	if ts.token.valid() {
+		return ts.token.AccessToken, nil
+	}
+
+	t, err := ts.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get an access token: %w", err)
+	}
+	ts.token = t
+	ts.storeCache()
+	return t.AccessToken, nil
+}
+
+// invalidate forgets the access token, and drops it from the cache, after the remote refused it. Requests that
+// were refused with an older token don't invalidate the one that replaced it.
+func (ts *tokenSource) invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token == nil || ts.token.AccessToken != token {
+		return
+	}
+	ts.token.AccessToken = ""
+	ts.storeCache()
+}
+
+// fetch requests a new token from the token endpoint, with the refresh token if there is one.
+func (ts *tokenSource) fetch(ctx context.Context) (*oauthToken, error) {
+	form := url.Values{}
+	refresh := ts.c.RefreshToken
+	if ts.token != nil && ts.token.RefreshToken != "" {
+		refresh = ts.token.RefreshToken
+	}
+	if refresh != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refresh)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(ts.c.Scopes) > 0 {
+		form.Set("scope", strings.Join(ts.c.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.SetBasicAuth(url.QueryEscape(ts.c.ClientID), url.QueryEscape(ts.c.ClientSecret))
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return nil, classify(ErrRemoteUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		// The token endpoint answers 400 for credentials or refresh tokens it doesn't accept.
+		if KindOf(err) == nil {
+			return nil, classify(ErrAuth, err)
+		}
+		return nil, err
+	}
+
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("couldn't parse token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return nil, classify(ErrAuth, fmt.Errorf("token response has no access token"))
+	}
+
+	t := &oauthToken{AccessToken: body.AccessToken, RefreshToken: body.RefreshToken}
+	if t.RefreshToken == "" {
+		t.RefreshToken = refresh
+	}
+	if body.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return t, nil
+}
+
+// cacheKey is the key of the token in the cache.
+func (ts *tokenSource) cacheKey() string {
+	return ts.c.TokenURL + " " + ts.c.ClientID
+}
+
+// readCache returns the tokens in the cache, an unreadable cache is empty.
+func (ts *tokenSource) readCache() tokenCache {
+	cache := make(tokenCache)
+	b, err := ioutil.ReadFile(ts.c.TokenCache)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return make(tokenCache)
+	}
+	return cache
+}
+
+// loadCache returns the cached token, nil if there is none. The lifetime of a cached access token without an
+// expiry is unknown, so only its refresh token is used.
+func (ts *tokenSource) loadCache() *oauthToken {
+	if ts.c.TokenCache == "" {
+		return nil
+	}
+	t := ts.readCache()[ts.cacheKey()]
+	if t != nil && t.Expiry.IsZero() {
+		t.AccessToken = ""
+	}
+	return t
+}
+
+// storeCache stores the current token, without its access token if that was refused. The cache is only an
+// optimisation so failures are ignored.
+func (ts *tokenSource) storeCache() {
+	if ts.c.TokenCache == "" {
+		return
+	}
+	cache := ts.readCache()
+	if ts.token == nil || (ts.token.AccessToken == "" && ts.token.RefreshToken == "") {
+		delete(cache, ts.cacheKey())
+	} else {
+		cache[ts.cacheKey()] = ts.token
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(ts.c.TokenCache, b); err == nil {
+		_ = os.Chmod(ts.c.TokenCache, 0600)
+	}
+}
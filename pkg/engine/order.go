@@ -0,0 +1,48 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"sort"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// ordered returns files sorted by the configured download order, files the order can't tell apart keep the
+// order of the listing. Files with an unknown size or modification time go last.
+func (e *Engine) ordered(files []wp) []wp {
+	sorted := append([]wp{}, files...)
+
+	var less func(a, b wp) bool
+	switch e.c.DownloadOrder {
+	case config.OrderSmallestFirst:
+		less = func(a, b wp) bool { return a.Size > 0 && (b.Size <= 0 || a.Size < b.Size) }
+	case config.OrderLargestFirst:
+		less = func(a, b wp) bool { return a.Size > b.Size }
+	case config.OrderOldestFirst:
+		less = func(a, b wp) bool {
+			return !a.Modified.IsZero() && (b.Modified.IsZero() || a.Modified.Before(b.Modified))
+		}
+	case config.OrderAlphabetical:
+		less = func(a, b wp) bool { return a.WebPath < b.WebPath }
+	default:
+		return sorted
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/mapping"
+)
+
+// permissions are the modes and ownership of the files and directories a mapping creates.
+type permissions struct {
+	// fileMode and dirMode are zero and uid and gid are -1 if they aren't configured.
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	uid      int
+	gid      int
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > uint64(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// lookupID resolves a user or group name with lookup, numeric IDs are used as they are.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+func resolvePermissions(m config.FilePath) (permissions, error) {
+	var (
+		p   permissions
+		err error
+	)
+	if p.fileMode, err = parseMode(m.FileMode); err != nil {
+		return p, err
+	}
+	if p.dirMode, err = parseMode(m.DirMode); err != nil {
+		return p, err
+	}
+	p.uid, err = lookupID(m.Owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return p, fmt.Errorf("invalid owner %q: %w", m.Owner, err)
+	}
+	p.gid, err = lookupID(m.Group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return p, fmt.Errorf("invalid group %q: %w", m.Group, err)
+	}
+	return p, nil
+}
+
+// preparePermissions resolves the permissions of all mappings.
+func (e *Engine) preparePermissions() error {
+	perms := make(map[string]permissions, len(e.c.RootMapping))
+	for _, m := range e.c.RootMapping {
+		p, err := resolvePermissions(m)
+		if err != nil {
+			return fmt.Errorf("mapping %s: %w", m.RemotePath, err)
+		}
+		perms[mapping.Clean(m.RemotePath)] = p
+	}
+	e.perms = perms
+	return nil
+}
+
+func (e *Engine) permissions(webPath string) permissions {
+	m, _ := e.mapper.Find(webPath)
+	if p, ok := e.perms[m.RemotePath]; ok {
+		return p
+	}
+	return permissions{uid: -1, gid: -1}
+}
+
+// apply sets mode and the ownership on p, failures are reported as warnings as the file itself is fine.
+func (e *Engine) apply(webPath, p string, mode os.FileMode, perms permissions) {
+	if mode != 0 {
+		if err := os.Chmod(p, mode); err != nil {
+			e.emit(Event{Type: Warning, File: webPath, Err: fmt.Errorf("couldn't set mode of %s: %w", p, err)})
+		}
+	}
+	if perms.uid != -1 || perms.gid != -1 {
+		if err := os.Lchown(p, perms.uid, perms.gid); err != nil {
+			e.emit(Event{Type: Warning, File: webPath, Err: fmt.Errorf("couldn't set owner of %s: %w", p, err)})
+		}
+	}
+}
+
+// applyFilePermissions sets the configured mode and ownership on a download.
+func (e *Engine) applyFilePermissions(webPath, local string) {
+	perms := e.permissions(webPath)
+	e.apply(webPath, local, perms.fileMode, perms)
+}
+
+// mkdirAll creates dir and its missing parents with the configured mode and ownership.
+func (e *Engine) mkdirAll(webPath, dir string) error {
+	existing := existingParent(dir)
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return err
+	}
+
+	perms := e.permissions(webPath)
+	for d := filepath.Clean(dir); d != existing && d != filepath.Dir(d); d = filepath.Dir(d) {
+		e.apply(webPath, d, perms.dirMode, perms)
+	}
+	return nil
+}
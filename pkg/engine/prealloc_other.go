@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import "os"
+
+// preallocate is only supported on linux, elsewhere files grow while they are written. Truncating to the size
+// isn't a fallback: it makes a sparse file on most filesystems, which neither reserves the space nor avoids
+// fragmentation, and it gives a download that stops short the size of a complete one.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
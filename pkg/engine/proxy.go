@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ParseProxyURL parses the configured proxy URL, an HTTP proxy or a SOCKS5 proxy with the socks5 scheme. Credentials
+// for the proxy go in its user info. The URL isn't part of the error, so the password doesn't end up in logs.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy_url: unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url: no host")
+	}
+	return u, nil
+}
+
+// ProxyFunc returns the proxy function for transports, requests go through raw if it is set and through the
+// proxy from the HTTP_PROXY environment variables otherwise. With a SOCKS5 proxy the transport dials through the
+// proxy, which also resolves the host names.
+func ProxyFunc(raw string) func(*http.Request) (*url.URL, error) {
+	if raw == "" {
+		return http.ProxyFromEnvironment
+	}
+	u, err := ParseProxyURL(raw)
+	if err != nil {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, err
+		}
+	}
+	return http.ProxyURL(u)
+}
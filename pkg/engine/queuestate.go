@@ -0,0 +1,154 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// queueState stores the files of a run with their status, so a run that crashed or was interrupted is resumed
+// by the next one instead of fetching and planning the listing again. It is safe for concurrent use.
+type queueState struct {
+	mu sync.Mutex
+	// path is where the state is stored, nothing is stored if it is empty.
+	path  string
+	files []queuedFile
+}
+
+// queuedFile is a file of the run, Outcome is empty while the file still has to be synchronised.
+type queuedFile struct {
+	wp
+	Outcome Outcome `json:"outcome,omitempty"`
+}
+
+func loadQueueState(p string) (*queueState, error) {
+	s := &queueState{path: p, files: make([]queuedFile, 0)}
+	if p == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read queue state: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.files); err != nil {
+		return nil, fmt.Errorf("couldn't parse queue state %s: %w", p, err)
+	}
+	return s, nil
+}
+
+// pending returns the files that still have to be synchronised, only a stored state is resumed.
+func (s *queueState) pending() []wp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+	files := make([]wp, 0, len(s.files))
+	for _, f := range s.files {
+		if f.Outcome == "" {
+			files = append(files, f.wp)
+		}
+	}
+	return files
+}
+
+// reset replaces the state with files, which all still have to be synchronised.
+func (s *queueState) reset(files []wp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = make([]queuedFile, 0, len(files))
+	for _, f := range files {
+		s.files = append(s.files, queuedFile{wp: f})
+	}
+	return s.store()
+}
+
+// add adds files that still have to be synchronised to the state.
+func (s *queueState) add(files []wp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range files {
+		s.files = append(s.files, queuedFile{wp: f})
+	}
+	return s.store()
+}
+
+// finish records the outcome of a file.
+func (s *queueState) finish(webPath string, o Outcome) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.files {
+		if s.files[i].WebPath == webPath {
+			s.files[i].Outcome = o
+		}
+	}
+	return s.store()
+}
+
+// store writes the state, or removes it once every file is finished. s.mu has to be held.
+func (s *queueState) store() error {
+	if s.path == "" {
+		return nil
+	}
+
+	finished := true
+	for _, f := range s.files {
+		finished = finished && f.Outcome != ""
+	}
+	if finished {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("couldn't remove queue state: %w", err)
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(s.files)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, b); err != nil {
+		return fmt.Errorf("couldn't store queue state: %w", err)
+	}
+	return nil
+}
+
+// finished reports whether fr is final for the queue state. Most deferred files and files that aborted the run are
+// tried again by the next run.
+func finished(fr FileResult) bool {
+	switch fr.Outcome {
+	case Deferred:
+		// Files outside the age limits come back with the next listing, they shouldn't hold up the listing.
+		return errors.Is(fr.Err, errAge)
+	case Failed:
+		return !IsFatal(fr.Err)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ainmosni/mediasync-client/pkg/journal"
+)
+
+// SetJournal makes the engine record the progress of every file in j, and recover from it at the start of a run.
+func (e *Engine) SetJournal(j *journal.Journal) {
+	e.journal = j
+}
+
+func (e *Engine) record(webPath, local, tmp string, stage journal.Stage) error {
+	if e.journal == nil {
+		return nil
+	}
+	return e.journal.Record(journal.Entry{WebPath: webPath, Local: local, Tmp: tmp, Stage: stage})
+}
+
+// recoverJournal finishes or rolls back the files that were in flight when an earlier run was interrupted.
+// Files that were already in place get deleted from the remote, or kept if their mapping keeps remote files.
+// Anything before that is thrown away, so it gets downloaded again.
+func (e *Engine) recoverJournal(ctx context.Context, res *Result) error {
+	entries, err := e.journal.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, en := range entries {
+		switch en.Stage {
+		case journal.Renamed:
+			if err := e.completeSync(ctx, en); err != nil {
+				e.emit(Event{Type: Warning, Err: fmt.Errorf("couldn't complete interrupted sync: %w", err)})
+				continue
+			}
+			res.add(FileResult{File: en.WebPath, Local: en.Local, Outcome: Downloaded})
+			e.emit(Event{Type: FileDone, File: en.WebPath})
+		default:
+			if en.Tmp != "" {
+				if err := os.Remove(en.Tmp); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("couldn't remove %s: %w", en.Tmp, err)
+				}
+			}
+			if err := e.record(en.WebPath, en.Local, "", journal.RolledBack); err != nil {
+				return err
+			}
+			e.emit(Event{Type: Warning, Err: fmt.Errorf("rolled back interrupted download of %s", en.WebPath)})
+		}
+	}
+
+	return e.journal.Compact()
+}
+
+// completeSync removes a file that is in place from the remote, unless its mapping keeps remote files.
+func (e *Engine) completeSync(ctx context.Context, en journal.Entry) error {
+	if _, err := os.Stat(en.Local); err != nil {
+		return fmt.Errorf("%s is missing: %w", en.Local, err)
+	}
+	if !e.deletesRemote(en.WebPath) {
+		return e.keep(wp{WebPath: en.WebPath}, en.Local)
+	}
+	return e.removeRemote(ctx, en.WebPath, en.Local)
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/version"
+)
+
+type wp struct {
+	WebPath string `json:"web_path"`
+	// Priority is an optional hint from the server, it is added to the priority of the mapping.
+	Priority int `json:"priority"`
+	// Size is the size of the file in bytes, zero if the server didn't supply it.
+	Size int64 `json:"size"`
+	// Metadata holds attributes of the file the server wants to have preserved.
+	Metadata map[string]string `json:"metadata"`
+	// SHA256 is the hex encoded sha-256 digest of the file, empty if the server didn't supply it.
+	SHA256 string `json:"sha256"`
+	// Modified is the modification time of the file, zero if the server didn't supply it.
+	Modified time.Time `json:"modified"`
+}
+
+func (e *Engine) createURL(rPath string) (*url.URL, error) {
+	return joinURL(httpBase(e.c.Remote), rPath)
+}
+
+// joinURL returns the URL of rPath below base.
+func joinURL(base, rPath string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, rPath)
+	return u, nil
+}
+
+func (e *Engine) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	for k, v := range e.c.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if err := e.authorize(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (e *Engine) reqWithAuth(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := e.newRequest(ctx, method, url)
+	if err != nil {
+		return nil, err
+	}
+	return e.do(req)
+}
+
+// do sends req, classifying transport errors as the remote being unavailable.
+func (e *Engine) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	do := e.client.Do
+	if e.digest != nil {
+		do = e.doDigest
+	}
+	resp, err := do(req)
+	if err != nil && ctx.Err() == nil {
+		return nil, classify(ErrRemoteUnavailable, err)
+	}
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && e.tokens != nil {
+		// The token was revoked or expired early.
+		return e.retryUnauthorized(req, resp)
+	}
+	return resp, err
+}
+
+// retryUnauthorized sends req again with a new access token, once, after the remote refused its token with resp.
+// Requests with a body that can't be sent again get resp.
+func (e *Engine) retryUnauthorized(req *http.Request, resp *http.Response) (*http.Response, error) {
+	ctx := req.Context()
+	e.tokens.invalidate(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	token, err := e.tokens.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	resp, err = e.client.Do(retry)
+	if err != nil && ctx.Err() == nil {
+		return nil, classify(ErrRemoteUnavailable, err)
+	}
+	return resp, err
+}
+
+// prewarm resolves the remote host and opens the configured amount of connections in parallel, so they
+// are idle in the pool by the time the downloads start.
+func (e *Engine) prewarm(ctx context.Context) error {
+	if e.c.PrewarmConnections <= 0 || !e.httpRemote() {
+		return nil
+	}
+
+	u, err := e.createURL("/")
+	if err != nil {
+		return err
+	}
+
+	if _, unix := unixSocket(e.c.Remote); !unix {
+		if _, err := e.resolveHost(ctx, u.Hostname()); err != nil {
+			return fmt.Errorf("couldn't resolve %s: %w", u.Hostname(), err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, e.c.PrewarmConnections)
+	for i := 0; i < e.c.PrewarmConnections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := e.reqWithAuth(ctx, "HEAD", u.String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// cachedListing is the last listing with the validators the remote sent along with it.
+type cachedListing struct {
+	// base is the remote or mirror the listing came from, the validators only apply to it.
+	base         string
+	etag         string
+	lastModified string
+	files        []wp
+}
+
+// getFiles gets the listing from base, the remote or one of its mirrors, and hands it to add. If a page size is
+// configured, every page is handed to add as soon as it arrives. Without pages the request is conditional if an
+// earlier listing from base had an ETag or Last-Modified header, so an unchanged listing doesn't have to be
+// transferred and parsed again.
+func (e *Engine) getFiles(ctx context.Context, base string, add func(page []wp) error) error {
+	if e.c.ListingPageSize <= 0 {
+		files, _, err := e.getPage(ctx, base, nil)
+		if err != nil {
+			return err
+		}
+		return add(files)
+	}
+
+	var (
+		seen  = make(map[string]bool)
+		query = url.Values{"limit": {strconv.Itoa(e.c.ListingPageSize)}}
+	)
+	for offset := 0; ; {
+		page, next, err := e.getPage(ctx, base, query)
+		if err != nil {
+			return err
+		}
+		files := make([]wp, 0, len(page))
+		for _, f := range page {
+			if !seen[f.WebPath] {
+				seen[f.WebPath] = true
+				files = append(files, f)
+			}
+		}
+		if err := add(files); err != nil {
+			return err
+		}
+
+		// The remote hands out a cursor for the next page, or pages by offset. A remote that ignores the limit
+		// sends everything at once.
+		switch {
+		case next != "":
+			query.Set("cursor", next)
+		case len(page) == e.c.ListingPageSize && len(files) > 0:
+			offset += len(page)
+			query.Set("offset", strconv.Itoa(offset))
+		default:
+			return nil
+		}
+	}
+}
+
+// getPage gets a page of the listing, query is nil for the whole listing. It returns the cursor of the next page if
+// the remote sent one.
+func (e *Engine) getPage(ctx context.Context, base string, query url.Values) ([]wp, string, error) {
+	fileInfo, err := joinURL(base, "/fileinfo")
+	if err != nil {
+		return []wp{}, "", fmt.Errorf("can't parse remote: %w", err)
+	}
+	fileInfo.RawQuery = query.Encode()
+
+	req, err := e.newRequest(ctx, "GET", fileInfo.String())
+	if err != nil {
+		return []wp{}, "", fmt.Errorf("failed to get fileinfo: %w", err)
+	}
+	if !e.c.HTTP.DisableCompression {
+		acceptCompression(req)
+	}
+	l := e.listing
+	if l != nil && l.base != base {
+		l = nil
+	}
+	if l != nil && query == nil {
+		if l.etag != "" {
+			req.Header.Set("If-None-Match", l.etag)
+		}
+		if l.lastModified != "" {
+			req.Header.Set("If-Modified-Since", l.lastModified)
+		}
+	}
+	resp, err := e.do(req)
+	if err != nil {
+		return []wp{}, "", fmt.Errorf("failed to get fileinfo: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && l != nil {
+		return append([]wp{}, l.files...), "", nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return []wp{}, "", fmt.Errorf("failed to get fileinfo: %w", err)
+	}
+	if err := decodeBody(resp); err != nil {
+		return []wp{}, "", fmt.Errorf("failed to get fileinfo: %w", err)
+	}
+
+	files, err := decodeListing(resp.Body)
+	if err != nil {
+		return []wp{}, "", err
+	}
+
+	if query == nil {
+		e.listing = nil
+		if etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"); etag != "" || modified != "" {
+			e.listing = &cachedListing{
+				base: base, etag: etag, lastModified: modified, files: append([]wp{}, files...),
+			}
+		}
+	}
+	return files, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+// decodeListing decodes a listing an entry at a time, so the document itself is never held in memory.
+func decodeListing(r io.Reader) ([]wp, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return []wp{}, fmt.Errorf("couldn't parse json: %w", err)
+	}
+	if tok == nil {
+		return []wp{}, nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return []wp{}, fmt.Errorf("couldn't parse json: expected a list of files")
+	}
+
+	files := make([]wp, 0)
+	for dec.More() {
+		var f wp
+		if err := dec.Decode(&f); err != nil {
+			return []wp{}, fmt.Errorf("couldn't parse json: %w", err)
+		}
+		files = append(files, f)
+	}
+	if _, err := dec.Token(); err != nil {
+		return []wp{}, fmt.Errorf("couldn't parse json: %w", err)
+	}
+	return files, nil
+}
+
+// archiveFile asks the remote to archive a file, u is the archive endpoint of the file.
+func (e *Engine) archiveFile(ctx context.Context, u fmt.Stringer) error {
+	resp, err := e.reqWithAuth(ctx, "POST", u.String())
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", u.String(), err)
+	}
+	return nil
+}
+
+func (e *Engine) delFile(ctx context.Context, u fmt.Stringer) error {
+	delResp, err := e.reqWithAuth(ctx, "DELETE", u.String())
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", u.String(), err)
+	}
+	defer delResp.Body.Close()
+
+	if err := checkResponse(delResp); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", u.String(), err)
+	}
+	return nil
+}
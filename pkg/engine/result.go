@@ -0,0 +1,208 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// runIDLen is the amount of random bytes in the ID of a run.
+const runIDLen = 8
+
+// Outcome is what happened to a file during a run.
+type Outcome string
+
+const (
+	Downloaded Outcome = "downloaded"
+	Failed     Outcome = "failed"
+	Skipped    Outcome = "skipped"
+	// Deferred files were not looked at in this run, they are left for a later run.
+	Deferred Outcome = "deferred"
+	// Uploaded files are local files of push mappings that were uploaded to the remote.
+	Uploaded Outcome = "uploaded"
+	// Planned files would have been downloaded, they are only listed by dry runs.
+	Planned Outcome = "planned"
+)
+
+type FileResult struct {
+	// Remote is the name of the remote the file came from, it is only set if the remote has a name.
+	Remote  string  `json:"remote,omitempty"`
+	File    string  `json:"file"`
+	Local   string  `json:"local,omitempty"`
+	Outcome Outcome `json:"outcome"`
+	Bytes   int64   `json:"bytes"`
+	// Transferred is the amount of bytes downloaded in this run, less than Bytes if the download was resumed.
+	Transferred int64         `json:"transferred"`
+	Duration    time.Duration `json:"duration"`
+	// Retries is how often the file was tried again after a transient error.
+	Retries int   `json:"retries,omitempty"`
+	Err     error `json:"-"`
+	// Reason explains why the file was skipped, deferred or failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Result describes everything that happened during a run.
+type Result struct {
+	mu sync.Mutex
+	// ID identifies the run, it is also stamped on the downloads as provenance.
+	ID       string       `json:"id"`
+	Mode     Mode         `json:"mode"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Files    []FileResult `json:"files"`
+	// Failovers describes the listings and downloads that had to use another mirror because one was unavailable.
+	Failovers []string `json:"failovers,omitempty"`
+	// Err is set if the run as a whole failed, Error holds its message.
+	Err   error  `json:"-"`
+	Error string `json:"error,omitempty"`
+}
+
+func newResult() *Result {
+	r := &Result{
+		Started: time.Now(),
+		Files:   make([]FileResult, 0),
+	}
+
+	id, err := randomString(runIDLen)
+	if err != nil {
+		id = strconv.FormatInt(r.Started.UnixNano(), 16)
+	}
+	r.ID = id
+	return r
+}
+
+func (r *Result) add(fr FileResult) {
+	if fr.Err != nil && fr.Reason == "" {
+		fr.Reason = fr.Err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Files = append(r.Files, fr)
+}
+
+// fail marks the file with remote path file as failed because of err.
+func (r *Result) fail(file string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.Files {
+		if r.Files[i].File == file {
+			r.Files[i].Outcome = Failed
+			r.Files[i].Err = err
+			r.Files[i].Reason = err.Error()
+		}
+	}
+}
+
+func (r *Result) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now()
+	r.Err = err
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// label sets the remote of all files to name.
+func (r *Result) label(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.Files {
+		r.Files[i].Remote = name
+	}
+}
+
+// Merge combines the results of runs against several remotes into one, it spans from the start of the earliest
+// run until the end of the last one and fails with the first error of the runs. It keeps the ID of the first run.
+func Merge(results ...*Result) *Result {
+	if len(results) == 1 {
+		return results[0]
+	}
+	merged := newResult()
+	for i, res := range results {
+		res.mu.Lock()
+		if i == 0 {
+			merged.ID, merged.Mode, merged.Started, merged.Finished = res.ID, res.Mode, res.Started, res.Finished
+		}
+		if res.Started.Before(merged.Started) {
+			merged.Started = res.Started
+		}
+		if res.Finished.After(merged.Finished) {
+			merged.Finished = res.Finished
+		}
+		merged.Files = append(merged.Files, res.Files...)
+		merged.Failovers = append(merged.Failovers, res.Failovers...)
+		if merged.Err == nil && res.Err != nil {
+			merged.Err, merged.Error = res.Err, res.Error
+		}
+		res.mu.Unlock()
+	}
+	return merged
+}
+
+// Filter returns the files with outcome o.
+func (r *Result) Filter(o Outcome) []FileResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make([]FileResult, 0)
+	for _, f := range r.Files {
+		if f.Outcome == o {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Bytes returns the total size of the downloaded files.
+func (r *Result) Bytes() int64 {
+	var total int64
+	for _, f := range r.Filter(Downloaded) {
+		total += f.Bytes
+	}
+	return total
+}
+
+// Transferred returns the amount of bytes that were downloaded during the run.
+func (r *Result) Transferred() int64 {
+	var total int64
+	for _, f := range r.Filter(Downloaded) {
+		total += f.Transferred
+	}
+	return total
+}
+
+// Rate returns the average amount of bytes per second downloaded during the run.
+func (r *Result) Rate() float64 {
+	d := r.Duration().Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(r.Transferred()) / d
+}
+
+// Duration returns how long the run took.
+func (r *Result) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// resumeState records which segments of a large download are complete, it is stored next to the partial
+// file so an interrupted download continues with the missing segments only.
+type resumeState struct {
+	Size  int64   `json:"size"`
+	Chunk int64   `json:"chunk"`
+	Done  []int64 `json:"done"`
+	// Validator is the ETag or Last-Modified header of the remote file the segments belong to.
+	Validator string `json:"validator,omitempty"`
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	done map[int64]bool
+}
+
+// loadResume loads the state of the partial file f, if the state is missing or belongs to a different
+// download, or to another version of the remote file, f is truncated and a fresh state is returned.
+func loadResume(f *os.File, path string, size, chunk int64, validator string) (*resumeState, error) {
+	rs := &resumeState{}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || json.Unmarshal(b, rs) != nil || rs.Size != size || rs.Chunk != chunk ||
+		rs.Validator != validator {
+		rs = &resumeState{Size: size, Chunk: chunk, Validator: validator}
+		if err := f.Truncate(0); err != nil {
+			return nil, fmt.Errorf("couldn't truncate %s: %w", f.Name(), err)
+		}
+	}
+
+	rs.path = path
+	rs.f = f
+	rs.done = make(map[int64]bool, len(rs.Done))
+	for _, start := range rs.Done {
+		rs.done[start] = true
+	}
+	return rs, nil
+}
+
+func (rs *resumeState) isDone(seg segment) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.done[seg.start]
+}
+
+// markDone records seg as complete, after making sure its data is on disk. It is safe for concurrent use.
+func (rs *resumeState) markDone(seg segment) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if err := rs.f.Sync(); err != nil {
+		return diskError(err)
+	}
+
+	rs.done[seg.start] = true
+	rs.Done = append(rs.Done, seg.start)
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+
+	tmp := rs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return diskError(err)
+	}
+	return os.Rename(tmp, rs.path)
+}
+
+// resumableFile is staged under a predictable name, and is kept when the download fails so it can be resumed.
+type resumableFile struct {
+	namedFile
+	statePath string
+}
+
+func stageResumable(dir, name string, flags int) (*resumableFile, error) {
+	p := filepath.Join(dir, fmt.Sprintf(".%s.partial", name))
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create file: %w", diskError(err))
+	}
+	return &resumableFile{
+		namedFile: namedFile{f: f, path: p},
+		statePath: p + ".json",
+	}, nil
+}
+
+// Name returns an empty string, the file must survive crash recovery.
+func (r *resumableFile) Name() string {
+	return ""
+}
+
+func (r *resumableFile) Commit(dst string) error {
+	if err := r.namedFile.Commit(dst); err != nil {
+		return err
+	}
+	_ = os.Remove(r.statePath)
+	return nil
+}
+
+// discard throws the file and its state away, for downloads that failed verification and can't be resumed. It is
+// a no-op on a nil file.
+func (r *resumableFile) discard() {
+	if r == nil || r.done {
+		return
+	}
+	_ = r.f.Close()
+	_ = os.Remove(r.path)
+	_ = os.Remove(r.statePath)
+	r.done = true
+}
+
+func (r *resumableFile) Abort() {
+	if !r.done {
+		_ = r.f.Close()
+	}
+}
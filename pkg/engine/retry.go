@@ -0,0 +1,158 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultMaxAttempts  = 3
+	defaultRetryBackoff = time.Second
+	defaultMaxBackoff   = time.Minute
+)
+
+// retryable reports whether err is a transient problem that might go away when trying again.
+func retryable(err error) bool {
+	return errors.Is(err, ErrRemoteUnavailable) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrChecksumMismatch) ||
+		errors.Is(err, ErrSizeMismatch) ||
+		errors.Is(err, errRemoteChanged)
+}
+
+func (e *Engine) maxAttempts() int {
+	if e.c.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return e.c.MaxAttempts
+}
+
+func (e *Engine) maxBackoff() time.Duration {
+	if e.c.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return e.c.MaxBackoff
+}
+
+// backoff returns how long to wait before the given retry, doubling every time, with jitter so that
+// parallel downloads don't retry in lockstep.
+func (e *Engine) backoff(retry int) time.Duration {
+	d, limit := e.c.RetryBackoff, e.maxBackoff()
+	if d <= 0 {
+		d = defaultRetryBackoff
+	}
+	for i := 1; i < retry && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec // Not used for security.
+}
+
+// retryAfter returns how long the remote asked to wait after err, zero if it didn't.
+func retryAfter(err error) time.Duration {
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		return herr.RetryAfter
+	}
+	return 0
+}
+
+// pause makes all retried operations wait until d has passed, as the remote asks that of the client rather than of a
+// single request.
+func (e *Engine) pause(d time.Duration) {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	if until := time.Now().Add(d); until.After(e.pausedUntil) {
+		e.pausedUntil = until
+	}
+}
+
+// waitPause waits until the pause the remote asked for is over, it returns early when ctx is done.
+func (e *Engine) waitPause(ctx context.Context) error {
+	e.pauseMu.Lock()
+	d := time.Until(e.pausedUntil)
+	e.pauseMu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	return sleep(ctx, d)
+}
+
+// sleep waits for d, it returns the error of ctx if that is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retry calls op until it succeeds, fails with an error that isn't retryable or runs out of attempts. It
+// returns how often op was retried. When the remote says how long to wait, with a Retry-After header, that is
+// used instead of the backoff, unless it is longer than the maximum backoff.
+func (e *Engine) retry(ctx context.Context, webPath string, op func() error) (int, error) {
+	for retries := 0; ; retries++ {
+		if err := e.waitPause(ctx); err != nil {
+			return retries, err
+		}
+		err := op()
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return retries, err
+		}
+		if retries+1 >= e.maxAttempts() {
+			return retries, fmt.Errorf("giving up after %d attempts: %w", retries+1, err)
+		}
+
+		d := e.backoff(retries + 1)
+		if ra := retryAfter(err); ra > 0 {
+			if ra > e.maxBackoff() {
+				return retries, fmt.Errorf("remote asked to retry after %s, more than the maximum backoff: %w", ra, err)
+			}
+			d = ra
+			e.pause(ra)
+		}
+		e.emit(Event{Type: Warning, File: webPath, Err: fmt.Errorf("retrying %s in %s: %w", webPath, d, err)})
+		if sleep(ctx, d) != nil {
+			return retries, err
+		}
+	}
+}
+
+// getFileRetrying calls getFile with retries, it also returns how often the download was retried.
+func (e *Engine) getFileRetrying(ctx context.Context, f wp) (string, transfer, int, error) {
+	var (
+		local string
+		t     transfer
+	)
+	retries, err := e.retry(ctx, f.WebPath, func() error {
+		var err error
+		local, t, err = e.getFile(ctx, f)
+		return err
+	})
+	return local, t, retries, err
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// emptySHA256 is the hex encoded sha-256 digest of an empty payload, none of the requests have a body.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Backend pulls objects from an S3 compatible bucket with path style addressing. Downloads and deletes are plain
+// HTTP requests on the objects, signed with AWS signature version 4.
+type s3Backend struct {
+	httpBackend
+}
+
+// listBucketResult is the part of a ListObjectsV2 response the listing needs.
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// parseS3Remote splits an S3 remote into the URL of its bucket and the prefix of the objects, without slashes
+// around it.
+func parseS3Remote(remote string) (*url.URL, string, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't parse remote: %w", err)
+	}
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, "", errors.New("the s3 remote doesn't name a bucket")
+	}
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = strings.Trim(parts[1], "/")
+	}
+	u.Path, u.RawPath, u.RawQuery = "/"+parts[0], "", ""
+	return u, prefix, nil
+}
+
+// list lists the objects below the prefix, a page of listing_page_size keys at a time if it is set. Keys ending
+// in a slash are folder markers and are ignored.
+func (b *s3Backend) list(ctx context.Context, add func(page []wp) error) error {
+	bucket, prefix, err := parseS3Remote(b.e.c.Remote)
+	if err != nil {
+		return err
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if b.e.c.ListingPageSize > 0 {
+		query.Set("max-keys", strconv.Itoa(b.e.c.ListingPageSize))
+	}
+
+	for {
+		page, err := b.listPage(ctx, bucket, query)
+		if err != nil {
+			return err
+		}
+		files := make([]wp, 0, len(page.Contents))
+		for _, o := range page.Contents {
+			if strings.HasSuffix(o.Key, "/") || !strings.HasPrefix(o.Key, prefix) {
+				continue
+			}
+			f := wp{WebPath: "/" + strings.TrimPrefix(o.Key, prefix), Size: o.Size}
+			f.Modified, _ = time.Parse(time.RFC3339, o.LastModified)
+			files = append(files, f)
+		}
+		if err := add(files); err != nil {
+			return err
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (b *s3Backend) listPage(ctx context.Context, bucket *url.URL, query url.Values) (*listBucketResult, error) {
+	u := *bucket
+	u.RawQuery = query.Encode()
+	resp, err := b.e.reqWithAuth(ctx, "GET", u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	var page listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("couldn't parse xml: %w", err)
+	}
+	return &page, nil
+}
+
+// s3Credentials are the credentials requests are signed with.
+type s3Credentials struct {
+	id, secret, token string
+}
+
+// s3CredentialsOf returns the credentials of c, falling back to the environment for the ones that aren't set.
+func s3CredentialsOf(c config.S3Config) s3Credentials {
+	creds := s3Credentials{id: c.AccessKeyID, secret: c.SecretAccessKey, token: c.SessionToken}
+	if creds.id == "" {
+		creds.id = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if creds.secret == "" {
+		creds.secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if creds.token == "" {
+		creds.token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return creds
+}
+
+// checkS3 makes sure the remote names a bucket and there are credentials to sign requests with.
+func checkS3(c *config.Configuration) error {
+	if _, _, err := parseS3Remote(c.Remote); err != nil {
+		return err
+	}
+	if creds := s3CredentialsOf(c.S3); creds.id == "" || creds.secret == "" {
+		return errors.New("s3 remotes need an access key id and a secret access key")
+	}
+	return nil
+}
+
+// signS3 signs req for the configured region. Object requests ask for the checksum of the object, which S3 only
+// sends for objects that were uploaded with one.
+func (e *Engine) signS3(req *http.Request) {
+	if req.URL.RawQuery == "" {
+		req.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+	}
+	region := e.c.S3.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+	signV4(req, s3CredentialsOf(e.c.S3), region, time.Now())
+}
+
+// signV4 signs req with AWS signature version 4 for S3. The host and the x-amz-* headers are signed, the path and
+// query are rewritten into their canonical encoding so the request matches what was signed.
+func signV4(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if creds.token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.token)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") {
+			names = append(names, name)
+			values[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, n := range names {
+		headers.WriteString(n + ":" + values[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	p := req.URL.Path
+	if p == "" {
+		p = "/"
+	}
+	req.URL.RawPath = s3Escape(p, false)
+	req.URL.RawQuery = canonicalQuery(req.URL.Query())
+
+	canonical := strings.Join([]string{
+		req.Method, req.URL.RawPath, req.URL.RawQuery, headers.String(), signed, emptySHA256,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secret), day)
+	for _, s := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.id, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes q sorted by key and value, with everything but unreserved characters escaped.
+func canonicalQuery(q url.Values) string {
+	pairs := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, slashes are kept unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// segmentAttempts is how often a single segment is tried before the whole download fails.
+const segmentAttempts = 3
+
+type segment struct {
+	start int64
+	end   int64
+}
+
+func (s segment) length() int64 {
+	return s.end - s.start + 1
+}
+
+// segments splits size bytes into segments of at most chunk bytes.
+func segments(size, chunk int64) []segment {
+	segs := make([]segment, 0, size/chunk+1)
+	for start := int64(0); start < size; start += chunk {
+		end := start + chunk - 1
+		if end >= size {
+			end = size - 1
+		}
+		segs = append(segs, segment{start: start, end: end})
+	}
+	return segs
+}
+
+// offsetWriter writes sequentially to w, starting at an offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	n, err := o.w.WriteAt(b, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
+
+// rangeSupport describes remote and reports whether it can be downloaded in ranges.
+func (e *Engine) rangeSupport(ctx context.Context, remote string) (remoteMeta, bool) {
+	resp, err := e.reqWithAuth(ctx, "HEAD", remote)
+	if err != nil {
+		return remoteMeta{}, false
+	}
+	defer resp.Body.Close()
+
+	if checkResponse(resp) != nil || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return remoteMeta{}, false
+	}
+	return metaOf(resp), resp.ContentLength > 0
+}
+
+// fetchSegments downloads remote into w in segments according to p, up to the configured amount of segments at
+// once. Every segment is verified on its own and only the failing segments are fetched again. If rs is set,
+// segments that were completed earlier are skipped, and completed segments are recorded in it.
+func (e *Engine) fetchSegments(
+	ctx context.Context, webPath, remote string, w io.WriterAt, p transferPlan, bufSize int, rs *resumeState,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progress := &progressWriter{e: e, file: webPath, total: p.size}
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	segs := make(chan segment)
+	for i := 0; i < e.segmentWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seg := range segs {
+				err := e.fetchSegmentRetrying(ctx, remote, p.validator, w, seg, progress, bufSize, rs)
+				if err != nil {
+					// The first failure stops the other segments, their errors only stem from that.
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, seg := range segments(p.size, int64(e.c.ChunkSize)) {
+		if rs != nil && rs.isDone(seg) {
+			progress.add(seg.length())
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		segs <- seg
+	}
+	close(segs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// segmentWorkers returns the amount of segments of a file that are downloaded at once.
+func (e *Engine) segmentWorkers() int {
+	if e.c.SegmentWorkers <= 0 {
+		return 1
+	}
+	return e.c.SegmentWorkers
+}
+
+// fetchSegmentRetrying downloads a single segment, retrying it a few times, and records it in rs when done.
+func (e *Engine) fetchSegmentRetrying(
+	ctx context.Context, remote, validator string, w io.WriterAt, seg segment, progress *progressWriter,
+	bufSize int, rs *resumeState,
+) error {
+	var err error
+	for attempt := 0; attempt < segmentAttempts; attempt++ {
+		if err = e.waitPause(ctx); err != nil {
+			break
+		}
+		ap := &attemptProgress{p: progress}
+		if err = e.fetchSegment(ctx, progress.file, remote, validator, w, seg, ap, bufSize); err == nil {
+			break
+		}
+		// The segment starts over, so its progress doesn't count.
+		progress.add(-ap.n)
+		if ctx.Err() != nil || IsFatal(err) || errors.Is(err, errRemoteChanged) {
+			break
+		}
+		if ra := retryAfter(err); ra > 0 && ra <= e.maxBackoff() {
+			e.pause(ra)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed downloading bytes %d-%d of %s: %w", seg.start, seg.end, remote, err)
+	}
+	if rs != nil {
+		if err := rs.markDone(seg); err != nil {
+			return fmt.Errorf("couldn't record progress of %s: %w", remote, err)
+		}
+	}
+	return nil
+}
+
+// fetchSegment downloads seg of remote into w. With a validator the remote only sends the segment if the file
+// still has that version.
+func (e *Engine) fetchSegment(
+	ctx context.Context, webPath, remote, validator string, w io.WriterAt, seg segment, p io.Writer, bufSize int,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := e.newRequest(ctx, "GET", remote)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.start, seg.end))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := e.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK && validator != "" {
+		return errRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("remote ignored range request, status %d", resp.StatusCode)
+	}
+
+	h := sha256.New()
+	out := io.MultiWriter(&offsetWriter{w: w, offset: seg.start}, h, p)
+	n, err := e.copyBody(ctx, cancel, webPath, out, io.LimitReader(resp.Body, seg.length()), bufSize)
+	if err != nil {
+		return diskError(err)
+	}
+	if n != seg.length() {
+		return fmt.Errorf("got %d bytes, expected %d", n, seg.length())
+	}
+	return verifyDigest(resp.Header, h)
+}
+
+// verifyDigest compares the hash of a response with its Content-Digest or Digest header, if it has one.
+func verifyDigest(header http.Header, h hash.Hash) error {
+	expected := headerDigest(header)
+	if expected == nil {
+		return nil
+	}
+	if !bytes.Equal(expected, h.Sum(nil)) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// headerDigest extracts the sha-256 digest from a Content-Digest (RFC 9530) or Digest (RFC 3230) header, or the
+// checksum S3 sends for objects that were uploaded with one.
+func headerDigest(header http.Header) []byte {
+	if sum, err := base64.StdEncoding.DecodeString(header.Get("X-Amz-Checksum-Sha256")); err == nil && len(sum) > 0 {
+		return sum
+	}
+	for _, name := range []string{"Content-Digest", "Digest"} {
+		for _, d := range strings.Split(header.Get(name), ",") {
+			parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			if len(parts) != 2 || !strings.EqualFold(parts[0], "sha-256") {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(strings.Trim(parts[1], ":"))
+			if err == nil {
+				return sum
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/sftp"
+)
+
+// sftpBackend pulls files from an SSH server over the sftp subsystem of the ssh command. The session is opened
+// on first use and kept until the backend is closed or the connection drops.
+type sftpBackend struct {
+	c *config.Configuration
+	// dial opens a session, it is replaced in tests.
+	dial func(ctx context.Context) (*sftp.Client, error)
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+// parseSFTPRemote splits a remote of the form [user@]host:path into the ssh destination and the path, user is
+// used if the remote doesn't have one. An empty path is the home directory.
+func parseSFTPRemote(remote, user string) (string, string, error) {
+	i := strings.Index(remote, ":")
+	if i <= 0 || strings.HasPrefix(remote[i:], "://") {
+		return "", "", fmt.Errorf("sftp remote %q isn't of the form [user@]host:path", remote)
+	}
+	dest, root := remote[:i], remote[i+1:]
+	if user != "" && !strings.Contains(dest, "@") {
+		dest = user + "@" + dest
+	}
+	if root == "" {
+		root = "."
+	}
+	return dest, root, nil
+}
+
+// sshArgs returns the arguments for the ssh command to start the sftp subsystem on dest.
+func sshArgs(c config.SFTPConfig, dest string) []string {
+	// Batch mode makes ssh fail instead of asking for passwords or host keys.
+	args := []string{"-o", "BatchMode=yes"}
+	if c.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(c.Port))
+	}
+	if c.IdentityFile != "" {
+		args = append(args, "-i", c.IdentityFile)
+	}
+	// The destination comes from the configuration, -- keeps ssh from reading it as an option.
+	return append(args, "-s", "--", dest, "sftp")
+}
+
+// dialSSH starts ssh and an SFTP session over it, ssh is stopped when ctx is done before the session started.
+func (b *sftpBackend) dialSSH(ctx context.Context) (*sftp.Client, error) {
+	dest, _, err := parseSFTPRemote(b.c.Remote, b.c.UserName)
+	if err != nil {
+		return nil, err
+	}
+	command := b.c.SFTP.Command
+	if command == "" {
+		command = "ssh"
+	}
+
+	cmd := exec.Command(command, sshArgs(b.c.SFTP, dest)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't run %s: %w", command, err)
+	}
+
+	type result struct {
+		client *sftp.Client
+		err    error
+	}
+	started := make(chan result, 1)
+	go func() {
+		client, err := sftp.NewClient(stdout, stdin)
+		started <- result{client, err}
+	}()
+
+	select {
+	case r := <-started:
+		if r.err != nil {
+			stdin.Close()
+			_ = cmd.Wait()
+			return nil, sshError(r.err, stderr.String())
+		}
+		go func() {
+			<-r.client.Done()
+			stdin.Close()
+			_ = cmd.Wait()
+		}()
+		return r.client, nil
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, ctx.Err()
+	}
+}
+
+// sshError classifies the failure to start a session by what ssh printed, it is an authentication error if ssh
+// couldn't log in or verify the host.
+func sshError(err error, stderr string) error {
+	msg := strings.TrimSpace(stderr)
+	if i := strings.LastIndex(msg, "\n"); i >= 0 {
+		msg = msg[i+1:]
+	}
+	if msg != "" {
+		err = fmt.Errorf("%w (%s)", err, msg)
+	}
+	err = fmt.Errorf("couldn't start sftp session: %w", err)
+	if strings.Contains(stderr, "Permission denied") || strings.Contains(stderr, "Host key verification failed") {
+		return classify(ErrAuth, err)
+	}
+	return classify(ErrRemoteUnavailable, err)
+}
+
+// sftpError classifies err, errors of the connection rather than of a file make the remote unavailable. Cancelled
+// requests are left alone.
+func sftpError(err error) error {
+	var status *sftp.StatusError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return classify(ErrNotFound, err)
+	case errors.As(err, &status), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	default:
+		return classify(ErrRemoteUnavailable, err)
+	}
+}
+
+// session returns the current session, a new one is opened if there isn't one or its connection dropped.
+func (b *sftpBackend) session(ctx context.Context) (*sftp.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil && b.client.Err() == nil {
+		return b.client, nil
+	}
+	client, err := b.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b.client = client
+	return client, nil
+}
+
+// path returns the path of remote on the server.
+func (b *sftpBackend) path(remote string) string {
+	_, root, _ := parseSFTPRemote(b.c.Remote, b.c.UserName)
+	return path.Join(root, remote)
+}
+
+// list walks the directories below the root of the remote, symbolic links and special files are ignored.
+func (b *sftpBackend) list(ctx context.Context, add func(page []wp) error) error {
+	client, err := b.session(ctx)
+	if err != nil {
+		return err
+	}
+
+	var files []wp
+	for dirs := []string{"/"}; len(dirs) > 0; dirs = dirs[1:] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := client.ReadDir(ctx, b.path(dirs[0]))
+		if err != nil {
+			return fmt.Errorf("couldn't list %s: %w", dirs[0], sftpError(err))
+		}
+		for _, fi := range entries {
+			p := path.Join(dirs[0], fi.Name)
+			switch {
+			case fi.Mode.IsDir():
+				dirs = append(dirs, p)
+			case fi.Mode.IsRegular():
+				files = append(files, wp{WebPath: p, Size: fi.Size, Modified: fi.ModTime})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].WebPath < files[j].WebPath })
+	return add(files)
+}
+
+func (b *sftpBackend) stat(ctx context.Context, remote string) (remoteMeta, error) {
+	client, err := b.session(ctx)
+	if err != nil {
+		return remoteMeta{size: -1}, err
+	}
+	fi, err := client.Stat(ctx, b.path(remote))
+	if err != nil {
+		return remoteMeta{size: -1}, sftpError(err)
+	}
+	return remoteMeta{size: fi.Size, modified: fi.ModTime}, nil
+}
+
+// open opens remote, its reads fail once ctx is done while the other transfers of the session go on.
+func (b *sftpBackend) open(ctx context.Context, remote string) (io.ReadCloser, remoteMeta, error) {
+	client, err := b.session(ctx)
+	if err != nil {
+		return nil, remoteMeta{size: -1}, err
+	}
+	f, err := client.Open(ctx, b.path(remote))
+	if err != nil {
+		return nil, remoteMeta{size: -1}, sftpError(err)
+	}
+
+	meta := remoteMeta{size: -1}
+	if fi, err := f.Stat(); err == nil {
+		meta.size, meta.modified = fi.Size, fi.ModTime
+	}
+	return &sftpReader{f: f}, meta, nil
+}
+
+func (b *sftpBackend) remove(ctx context.Context, webPath string) error {
+	client, err := b.session(ctx)
+	if err != nil {
+		return err
+	}
+	if err := client.Remove(ctx, b.path(webPath)); err != nil {
+		return fmt.Errorf("couldn't delete %s: %w", webPath, sftpError(err))
+	}
+	return nil
+}
+
+func (b *sftpBackend) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil {
+		return nil
+	}
+	client := b.client
+	b.client = nil
+	if client.Err() != nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// sftpReader reads a file of an SFTP session, with the errors classified.
+type sftpReader struct {
+	f *sftp.File
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if err != nil && err != io.EOF {
+		err = sftpError(err)
+	}
+	return n, err
+}
+
+func (r *sftpReader) Close() error {
+	return r.f.Close()
+}
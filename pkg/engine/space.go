@@ -0,0 +1,66 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ainmosni/mediasync-client/pkg/crypt"
+)
+
+// remoteInfo asks the remote for the size and sha-256 digest of remote, they are -1 and nil if unknown.
+func (e *Engine) remoteInfo(ctx context.Context, remote string) (int64, []byte) {
+	meta, err := e.backend.stat(ctx, remote)
+	if err != nil {
+		return -1, nil
+	}
+	return meta.size, meta.digest
+}
+
+// checkSpace makes sure a file of size bytes fits in dir, files that don't fit are skipped. Files that would
+// make the free space drop below the watermark of their mapping are deferred. A negative size counts as zero
+// for the watermark and isn't checked otherwise.
+func (e *Engine) checkSpace(webPath, dir string, size int64) error {
+	m, _ := e.mapper.Find(webPath)
+	if size < 0 && m.MinFreeSpace <= 0 {
+		return nil
+	}
+	if e.key != nil && size >= 0 {
+		size = crypt.EncryptedSize(size)
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		e.emit(Event{Type: Warning, Err: fmt.Errorf("couldn't determine free space in %s: %w", dir, err)})
+		return nil
+	}
+	if free < 0 {
+		return nil
+	}
+	if size > free {
+		return skip(fmt.Errorf("%d bytes needed in %s, but only %d are available: %w",
+			size, dir, free, ErrInsufficientSpace))
+	}
+	if size < 0 {
+		size = 0
+	}
+	if m.MinFreeSpace > 0 && free-size < int64(m.MinFreeSpace) {
+		return fmt.Errorf("free space in %s would drop below %d bytes: %w", dir, m.MinFreeSpace, ErrLowSpace)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// eventFileAdded is the type of the server-sent events that announce a new file.
+const eventFileAdded = "file-added"
+
+// watchEvents reads announcements from a subscription to the server-sent events of the remote until it fails, it
+// reports whether the subscription was made. The ID of the last event is sent along when subscribing again, so the
+// remote can send the events that were missed in between.
+func (e *Engine) watchEvents(ctx context.Context, files chan<- string) (bool, error) {
+	u, err := e.createURL("/events")
+	if err != nil {
+		return false, fmt.Errorf("can't parse remote: %w", err)
+	}
+	req, err := e.newRequest(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if e.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", e.lastEventID)
+	}
+
+	resp, err := e.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return false, err
+	}
+
+	return true, readEvents(resp.Body, func(typ, id string, data []byte) {
+		if id != "" {
+			e.lastEventID = id
+		}
+		if typ == eventFileAdded {
+			e.announce(data, files)
+		}
+	})
+}
+
+// readEvents parses an event stream, calling handle for every event. It returns when the stream ends.
+func readEvents(r io.Reader, handle func(typ, id string, data []byte)) error {
+	var (
+		typ, id string
+		data    []string
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			if len(data) > 0 {
+				handle(typ, id, []byte(strings.Join(data, "\n")))
+			}
+			typ, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// A comment, which servers send to keep the connection open.
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			typ = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			id = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
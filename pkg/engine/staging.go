@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stagedFile is a download in progress, it only shows up at its destination once it is committed.
+type stagedFile interface {
+	File() *os.File
+	// Name returns the path of the file, or an empty string if it has none.
+	Name() string
+	// Commit closes the file and atomically moves it to dst.
+	Commit(dst string) error
+	// Abort throws the file away, it is a no-op after a successful commit.
+	Abort()
+}
+
+// stage creates a staged file in dir, using an anonymous file where the platform supports it. Network
+// filesystems don't support those, so they always get a named file.
+func stage(dir, name string, ws writeStrategy) (stagedFile, error) {
+	if !ws.network {
+		sf, err := stageAnonymous(dir, ws.openFlags())
+		if err == nil {
+			return sf, nil
+		}
+	}
+	return stageNamed(dir, name, ws.openFlags())
+}
+
+// tmpName returns a hidden, unique name for a temporary file next to name.
+func tmpName(dir, name string) (string, error) {
+	postfix, err := randomString(postfixLen)
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate postfix: %w", err)
+	}
+	return filepath.Join(dir, fmt.Sprintf(".%s.%s", name, postfix)), nil
+}
+
+type namedFile struct {
+	f    *os.File
+	path string
+	done bool
+}
+
+func stageNamed(dir, name string, flags int) (stagedFile, error) {
+	tmpFile, err := tmpName(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(tmpFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC|flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create file: %w", diskError(err))
+	}
+	return &namedFile{f: f, path: tmpFile}, nil
+}
+
+func (n *namedFile) File() *os.File {
+	return n.f
+}
+
+func (n *namedFile) Name() string {
+	return n.path
+}
+
+func (n *namedFile) Commit(dst string) error {
+	if err := n.f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", n.path, diskError(err))
+	}
+	if err := os.Rename(n.path, dst); err != nil {
+		return fmt.Errorf("couldn't rename %s to %s: %w", n.path, dst, err)
+	}
+	n.done = true
+	return nil
+}
+
+func (n *namedFile) Abort() {
+	if n.done {
+		return
+	}
+	_ = n.f.Close()
+	// The file may be gone already, and a staging directory that fails now can't be cleaned up anyway.
+	_ = os.Remove(n.path)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// anonymousFile is staged with O_TMPFILE, it has no name until it is linked into place, so an
+// interrupted download never leaves anything behind.
+type anonymousFile struct {
+	f    *os.File
+	dir  string
+	done bool
+}
+
+func stageAnonymous(dir string, flags int) (stagedFile, error) {
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC|flags, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return &anonymousFile{f: os.NewFile(uintptr(fd), dir), dir: dir}, nil
+}
+
+func (a *anonymousFile) File() *os.File {
+	return a.f
+}
+
+func (a *anonymousFile) link(dst string) error {
+	src := fmt.Sprintf("/proc/self/fd/%d", a.f.Fd())
+	return unix.Linkat(unix.AT_FDCWD, src, unix.AT_FDCWD, dst, unix.AT_SYMLINK_FOLLOW)
+}
+
+func (a *anonymousFile) Name() string {
+	return ""
+}
+
+// linkOver links the file next to dst and renames it over dst, as linking can't replace files.
+func (a *anonymousFile) linkOver(dst string) error {
+	tmpFile, err := tmpName(a.dir, filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	if err := a.link(tmpFile); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, dst); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+func (a *anonymousFile) Commit(dst string) error {
+	err := a.link(dst)
+	if errors.Is(err, unix.EEXIST) {
+		err = a.linkOver(dst)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't link %s: %w", dst, diskError(err))
+	}
+
+	a.done = true
+	if err := a.f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", dst, diskError(err))
+	}
+	return nil
+}
+
+func (a *anonymousFile) Abort() {
+	if !a.done {
+		_ = a.f.Close()
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+const (
+	// networkBufferSize is the size of the writes to network filesystems, which handle few large writes much
+	// better than many small ones.
+	networkBufferSize = 4 << 20
+	// eioRetries is how often a write to a network filesystem is retried when it fails with EIO.
+	eioRetries = 3
+	eioBackoff = time.Second
+)
+
+// writeStrategy decides how downloads are written to the destination of a mapping.
+type writeStrategy struct {
+	network      bool
+	writeThrough bool
+	preallocate  bool
+}
+
+func (e *Engine) writeStrategy(webPath string) writeStrategy {
+	m, _ := e.mapper.Find(webPath)
+	network := m.WriteStrategy == config.WriteStrategyNetwork
+	return writeStrategy{
+		network:      network,
+		writeThrough: m.WriteThrough,
+		// Network filesystems emulate fallocate by writing zeros, which doubles the traffic.
+		preallocate: m.Preallocate && !network,
+	}
+}
+
+// bufferSize returns the size of the buffer writes should be collected in, zero for no buffering.
+func (ws writeStrategy) bufferSize() int {
+	if ws.network {
+		return networkBufferSize
+	}
+	return 0
+}
+
+// openFlags returns the extra flags the staged file should be opened with.
+func (ws writeStrategy) openFlags() int {
+	if ws.writeThrough {
+		return syscall.O_SYNC
+	}
+	return 0
+}
+
+// destination is what downloads are written to, both streaming and at offsets.
+type destination interface {
+	io.Writer
+	io.WriterAt
+}
+
+// wrap returns the destination to write f through.
+func (ws writeStrategy) wrap(f *os.File) destination {
+	if ws.network {
+		return &eioRetryWriter{f: f}
+	}
+	return f
+}
+
+// eioRetryWriter retries writes that fail with EIO, which network filesystems return for transient problems.
+type eioRetryWriter struct {
+	f *os.File
+}
+
+func (w *eioRetryWriter) Write(p []byte) (int, error) {
+	written := 0
+	for attempt := 0; ; attempt++ {
+		n, err := w.f.Write(p[written:])
+		written += n
+		if err == nil || !errors.Is(err, syscall.EIO) || attempt == eioRetries {
+			return written, err
+		}
+		time.Sleep(eioBackoff)
+	}
+}
+
+func (w *eioRetryWriter) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	for attempt := 0; ; attempt++ {
+		n, err := w.f.WriteAt(p[written:], off+int64(written))
+		written += n
+		if err == nil || !errors.Is(err, syscall.EIO) || attempt == eioRetries {
+			return written, err
+		}
+		time.Sleep(eioBackoff)
+	}
+}
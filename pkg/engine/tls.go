@@ -0,0 +1,126 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// LoadTLSConfig builds the TLS configuration for the connections to the remote from c, it returns nil if c
+// doesn't configure anything.
+func LoadTLSConfig(c config.TLSConfig) (*tls.Config, error) {
+	if c == (config.TLSConfig{}) {
+		return nil, nil
+	}
+	tc := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify} //nolint:gosec // Explicitly asked for.
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("a client certificate needs both tls.cert_file and tls.key_file")
+		}
+		r := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+		if err := r.reload(); err != nil {
+			return nil, err
+		}
+		tc.GetClientCertificate = r.clientCertificate
+	}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
+}
+
+// SetTLSConfig makes the connections to the remote use tc, a nil configuration restores the defaults. It has to
+// be called before the first run.
+func (e *Engine) SetTLSConfig(tc *tls.Config) {
+	e.transport.TLSClientConfig = tc
+}
+
+// certReloader hands out the client certificate, loading it again when its files change, so certificates can be
+// renewed without restarting the client. It is safe for concurrent use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	// certMod and keyMod are the modification times of the files the certificate was loaded from.
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// reload loads the certificate if its files changed since it was last loaded. A certificate that doesn't load,
+// for instance because only one of the files was replaced yet, is tried again next time while the previous one
+// remains in use.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load client certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load client certificate: %w", err)
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load client certificate: %w", err)
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return nil
+}
+
+// clientCertificate is the GetClientCertificate callback of the TLS configuration, it checks for a renewed
+// certificate on every handshake.
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	err := r.reload()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert == nil {
+		return nil, err
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// unixHost stands in for the host in the URLs of requests to a remote on a Unix socket, the transport dials the
+// socket for it.
+const unixHost = "mediasync.sock"
+
+// unixSocket returns the path of the socket if remote is a unix:// URL.
+func unixSocket(remote string) (string, bool) {
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme != "unix" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// httpBase returns the base URL of the requests to remote.
+func httpBase(remote string) string {
+	if _, ok := unixSocket(remote); ok {
+		return "http://" + unixHost
+	}
+	return remote
+}
+
+// checkUnix returns an error if the remote of c is a unix:// URL that can't be used.
+func checkUnix(c *config.Configuration) error {
+	if _, ok := unixSocket(c.Remote); !ok {
+		return nil
+	}
+	if c.Scheme != "" && c.Scheme != config.SchemeHTTP {
+		return fmt.Errorf("unix sockets can't be used with scheme %s", c.Scheme)
+	}
+	u, _ := url.Parse(c.Remote)
+	if u.Host != "" || u.Path == "" {
+		return errors.New("a unix remote needs an absolute socket path, like unix:///run/mediasync.sock")
+	}
+	return nil
+}
+
+// dialUnix makes transport connect to the socket of a unix:// remote for the requests to unixHost, which never
+// go through a proxy. Other requests, to mirrors for instance, are left alone.
+func dialUnix(transport *http.Transport, remote string) {
+	sock, ok := unixSocket(remote)
+	if !ok {
+		return
+	}
+
+	dial, proxy := transport.DialContext, transport.Proxy
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == unixHost+":80" {
+			return dial(ctx, "unix", sock)
+		}
+		return dial(ctx, network, addr)
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Host == unixHost {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/ratelimit"
+)
+
+// Mode decides what a run does.
+type Mode string
+
+const (
+	// ModeSync downloads the files of pull mappings and then uploads the files of push mappings.
+	ModeSync Mode = "sync"
+	// ModeUpload only uploads the files of push mappings.
+	ModeUpload Mode = "upload"
+)
+
+// ParseMode checks that s is a known mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeSync, ModeUpload:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q, expected %s or %s", s, ModeSync, ModeUpload)
+	}
+}
+
+// SetMode changes what the following runs do, runs are in ModeSync by default.
+func (e *Engine) SetMode(m Mode) {
+	e.mode = m
+}
+
+// pushes reports whether webPath belongs to a mapping that uploads its local files.
+func (e *Engine) pushes(webPath string) bool {
+	m, ok := e.mapper.Find(webPath)
+	return ok && m.Direction == config.DirectionPush
+}
+
+// HasPushMappings reports whether any of the mappings uploads its local files.
+func (e *Engine) HasPushMappings() bool {
+	for _, m := range e.c.RootMapping {
+		if m.Direction == config.DirectionPush {
+			return true
+		}
+	}
+	return false
+}
+
+// pulled filters out the files of mappings that upload their local files, those came from this client.
+func (e *Engine) pulled(files []wp) []wp {
+	kept := make([]wp, 0, len(files))
+	for _, f := range files {
+		if !e.pushes(f.WebPath) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// pushPhase uploads the local files of the push mappings that weren't uploaded before.
+func (e *Engine) pushPhase(ctx context.Context, res *Result) error {
+	for _, m := range e.c.RootMapping {
+		if m.Direction != config.DirectionPush {
+			continue
+		}
+		files, err := e.localFiles(m)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("run aborted: %w", err)
+			}
+			fr := e.uploadFile(ctx, f)
+			res.add(fr)
+			if fr.Outcome == Failed && IsFatal(fr.Err) {
+				return fmt.Errorf("run aborted after %s: %w", f.WebPath, KindOf(fr.Err))
+			}
+		}
+	}
+	return nil
+}
+
+// pushFile is a local file that is uploaded to WebPath.
+type pushFile struct {
+	wp
+	local string
+}
+
+// localFiles lists the files of push mapping m that still have to be uploaded, hidden files are left alone as
+// they are usually still being written.
+func (e *Engine) localFiles(m config.FilePath) ([]pushFile, error) {
+	files := make([]pushFile, 0)
+	err := filepath.Walk(m.LocalPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") && p != m.LocalPath {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.LocalPath, p)
+		if err != nil {
+			return err
+		}
+		f := wp{WebPath: path.Join(m.RemotePath, filepath.ToSlash(rel)), Size: fi.Size(), Modified: fi.ModTime()}
+		if e.filters.Allows(f.WebPath, m) && !e.mirrored.has(f) {
+			files = append(files, pushFile{wp: f, local: p})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't scan %s: %w", m.LocalPath, err)
+	}
+	return files, nil
+}
+
+// uploadFile uploads f with retries, and records it so it isn't uploaded again.
+func (e *Engine) uploadFile(ctx context.Context, f pushFile) FileResult {
+	start := time.Now()
+	e.emit(Event{Type: FileStarted, File: f.WebPath, Total: f.Size})
+
+	retries, err := e.retry(ctx, f.WebPath, func() error {
+		return e.upload(ctx, f.WebPath, f.local, f.Size)
+	})
+	if err == nil {
+		err = e.mirrored.add(f.wp)
+	}
+	fr := FileResult{
+		File:        f.WebPath,
+		Local:       f.local,
+		Outcome:     Uploaded,
+		Bytes:       f.Size,
+		Transferred: f.Size,
+		Duration:    time.Since(start),
+		Retries:     retries,
+	}
+	if err != nil {
+		fr.Outcome = Failed
+		fr.Err = err
+		fr.Transferred = 0
+		e.emit(Event{Type: FileFailed, File: f.WebPath, Err: err})
+		return fr
+	}
+	e.emit(Event{Type: FileDone, File: f.WebPath, Bytes: f.Size, Total: f.Size})
+	return fr
+}
+
+// upload POSTs the file at local to webPath on the remote.
+func (e *Engine) upload(ctx context.Context, webPath, local string, size int64) error {
+	fileURL, err := e.createURL(webPath)
+	if err != nil {
+		return fmt.Errorf("couldn't parse remote: %w", err)
+	}
+
+	file, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s: %w", local, err)
+	}
+	defer file.Close()
+
+	req, err := e.newRequest(ctx, http.MethodPost, fileURL.String())
+	if err != nil {
+		return err
+	}
+	progress := &progressWriter{e: e, file: webPath, total: size}
+	body := ratelimit.NewReader(ctx, file, e.limiter, e.mappingLimiter(webPath))
+	req.Body = ioutil.NopCloser(io.TeeReader(body, progress))
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := e.do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't upload %s: %w", local, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("couldn't upload %s: %w", local, err)
+	}
+	return nil
+}
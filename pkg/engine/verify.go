@@ -0,0 +1,49 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// verifyChecksum compares a staged download with the sha-256 digest from the listing or, failing that, the one
+// the remote sent along with the file. Files without a known digest are accepted. It runs before the download is
+// moved into place, so a bad download never replaces a good local file.
+func (e *Engine) verifyChecksum(listed []byte, t transfer, staged *os.File, size int64, local string) error {
+	expected := listed
+	if len(expected) == 0 {
+		expected = t.digest
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+
+	sum := t.sum
+	if sum == nil {
+		var err error
+		if sum, err = e.readerDigest(io.NewSectionReader(staged, 0, size)); err != nil {
+			return fmt.Errorf("couldn't verify %s: %w", local, err)
+		}
+	}
+	if bytes.Equal(sum, expected) {
+		return nil
+	}
+	return fmt.Errorf("%s doesn't match the digest of the remote file: %w", local, ErrChecksumMismatch)
+}
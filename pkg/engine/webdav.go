@@ -0,0 +1,177 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// propfindBody asks for the properties the listing needs.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// webdavBackend pulls files from a WebDAV share, such as Nextcloud or rclone serve webdav. Downloads and deletes
+// are plain HTTP requests, only the listing differs from the HTTP API.
+type webdavBackend struct {
+	httpBackend
+}
+
+// davResponse is a resource in a multistatus response to a PROPFIND.
+type davResponse struct {
+	Href     string `xml:"DAV: href"`
+	Propstat []struct {
+		Status string `xml:"DAV: status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"DAV: collection"`
+			} `xml:"DAV: resourcetype"`
+			ContentLength string `xml:"DAV: getcontentlength"`
+			LastModified  string `xml:"DAV: getlastmodified"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+// list walks the share a directory at a time, as servers tend to refuse listing a whole tree in one request.
+func (b *webdavBackend) list(ctx context.Context, add func(page []wp) error) error {
+	base, err := url.Parse(b.e.c.Remote)
+	if err != nil {
+		return fmt.Errorf("can't parse remote: %w", err)
+	}
+	basePath := strings.TrimSuffix(base.Path, "/")
+
+	var files []wp
+	for dirs := []string{"/"}; len(dirs) > 0; dirs = dirs[1:] {
+		entries, err := b.propfind(ctx, dirs[0])
+		if err != nil {
+			return err
+		}
+		for _, r := range entries {
+			u, err := url.Parse(r.Href)
+			if err != nil || !strings.HasPrefix(u.Path, basePath+"/") {
+				continue
+			}
+			p := path.Clean(strings.TrimPrefix(u.Path, basePath))
+			if p == dirs[0] {
+				continue
+			}
+
+			for _, ps := range r.Propstat {
+				if !strings.Contains(ps.Status, " 200 ") {
+					continue
+				}
+				if ps.Prop.ResourceType.Collection != nil {
+					dirs = append(dirs, p)
+					break
+				}
+				f := wp{WebPath: p}
+				f.Size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+				f.Modified, _ = http.ParseTime(ps.Prop.LastModified)
+				files = append(files, f)
+				break
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].WebPath < files[j].WebPath })
+	return add(files)
+}
+
+// propfind returns the entries of dir, including dir itself.
+func (b *webdavBackend) propfind(ctx context.Context, dir string) ([]davResponse, error) {
+	u, err := b.e.createURL(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse remote: %w", err)
+	}
+	// Collections are addressed with a trailing slash, some servers redirect otherwise.
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	req, err := b.e.newRequest(ctx, "PROPFIND", u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	req.Body = ioutil.NopCloser(strings.NewReader(propfindBody))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(propfindBody)), nil
+	}
+	req.ContentLength = int64(len(propfindBody))
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	req.Header.Set("Depth", "1")
+	if !b.e.c.HTTP.DisableCompression {
+		acceptCompression(req)
+	}
+
+	resp, err := b.e.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	if err := decodeBody(resp); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	entries, err := decodeMultistatus(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	return entries, nil
+}
+
+// decodeMultistatus decodes the responses of a multistatus document one at a time.
+func decodeMultistatus(r io.Reader) ([]davResponse, error) {
+	dec := xml.NewDecoder(r)
+	var entries []davResponse
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse xml: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Space != "DAV:" || se.Name.Local != "response" {
+			continue
+		}
+		var entry davResponse
+		if err := dec.DecodeElement(&entry, &se); err != nil {
+			return nil, fmt.Errorf("couldn't parse xml: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (b *webdavBackend) remove(ctx context.Context, webPath string) error {
+	u, err := b.e.createURL(webPath)
+	if err != nil {
+		return fmt.Errorf("couldn't parse remote: %w", err)
+	}
+	return b.e.delFile(ctx, u)
+}
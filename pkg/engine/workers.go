@@ -0,0 +1,279 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/queue"
+)
+
+// runState is shared by the workers of a run.
+type runState struct {
+	mu sync.Mutex
+	// pending holds the downloads that still have to be deleted from the remote.
+	pending []FileResult
+	// err is set once the run is aborted.
+	err error
+	// started is the amount of files that count towards the limit of files per run, transferred the amount of
+	// bytes downloaded so far. limit is set to the reason once a file was left because of a limit.
+	started     int
+	transferred int64
+	limit       string
+	// changed is closed and replaced whenever files are queued, the listing is complete or a worker frees the slot
+	// of a mapping.
+	changed chan struct{}
+	// listing is true until the listing added all files to the queue.
+	listing bool
+}
+
+func (s *runState) abort(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *runState) aborted() bool {
+	return s.failure() != nil
+}
+
+// failure returns the error the run was aborted with, nil if it wasn't.
+func (s *runState) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// take reserves one of the files of the run, unless the run reached the limit of files or bytes. A limit of
+// zero means no limit.
+func (s *runState) take(maxFiles int, maxBytes int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case maxFiles > 0 && s.started >= maxFiles:
+		s.limit = fmt.Sprintf("limit of %d files per run reached", maxFiles)
+		return false
+	case maxBytes > 0 && s.transferred >= maxBytes:
+		s.limit = fmt.Sprintf("limit of %s per run reached", config.ByteSize(maxBytes))
+		return false
+	}
+	s.started++
+	return true
+}
+
+// addTransferred counts n downloaded bytes towards the limit of bytes per run.
+func (s *runState) addTransferred(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transferred += n
+}
+
+// release gives back a reservation for a file that wasn't transferred.
+func (s *runState) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.started--
+}
+
+// changes returns a channel that is closed once files are queued, the listing is complete or a worker frees the
+// slot of a mapping, any of which can give a waiting worker something to do.
+func (s *runState) changes() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changed
+}
+
+func (s *runState) change() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// listed records that the listing added all files to the queue.
+func (s *runState) listed() {
+	s.mu.Lock()
+	s.listing = false
+	s.mu.Unlock()
+
+	s.change()
+}
+
+func (s *runState) isListing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listing
+}
+
+func (s *runState) addPending(fr FileResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(s.pending, fr)
+}
+
+// process synchronises the files list adds to the queue with the configured amount of workers, which start on
+// the first files while list is still adding more. It returns the downloads that still have to be deleted from the
+// remote.
+func (e *Engine) process(
+	ctx context.Context, res *Result, list func(add func([]wp) error) error,
+) ([]FileResult, error) {
+	workers := e.c.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	s := &runState{pending: make([]FileResult, 0), changed: make(chan struct{}), listing: true}
+	q := queue.New()
+	e.listed = make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.listed()
+		defer close(e.listed)
+		seen := make(map[string]bool)
+		err := list(func(files []wp) error {
+			// There is no point in listing further once the run is aborted.
+			if err := s.failure(); err != nil {
+				return err
+			}
+			e.enqueue(q, seen, files)
+			s.change()
+			return nil
+		})
+		if err != nil {
+			s.abort(err)
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.work(ctx, res, q, s)
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case s.err != nil:
+		deferQueue(res, q, s.err.Error())
+	case s.limit != "":
+		deferQueue(res, q, s.limit)
+	}
+	return s.pending, s.err
+}
+
+// nextFile pops the file with the highest priority whose mapping may start another file, the returned function
+// frees the slot it takes. ok is false if there is no such file.
+func (e *Engine) nextFile(q *queue.Queue, s *runState) (f wp, release func(), ok bool) {
+	if len(e.slots) == 0 {
+		v, ok := q.Pop()
+		if !ok {
+			return wp{}, nil, false
+		}
+		return v.(wp), func() {}, true
+	}
+
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+
+	v, ok := q.PopFunc(func(v interface{}) bool {
+		slots := e.mappingSlots(v.(wp).WebPath)
+		return slots == nil || len(slots) < cap(slots)
+	})
+	if !ok {
+		return wp{}, nil, false
+	}
+	f = v.(wp)
+	slots := e.mappingSlots(f.WebPath)
+	if slots == nil {
+		return f, func() {}, true
+	}
+	// This doesn't block, slots are only taken here with slotMu held.
+	slots <- struct{}{}
+	return f, func() {
+		<-slots
+		s.change()
+	}, true
+}
+
+// work synchronises files from q until it is empty, a limit of the run is reached or the run is aborted.
+func (e *Engine) work(ctx context.Context, res *Result, q *queue.Queue, s *runState) {
+	for !s.aborted() {
+		changed := s.changes()
+		f, release, ok := e.nextFile(q, s)
+		if !ok {
+			if q.Len() == 0 && !s.isListing() {
+				return
+			}
+			// The files that are left belong to mappings that are busy, or the listing didn't get to more files yet.
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				s.abort(fmt.Errorf("run aborted: %w", ctx.Err()))
+				return
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			release()
+			q.Push(f, 0)
+			s.abort(fmt.Errorf("run aborted: %w", err))
+			return
+		}
+
+		if !s.take(e.c.MaxFilesPerRun, int64(e.c.MaxBytesPerRun)) {
+			release()
+			q.Push(f, 0)
+			return
+		}
+
+		fr := e.syncFile(ctx, f)
+		release()
+		if fr.Outcome == Skipped || fr.Outcome == Deferred {
+			s.release()
+		}
+		res.add(fr)
+		s.addTransferred(fr.Transferred)
+		if finished(fr) {
+			if err := e.queued.finish(f.WebPath, fr.Outcome); err != nil {
+				e.emit(Event{Type: Warning, Err: err})
+			}
+		}
+		if fr.Outcome == Downloaded && e.deferredDelete(f.WebPath) {
+			s.addPending(fr)
+		}
+		if fr.Outcome == Failed && IsFatal(fr.Err) {
+			s.abort(fmt.Errorf("run aborted after %s: %w", f.WebPath, KindOf(fr.Err)))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/fakeserver"
+	"golang.org/x/sys/unix"
+)
+
+// getXattr returns the extended attribute name of the file at p.
+func getXattr(t *testing.T, p, name string) string {
+	t.Helper()
+	b := make([]byte, 256)
+	n, err := unix.Getxattr(p, name, b)
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("the filesystem of the test files doesn't support extended attributes")
+	}
+	if err != nil {
+		t.Fatalf("couldn't get %s of %s: %v", name, p, err)
+	}
+	return string(b[:n])
+}
+
+func TestRunSetsMetadata(t *testing.T) {
+	srv := fakeserver.New(fakeserver.Options{Username: "user", Password: "pass"},
+		fakeserver.File{WebPath: "/tv/show/s01e01.mkv", Content: []byte("episode"),
+			Metadata: map[string]string{"comment": "pilot"}},
+	)
+	defer srv.Close()
+
+	c := testConfig(t, srv.URL)
+	c.PreserveMetadata = true
+	c.Provenance = true
+	res, err := New(c).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Filter(Downloaded); len(got) != 1 {
+		t.Fatalf("unexpected downloads %+v", res.Files)
+	}
+
+	local := filepath.Join(c.RootMapping[0].LocalPath, "show", "s01e01.mkv")
+	if got := getXattr(t, local, "user.comment"); got != "pilot" {
+		t.Errorf("user.comment is %q, want the metadata of the listing", got)
+	}
+	if got := getXattr(t, local, "user.mediasync.source"); got != srv.URL+"/tv/show/s01e01.mkv" {
+		t.Errorf("user.mediasync.source is %q", got)
+	}
+	if got := getXattr(t, local, "user.mediasync.run"); got != res.ID {
+		t.Errorf("user.mediasync.run is %q, want %q", got, res.ID)
+	}
+}
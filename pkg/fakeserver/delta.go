@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/rollsum"
+)
+
+// blockSums is the response of /blocksums.
+type blockSums struct {
+	BlockSize int        `json:"block_size"`
+	Size      int        `json:"size"`
+	SHA256    string     `json:"sha256"`
+	Blocks    []blockSum `json:"blocks"`
+}
+
+type blockSum struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// blocksums sends the checksums of the blocks of a file, for delta transfers.
+func (s *Server) blocksums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	f, ok := s.files[strings.TrimPrefix(r.URL.Path, "/blocksums")]
+	s.mu.Unlock()
+	if !ok || s.opts.BlockSize <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	sum := sha256.Sum256(f.Content)
+	res := blockSums{BlockSize: s.opts.BlockSize, Size: len(f.Content), SHA256: hex.EncodeToString(sum[:])}
+	for start := 0; start < len(f.Content); start += s.opts.BlockSize {
+		end := start + s.opts.BlockSize
+		if end > len(f.Content) {
+			end = len(f.Content)
+		}
+		block := f.Content[start:end]
+		strong := sha256.Sum256(block)
+		res.Blocks = append(res.Blocks, blockSum{Weak: rollsum.Checksum(block), Strong: hex.EncodeToString(strong[:])})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// countingWriter counts the bytes of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	s *Server
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.s.mu.Lock()
+	c.s.served += int64(n)
+	c.s.mu.Unlock()
+	return n, err
+}
+
+// Served returns the amount of bytes of file content that were served.
+func (s *Server) Served() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.served
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeserver
+
+import (
+	"crypto/md5" //nolint:gosec // Digest auth is defined with MD5.
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	digestRealm = "mediasync"
+	digestNonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+)
+
+// digestChallenge is the WWW-Authenticate header sent to clients that didn't authenticate.
+func digestChallenge() string {
+	return `Digest realm="` + digestRealm + `", qop="auth,auth-int", nonce="` + digestNonce + `", opaque="fake"`
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s)) //nolint:gosec // Digest auth is defined with MD5.
+	return hex.EncodeToString(sum[:])
+}
+
+// digestAuthorized checks the digest credentials of r, with the MD5 algorithm and qop auth.
+func (s *Server) digestAuthorized(r *http.Request) bool {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Digest ") {
+		return false
+	}
+	params := make(map[string]string)
+	for _, kv := range strings.Split(h[len("Digest "):], ", ") {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			params[kv[:i]] = strings.Trim(kv[i+1:], `"`)
+		}
+	}
+	if params["username"] != s.opts.Username || params["nonce"] != digestNonce || params["qop"] != "auth" ||
+		params["uri"] != r.URL.RequestURI() || params["opaque"] != "fake" {
+		return false
+	}
+
+	ha1 := md5Hex(s.opts.Username + ":" + digestRealm + ":" + s.opts.Password)
+	ha2 := md5Hex(r.Method + ":" + params["uri"])
+	want := md5Hex(ha1 + ":" + digestNonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	return params["response"] == want
+}
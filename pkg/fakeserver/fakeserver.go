@@ -0,0 +1,698 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeserver implements an in-process mediasync server, to test the client and configurations without
+// real infrastructure.
+package fakeserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+type File struct {
+	WebPath  string
+	Content  []byte
+	Priority int
+	// Metadata is sent along with the file in the listing.
+	Metadata map[string]string
+	// Modified is sent as the Last-Modified header, it isn't part of the listing.
+	Modified time.Time
+	// Corrupt serves the file with its first byte changed, while the listing has the digest of Content.
+	Corrupt bool
+	// ListedSize is the size in the listing instead of the length of Content, if it is set.
+	ListedSize int64
+}
+
+type Options struct {
+	// Username and Password are required as basic auth when Username is set.
+	Username string
+	Password string
+	// Digest requires the user name and password with digest auth instead of basic auth.
+	Digest bool
+	// Headers are required on every request, like a proxy in front of the server would.
+	Headers map[string]string
+	// UnixSocket makes the server listen on a Unix socket at this path, URL is then a unix:// URL.
+	UnixSocket string
+	// Token is required as a bearer token or in an X-API-Key header when it is set.
+	Token string
+	// Secret requires requests to be signed with it, see hmacAuthorized.
+	Secret string
+	// Latency is added to every request.
+	Latency time.Duration
+	// FailureRate is the chance, between 0 and 1, that a request fails with a 503.
+	FailureRate float64
+	// Fail makes requests for the given paths fail with the given status code.
+	Fail map[string]int
+	// FailDeletes makes this many deletes fail with a 503 before they start to succeed.
+	FailDeletes int
+	// RateLimit makes this many downloads fail with a 429 and a Retry-After header of RetryAfter seconds.
+	RateLimit  int
+	RetryAfter int
+	// Gzip and Zstd compress the listing and whole file bodies for clients that accept it, zstd is preferred.
+	Gzip bool
+	Zstd bool
+	// TLS serves over TLS with HTTP/2 enabled, with a certificate clients can get from Certificate.
+	TLS bool
+	// Cursors makes the listing hand out cursors for the next page, instead of paging by offset.
+	Cursors bool
+	// Duplicates lists every file twice, like servers sometimes do after a restart.
+	Duplicates bool
+	// Bucket makes the server answer ListObjectsV2 requests for the files below /Bucket, like S3 with path
+	// style addressing. Requests have to be signed with AccessKeyID when it is set.
+	Bucket      string
+	AccessKeyID string
+	// BlockSize makes /blocksums send the checksums of blocks of this size, for delta transfers.
+	BlockSize int
+}
+
+// Server serves a listing of files, which are removed when the client deletes them and added when the client
+// uploads them. It is safe for concurrent use.
+type Server struct {
+	URL string
+
+	mu       sync.Mutex
+	opts     Options
+	order    []string
+	files    map[string]File
+	deleted  []string
+	uploaded []string
+	archived []string
+	// conns counts the connections clients opened, protos the requests per protocol.
+	conns  int
+	protos map[string]int
+	// listings counts the requests for the listing, notModified those that weren't sent because the client had
+	// the listing already.
+	listings    int
+	notModified int
+	// served counts the bytes of file content that were sent.
+	served int64
+	// compressed holds the paths of the responses that were compressed.
+	compressed []string
+	// watchers are the connections of clients that watch for notifications, subscribers those of clients that
+	// subscribed to events.
+	watchers    []*websocket.Conn
+	subscribers []chan []byte
+	eventID     int
+	closed      chan struct{}
+	srv         *httptest.Server
+}
+
+// New starts a server serving files.
+func New(opts Options, files ...File) *Server {
+	s := &Server{
+		opts:     opts,
+		order:    make([]string, 0),
+		files:    make(map[string]File),
+		deleted:  make([]string, 0),
+		uploaded: make([]string, 0),
+		archived: make([]string, 0),
+		protos:   make(map[string]int),
+		closed:   make(chan struct{}),
+	}
+	for _, f := range files {
+		s.Add(f)
+	}
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+		}
+	}
+	if opts.UnixSocket != "" {
+		l, err := net.Listen("unix", opts.UnixSocket)
+		if err != nil {
+			panic(fmt.Sprintf("fakeserver: failed to listen on %s: %v", opts.UnixSocket, err))
+		}
+		s.srv.Listener.Close()
+		s.srv.Listener = l
+	}
+	if opts.TLS {
+		s.srv.EnableHTTP2 = true
+		s.srv.StartTLS()
+	} else {
+		s.srv.Start()
+	}
+	s.URL = s.srv.URL
+	if opts.UnixSocket != "" {
+		s.URL = "unix://" + opts.UnixSocket
+	}
+	return s
+}
+
+// Add adds f to the listing, replacing any file with the same path, and announces it to the clients that watch
+// for notifications.
+func (s *Server) Add(f File) {
+	s.mu.Lock()
+	if _, ok := s.files[f.WebPath]; !ok {
+		s.order = append(s.order, f.WebPath)
+	}
+	s.files[f.WebPath] = f
+	watchers := append([]*websocket.Conn{}, s.watchers...)
+	msg, _ := json.Marshal(entry{WebPath: f.WebPath, Size: int64(len(f.Content))})
+	for _, sub := range s.subscribers {
+		select {
+		case sub <- msg:
+		default:
+		}
+	}
+	s.mu.Unlock()
+
+	for _, w := range watchers {
+		_ = w.Write(msg)
+	}
+}
+
+// Watchers returns the amount of clients that watch for notifications or subscribe to events.
+func (s *Server) Watchers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.watchers) + len(s.subscribers)
+}
+
+// events sends a file-added server-sent event for every file that is added, until the client or the server goes
+// away.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := make(chan []byte, 16)
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, sub)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, c := range s.subscribers {
+			if c == sub {
+				s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(": subscribed\n\n"))
+	flusher.Flush()
+	for {
+		select {
+		case msg := <-sub:
+			s.mu.Lock()
+			s.eventID++
+			id := s.eventID
+			s.mu.Unlock()
+			fmt.Fprintf(w, "event: file-added\nid: %d\ndata: %s\n\n", id, msg)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// notifications keeps a WebSocket connection to a client, to announce new files on.
+func (s *Server) notifications(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.watchers = append(s.watchers, conn)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, c := range s.watchers {
+			if c == conn {
+				s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+				break
+			}
+		}
+	}()
+	for {
+		if _, err := conn.Read(); err != nil {
+			return
+		}
+	}
+}
+
+// Files returns the paths of the files that haven't been deleted yet.
+func (s *Server) Files() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.order...)
+}
+
+// Deleted returns the paths of the files the client deleted, in order.
+func (s *Server) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.deleted...)
+}
+
+// Compressed returns the paths of the responses that were compressed.
+func (s *Server) Compressed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.compressed...)
+}
+
+// Uploaded returns the paths of the files the client uploaded, in order.
+func (s *Server) Uploaded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.uploaded...)
+}
+
+// Archived returns the paths of the files the client archived, in order.
+func (s *Server) Archived() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.archived...)
+}
+
+// Connections returns the amount of connections clients opened.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns
+}
+
+// Requests returns the amount of requests that used proto, like HTTP/1.1 or HTTP/2.0.
+func (s *Server) Requests(proto string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.protos[proto]
+}
+
+// Listings returns how often the listing, or a page of it, was requested.
+func (s *Server) Listings() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listings
+}
+
+// NotModified returns how often the listing wasn't sent because the client already had it.
+func (s *Server) NotModified() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.notModified
+}
+
+// Certificate returns the certificate of a TLS server.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.srv.Certificate()
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	watchers := append([]*websocket.Conn{}, s.watchers...)
+	s.mu.Unlock()
+	for _, w := range watchers {
+		_ = w.Close()
+	}
+	close(s.closed)
+	s.srv.Close()
+}
+
+type entry struct {
+	WebPath  string            `json:"web_path"`
+	Priority int               `json:"priority,omitempty"`
+	Size     int64             `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	SHA256   string            `json:"sha256"`
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.protos[r.Proto]++
+	s.mu.Unlock()
+
+	if s.opts.Latency > 0 {
+		time.Sleep(s.opts.Latency)
+	}
+
+	if !s.authorized(r) {
+		if s.opts.Digest {
+			w.Header().Set("WWW-Authenticate", digestChallenge())
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/notifications" {
+		s.notifications(w, r)
+		return
+	}
+	if r.URL.Path == "/events" {
+		s.events(w, r)
+		return
+	}
+	if code, ok := s.opts.Fail[r.URL.Path]; ok {
+		http.Error(w, "injected failure", code)
+		return
+	}
+	if s.opts.FailureRate > 0 && rand.Float64() < s.opts.FailureRate { //nolint:gosec // Not used for security.
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	if cw := s.compressWriter(w, r); cw != nil {
+		defer cw.zw.Close()
+		w = cw
+		s.mu.Lock()
+		s.compressed = append(s.compressed, r.URL.Path)
+		s.mu.Unlock()
+	}
+
+	if s.opts.Bucket != "" && r.URL.Path == "/"+s.opts.Bucket && r.URL.Query().Get("list-type") == "2" {
+		s.listObjects(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/blocksums/") {
+		s.blocksums(w, r)
+		return
+	}
+	if r.URL.Path == "/fileinfo" {
+		s.listing(w, r)
+		return
+	}
+	if r.URL.Path == "/healthz" {
+		_, _ = w.Write([]byte("ok"))
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/archive/") && r.Method == http.MethodPost {
+		s.archive(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.rateLimited() {
+			w.Header().Set("Retry-After", strconv.Itoa(s.opts.RetryAfter))
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		s.serve(w, r)
+	case http.MethodDelete:
+		s.delete(w, r)
+	case http.MethodPost:
+		s.upload(w, r)
+	case "PROPFIND":
+		s.propfind(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// compressWriter returns a writer that compresses the response to r, or nil if it isn't compressed.
+func (s *Server) compressWriter(w http.ResponseWriter, r *http.Request) *compressWriter {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return nil
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case s.opts.Zstd && strings.Contains(accept, "zstd"):
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			panic(fmt.Sprintf("fakeserver: failed to set up zstd: %v", err))
+		}
+		return &compressWriter{ResponseWriter: w, zw: zw, encoding: "zstd"}
+	case s.opts.Gzip && strings.Contains(accept, "gzip"):
+		return &compressWriter{ResponseWriter: w, zw: gzip.NewWriter(w), encoding: "gzip"}
+	}
+	return nil
+}
+
+// compressWriter compresses a response.
+type compressWriter struct {
+	http.ResponseWriter
+	zw          io.WriteCloser
+	encoding    string
+	wroteHeader bool
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	c.wroteHeader = true
+	c.Header().Del("Content-Length")
+	c.Header().Set("Content-Encoding", c.encoding)
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.zw.Write(b)
+}
+
+// rateLimited reports whether a download should be refused because of the rate limit.
+func (s *Server) rateLimited() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opts.RateLimit > 0 {
+		s.opts.RateLimit--
+		return true
+	}
+	return false
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	for k, v := range s.opts.Headers {
+		if r.Header.Get(k) != v {
+			return false
+		}
+	}
+	if s.opts.AccessKeyID != "" {
+		return s.s3Authorized(r)
+	}
+	if s.opts.Secret != "" {
+		return s.hmacAuthorized(r)
+	}
+	if s.opts.Token != "" {
+		return r.Header.Get("Authorization") == "Bearer "+s.opts.Token || r.Header.Get("X-API-Key") == s.opts.Token
+	}
+	if s.opts.Username != "" && s.opts.Digest {
+		return s.digestAuthorized(r)
+	}
+	if s.opts.Username != "" {
+		u, p, ok := r.BasicAuth()
+		return ok && u == s.opts.Username && p == s.opts.Password
+	}
+	return true
+}
+
+// hmacAuthorized checks the X-Signature header of r, the HMAC-SHA256 of its method, path and X-Timestamp header.
+// Signatures older than five minutes aren't accepted.
+func (s *Server) hmacAuthorized(r *http.Request) bool {
+	ts := r.Header.Get("X-Timestamp")
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(secs, 0)) > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.opts.Secret))
+	mac.Write([]byte(r.Method + "\n" + r.URL.EscapedPath() + "\n" + ts))
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature"))
+	return err == nil && hmac.Equal(sig, mac.Sum(nil))
+}
+
+func (s *Server) listing(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	entries := make([]entry, 0, len(s.order))
+	for _, p := range s.order {
+		f := s.files[p]
+		sum := sha256.Sum256(f.Content)
+		size := f.ListedSize
+		if size == 0 {
+			size = int64(len(f.Content))
+		}
+		entries = append(entries, entry{
+			WebPath:  p,
+			Priority: f.Priority,
+			Size:     size,
+			Metadata: f.Metadata,
+			SHA256:   hex.EncodeToString(sum[:]),
+		})
+	}
+	if s.opts.Duplicates {
+		entries = append(entries, entries...)
+	}
+	s.listings++
+	s.mu.Unlock()
+
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		s.page(w, r, entries, limit)
+		return
+	}
+
+	b, _ := json.Marshal(entries)
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		s.mu.Lock()
+		s.notModified++
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write(b)
+}
+
+// page sends limit entries from the offset or cursor of the request, the cursor of the next page is sent in the
+// X-Next-Cursor header if the server uses cursors.
+func (s *Server) page(w http.ResponseWriter, r *http.Request, entries []entry, limit int) {
+	start := r.URL.Query().Get("offset")
+	if s.opts.Cursors {
+		start = r.URL.Query().Get("cursor")
+	}
+	offset, _ := strconv.Atoi(start)
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	end := offset + limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	if s.opts.Cursors && end < len(entries) {
+		w.Header().Set("X-Next-Cursor", strconv.Itoa(end))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries[offset:end])
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	f, ok := s.files[r.URL.Path]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	content := f.Content
+	if s.opts.Bucket != "" {
+		s3Checksum(w, r, content)
+	}
+	if f.Corrupt && len(content) > 0 {
+		content = append([]byte{content[0] ^ 0xff}, content[1:]...)
+	}
+	sum := sha256.Sum256(f.Content)
+	w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(sum[:8])))
+	http.ServeContent(&countingWriter{ResponseWriter: w, s: s}, r, f.WebPath, f.Modified, bytes.NewReader(content))
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Add(File{WebPath: r.URL.Path, Content: b})
+	s.mu.Lock()
+	s.uploaded = append(s.uploaded, r.URL.Path)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) archive(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/archive")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.remove(p) {
+		http.NotFound(w, r)
+		return
+	}
+	s.archived = append(s.archived, p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove takes the file at p out of the listing, s.mu has to be held. It reports whether the file existed.
+func (s *Server) remove(p string) bool {
+	if _, ok := s.files[p]; !ok {
+		return false
+	}
+	delete(s.files, p)
+	for i, o := range s.order {
+		if o == p {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[r.URL.Path]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if s.opts.FailDeletes > 0 {
+		s.opts.FailDeletes--
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.remove(r.URL.Path)
+	s.deleted = append(s.deleted, r.URL.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listBucketResult is the response to a ListObjectsV2 request.
+type listBucketResult struct {
+	XMLName               xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	KeyCount              int        `xml:"KeyCount"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3Object `xml:"Contents"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int    `xml:"Size"`
+}
+
+// s3Authorized checks that r is signed with the access key, the signature itself isn't verified.
+func (s *Server) s3Authorized(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+s.opts.AccessKeyID+"/") &&
+		r.Header.Get("X-Amz-Date") != ""
+}
+
+// listObjects answers a ListObjectsV2 request for the bucket, the files are the keys with their leading slash and
+// the bucket removed. The continuation token is an offset.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+
+	s.mu.Lock()
+	var keys []string
+	files := make(map[string]File)
+	for p, f := range s.files {
+		key := strings.TrimPrefix(p, "/"+s.opts.Bucket+"/")
+		if key != p && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+			files[key] = f
+		}
+	}
+	s.listings++
+	s.mu.Unlock()
+	sort.Strings(keys)
+
+	offset, _ := strconv.Atoi(q.Get("continuation-token"))
+	if offset > len(keys) {
+		offset = len(keys)
+	}
+	end := len(keys)
+	if max, err := strconv.Atoi(q.Get("max-keys")); err == nil && max > 0 && offset+max < end {
+		end = offset + max
+	}
+
+	res := listBucketResult{Name: s.opts.Bucket, Prefix: prefix, KeyCount: end - offset, IsTruncated: end < len(keys)}
+	if res.IsTruncated {
+		res.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, k := range keys[offset:end] {
+		f := files[k]
+		modified := f.Modified
+		if modified.IsZero() {
+			modified = time.Now()
+		}
+		res.Contents = append(res.Contents, s3Object{
+			Key:          k,
+			LastModified: modified.UTC().Format(time.RFC3339),
+			Size:         len(f.Content),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+// s3Checksum sets the checksum S3 sends for objects that were uploaded with one, when the client asks for it. It
+// isn't sent for ranges.
+func s3Checksum(w http.ResponseWriter, r *http.Request, content []byte) {
+	if r.Header.Get("X-Amz-Checksum-Mode") != "ENABLED" || r.Header.Get("Range") != "" {
+		return
+	}
+	sum := sha256.Sum256(content)
+	w.Header().Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+}
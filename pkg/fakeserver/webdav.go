@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// propfind answers a WebDAV PROPFIND for a directory with its direct children, like Nextcloud it refuses to
+// list a whole tree at once.
+func (s *Server) propfind(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Depth") != "1" {
+		http.Error(w, "only depth 1 is supported", http.StatusForbidden)
+		return
+	}
+	dir := strings.TrimSuffix(r.URL.Path, "/")
+
+	s.mu.Lock()
+	files := make(map[string]File)
+	dirs := make(map[string]bool)
+	for p, f := range s.files {
+		if !strings.HasPrefix(p, dir+"/") {
+			continue
+		}
+		rest := strings.TrimPrefix(p, dir+"/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			dirs[dir+"/"+rest[:i]] = true
+			continue
+		}
+		files[p] = f
+	}
+	s.mu.Unlock()
+
+	if dir != "" && len(files) == 0 && len(dirs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:">`)
+	davEntry(&b, dir+"/", `<d:resourcetype><d:collection/></d:resourcetype>`)
+	names := make([]string, 0, len(dirs))
+	for d := range dirs {
+		names = append(names, d)
+	}
+	sort.Strings(names)
+	for _, d := range names {
+		davEntry(&b, d+"/", `<d:resourcetype><d:collection/></d:resourcetype>`)
+	}
+	names = names[:0]
+	for p := range files {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		f := files[p]
+		props := fmt.Sprintf(`<d:resourcetype/><d:getcontentlength>%d</d:getcontentlength>`, len(f.Content))
+		if !f.Modified.IsZero() {
+			props += fmt.Sprintf(`<d:getlastmodified>%s</d:getlastmodified>`, f.Modified.UTC().Format(http.TimeFormat))
+		}
+		davEntry(&b, p, props)
+	}
+	b.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// davEntry writes the response for the resource at p with its properties.
+func davEntry(b *strings.Builder, p, props string) {
+	href := (&url.URL{Path: p}).EscapedPath()
+	b.WriteString(`<d:response><d:href>`)
+	_ = xml.EscapeText(b, []byte(href))
+	fmt.Fprintf(b, `</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`, props)
+	b.WriteString(`</d:response>`)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filter decides which remote files are synchronised, using include and exclude patterns.
+//
+// Patterns are globs, unless they start with "re:", then the rest is a regular expression. Globs without a
+// slash are matched against the name of the file, globs with a slash and regular expressions are matched
+// against the full remote path.
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/mapping"
+)
+
+const regexPrefix = "re:"
+
+type matcher func(p string) bool
+
+func compile(pattern string) (matcher, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	// Match only reports malformed patterns when they are used, so try it once.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return func(p string) bool {
+		if !strings.Contains(pattern, "/") {
+			p = path.Base(p)
+		}
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}, nil
+}
+
+func compileAll(patterns []string) ([]matcher, error) {
+	ms := make([]matcher, 0, len(patterns))
+	for _, p := range patterns {
+		m, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func matchAny(ms []matcher, p string) bool {
+	for _, m := range ms {
+		if m(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter allows paths that match one of its include patterns, if it has any, and none of its exclude patterns.
+type Filter struct {
+	include []matcher
+	exclude []matcher
+}
+
+func New(include, exclude []string) (*Filter, error) {
+	in, err := compileAll(include)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := compileAll(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{include: in, exclude: ex}, nil
+}
+
+// Allows reports whether the remote path p passes the filter.
+func (f *Filter) Allows(p string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, p) {
+		return false
+	}
+	return !matchAny(f.exclude, p)
+}
+
+// Set holds the global filter and the filters of the mappings, it is safe for concurrent use.
+type Set struct {
+	global   *Filter
+	mappings map[string]*Filter
+}
+
+// FromConfig compiles the filters of the configuration.
+func FromConfig(c *config.Configuration) (*Set, error) {
+	global, err := New(c.Include, c.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Set{global: global, mappings: make(map[string]*Filter, len(c.RootMapping))}
+	for _, m := range c.RootMapping {
+		f, err := New(m.Include, m.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %w", m.RemotePath, err)
+		}
+		s.mappings[mapping.Clean(m.RemotePath)] = f
+	}
+	return s, nil
+}
+
+// Allows reports whether the remote path p passes both the global filter and the filter of its mapping m.
+func (s *Set) Allows(p string, m config.FilePath) bool {
+	if !s.global.Allows(p) {
+		return false
+	}
+	f, ok := s.mappings[mapping.Clean(m.RemotePath)]
+	return !ok || f.Allows(p)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+func TestAllows(t *testing.T) {
+	c := &config.Configuration{
+		Exclude: []string{"*.nfo", "re:(?i)sample"},
+		RootMapping: []config.FilePath{
+			{RemotePath: "/tv", Include: []string{"*.mkv", "*.srt"}},
+			{RemotePath: "/movies", Exclude: []string{"/movies/*/extras/*"}},
+		},
+	}
+	s, err := FromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote  string
+		mapping int
+		allowed bool
+	}{
+		{"/tv/show/s01e01.mkv", 0, true},
+		{"/tv/show/s01e01.srt", 0, true},
+		{"/tv/show/s01e01.txt", 0, false},
+		{"/tv/show/tvshow.nfo", 0, false},
+		{"/tv/show/Sample/s01e01.mkv", 0, false},
+		{"/movies/film/film.mkv", 1, true},
+		{"/movies/film/extras/interview.mkv", 1, false},
+		{"/movies/film/film.nfo", 1, false},
+	}
+
+	for _, tt := range tests {
+		if got := s.Allows(tt.remote, c.RootMapping[tt.mapping]); got != tt.allowed {
+			t.Errorf("Allows(%q) = %v, want %v", tt.remote, got, tt.allowed)
+		}
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	for _, p := range []string{"[", "re:("} {
+		if _, err := New(nil, []string{p}); err == nil {
+			t.Errorf("expected an error for %q", p)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package journal keeps a write-ahead log of the files that are being synchronised, so that after a crash it is
+// known how far along each file got.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/fsutil"
+)
+
+// Stage is a step in the pipeline every file goes through, in this order.
+type Stage string
+
+const (
+	// Downloading means the file is being written to Tmp, the remote file is untouched.
+	Downloading Stage = "downloading"
+	// Downloaded means Tmp is complete, but not yet moved into place.
+	Downloaded Stage = "downloaded"
+	// Renamed means the file is in place at Local, but still present on the remote.
+	Renamed Stage = "renamed"
+	// Deleted means the file has been removed from the remote, by deleting or archiving it, the file is done.
+	Deleted Stage = "deleted"
+	// RolledBack means an interrupted download has been cleaned up, the file is still on the remote.
+	RolledBack Stage = "rolled_back"
+	// Kept means the file is in place at Local and deliberately left on the remote, the file is done.
+	Kept Stage = "kept"
+)
+
+// Final reports whether nothing is left to do for a file in stage s.
+func (s Stage) Final() bool {
+	return s == Deleted || s == RolledBack || s == Kept
+}
+
+type Entry struct {
+	WebPath string `json:"web_path"`
+	Local   string `json:"local"`
+	// Tmp is the temporary file the download is written to, empty if it has no name.
+	Tmp   string    `json:"tmp,omitempty"`
+	Stage Stage     `json:"stage"`
+	Time  time.Time `json:"time"`
+}
+
+// Journal is an append-only file of entries, it is safe for concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open journal: %w", err)
+	}
+	return &Journal{path: path, f: f}, nil
+}
+
+// Record appends e to the journal and makes sure it is on disk before returning.
+func (j *Journal) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("couldn't write journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+// Pending returns the latest entry of every file that didn't reach a final stage, in journal order.
+func (j *Journal) Pending() ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.pending()
+}
+
+func (j *Journal) pending() ([]Entry, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read journal: %w", err)
+	}
+	defer f.Close()
+
+	latest := make(map[string]Entry)
+	order := make([]string, 0)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e Entry
+		// A crash can leave a partially written last line, which is safe to ignore.
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		if _, ok := latest[e.WebPath]; !ok {
+			order = append(order, e.WebPath)
+		}
+		latest[e.WebPath] = e
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read journal: %w", err)
+	}
+
+	entries := make([]Entry, 0)
+	for _, wp := range order {
+		if e := latest[wp]; !e.Stage.Final() {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Compact rewrites the journal so it only contains the pending entries.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries, err := j.pending()
+	if err != nil {
+		return err
+	}
+
+	// The new journal is written through the handle that is used once it is in place, so the old one stays usable
+	// until then.
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't compact journal: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("couldn't compact journal: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't compact journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't compact journal: %w", err)
+	}
+
+	// The old handle points to the replaced file.
+	_ = j.f.Close()
+	j.f = f
+	if err := fsutil.SyncDir(filepath.Dir(j.path)); err != nil {
+		return fmt.Errorf("couldn't compact journal: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.f.Close()
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package journal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func open(t *testing.T) (*Journal, string) {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), "journal")
+	j, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+	return j, p
+}
+
+func record(t *testing.T, j *Journal, webPath string, stage Stage) {
+	t.Helper()
+
+	if err := j.Record(Entry{WebPath: webPath, Local: "/media" + webPath, Stage: stage}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPending(t *testing.T) {
+	j, _ := open(t)
+	record(t, j, "/tv/a.mkv", Downloading)
+	record(t, j, "/tv/b.mkv", Downloading)
+	record(t, j, "/tv/a.mkv", Downloaded)
+	record(t, j, "/tv/b.mkv", Deleted)
+	record(t, j, "/tv/c.mkv", Renamed)
+
+	entries, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].WebPath != "/tv/a.mkv" || entries[0].Stage != Downloaded ||
+		entries[1].WebPath != "/tv/c.mkv" || entries[1].Stage != Renamed {
+		t.Errorf("unexpected pending entries: %+v", entries)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("entries aren't timestamped")
+	}
+}
+
+func TestPendingTruncated(t *testing.T) {
+	j, p := open(t)
+	record(t, j, "/tv/a.mkv", Renamed)
+
+	// A crash in the middle of a write leaves half a line.
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"web_path":"/tv/a.mkv","stage":"del`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	entries, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Stage != Renamed {
+		t.Errorf("unexpected pending entries: %+v", entries)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	j, p := open(t)
+	record(t, j, "/tv/a.mkv", Downloading)
+	record(t, j, "/tv/a.mkv", Deleted)
+	record(t, j, "/tv/b.mkv", Downloading)
+	record(t, j, "/tv/b.mkv", Downloaded)
+
+	if err := j.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 1 || !bytes.Contains(b, []byte(`"stage":"downloaded"`)) {
+		t.Errorf("unexpected compacted journal:\n%s", b)
+	}
+	if _, err := os.Stat(p + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file was left behind: %v", err)
+	}
+
+	// Records go to the compacted journal.
+	record(t, j, "/tv/b.mkv", Renamed)
+	entries, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Stage != Renamed {
+		t.Errorf("unexpected pending entries after compacting: %+v", entries)
+	}
+}
+
+func TestCompactFailure(t *testing.T) {
+	j, p := open(t)
+	record(t, j, "/tv/a.mkv", Downloading)
+
+	// A directory in the way of the temporary file makes compacting fail.
+	if err := os.Mkdir(p+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Compact(); err == nil {
+		t.Fatal("compacting succeeded")
+	}
+	record(t, j, "/tv/a.mkv", Downloaded)
+	entries, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Stage != Downloaded {
+		t.Errorf("journal isn't usable after a failed compaction: %+v", entries)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// run runs a command of the keyring with stdin as input, and returns its output without the trailing newline.
+func run(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", &commandError{name: name, err: err, stderr: strings.TrimSpace(stderr.String())}
+		}
+		return "", fmt.Errorf("couldn't run %s: %w", name, err)
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+// commandError is a failed keyring command.
+type commandError struct {
+	name   string
+	err    error
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("%s failed: %v", e.name, e.err)
+	}
+	return fmt.Sprintf("%s failed: %v: %s", e.name, e.err, e.stderr)
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
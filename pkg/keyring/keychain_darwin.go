@@ -0,0 +1,48 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyring
+
+import (
+	"errors"
+)
+
+// errItemNotFound is the exit code of security if the keychain has no such item.
+const errItemNotFound = 44
+
+func get(account string) (string, error) {
+	secret, err := run("", "security", "find-generic-password", "-s", Service, "-a", account, "-w")
+	var cerr *commandError
+	if errors.As(err, &cerr) && exitCode(cerr.err) == errItemNotFound {
+		return "", ErrNotFound
+	}
+	return secret, err
+}
+
+// set passes the secret as an argument, security can't read it from stdin. It is only briefly visible to other
+// users of the machine, while security runs.
+func set(account, secret string) error {
+	_, err := run("", "security", "add-generic-password", "-U", "-s", Service, "-a", account, "-w", secret)
+	return err
+}
+
+func exitCode(err error) int {
+	var eerr interface{ ExitCode() int }
+	if errors.As(err, &eerr) {
+		return eerr.ExitCode()
+	}
+	return -1
+}
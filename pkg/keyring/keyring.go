@@ -0,0 +1,39 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keyring keeps secrets in the keyring of the OS: the Secret Service through secret-tool on Linux and
+// the BSDs, the keychain through security on macOS and the credential manager on Windows.
+package keyring
+
+import (
+	"errors"
+)
+
+// Service is the service all secrets of the client are stored under.
+const Service = "mediasync-client"
+
+// ErrNotFound means the keyring has no secret for the account.
+var ErrNotFound = errors.New("secret not found in the keyring")
+
+// Get returns the secret of account, or ErrNotFound.
+func Get(account string) (string, error) {
+	return get(account)
+}
+
+// Set stores secret for account, replacing an existing one.
+func Set(account, secret string) error {
+	return set(account, secret)
+}
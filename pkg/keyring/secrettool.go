@@ -0,0 +1,41 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyring
+
+import (
+	"errors"
+)
+
+// Secrets are looked up by the service and account attributes, the same as other clients of secret-tool do.
+func get(account string) (string, error) {
+	secret, err := run("", "secret-tool", "lookup", "service", Service, "account", account)
+	var cerr *commandError
+	// secret-tool exits with 1 without saying anything if there is no such secret.
+	if errors.As(err, &cerr) && cerr.stderr == "" {
+		return "", ErrNotFound
+	}
+	return secret, err
+}
+
+func set(account, secret string) error {
+	_, err := run(secret, "secret-tool", "store", "--label", Service+" "+account,
+		"service", Service, "account", account)
+	return err
+}
@@ -0,0 +1,64 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyring
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSecretTool keeps the secrets in files named after the account.
+const fakeSecretTool = `#!/bin/sh
+case "$1" in
+lookup) cat "$STORE/$5" 2>/dev/null || exit 1 ;;
+store) cat > "$STORE/$7" ;;
+esac
+`
+
+func TestSecretTool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte(fakeSecretTool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	_ = os.Setenv("STORE", dir)
+	defer func() {
+		_ = os.Setenv("PATH", path)
+		_ = os.Unsetenv("STORE")
+	}()
+
+	if _, err := Get("telegram"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := Set("telegram", "123:token"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Get("telegram"); err != nil || s != "123:token" {
+		t.Errorf("got %q, %v", s, err)
+	}
+}
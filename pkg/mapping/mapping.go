@@ -0,0 +1,95 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mapping maps remote paths to local paths, according to the root mappings of the configuration.
+package mapping
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+// Mapper finds the mapping for remote paths, it is safe for concurrent use.
+type Mapper struct {
+	// mappings are sorted by the length of their remote path, longest first.
+	mappings []config.FilePath
+}
+
+// Clean normalises a remote path, it always starts with a slash and never contains dot segments.
+func Clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Validate returns an error if mappings are ambiguous, which is when several mappings have the same remote path.
+func Validate(mappings []config.FilePath) error {
+	seen := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		p := Clean(m.RemotePath)
+		if other, ok := seen[p]; ok {
+			return fmt.Errorf("remote path %s is mapped to both %s and %s", p, other, m.LocalPath)
+		}
+		seen[p] = m.LocalPath
+	}
+	return nil
+}
+
+func New(mappings []config.FilePath) *Mapper {
+	ms := make([]config.FilePath, 0, len(mappings))
+	for _, m := range mappings {
+		m.RemotePath = Clean(m.RemotePath)
+		ms = append(ms, m)
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		return len(ms[i].RemotePath) > len(ms[j].RemotePath)
+	})
+	return &Mapper{mappings: ms}
+}
+
+// under reports whether p is prefix itself, or a path below it.
+func under(p, prefix string) bool {
+	if prefix == "/" || p == prefix {
+		return true
+	}
+	return strings.HasPrefix(p, prefix+"/")
+}
+
+// Find returns the mapping for remotePath, the mapping with the longest matching remote path wins. Mappings
+// only match on path boundaries, so /tv2/file doesn't match a /tv mapping.
+func (m *Mapper) Find(remotePath string) (config.FilePath, bool) {
+	p := Clean(remotePath)
+	for _, fp := range m.mappings {
+		if under(p, fp.RemotePath) {
+			return fp, true
+		}
+	}
+	return config.FilePath{}, false
+}
+
+// Local returns the local path for remotePath, which is always inside the local path of its mapping.
+func (m *Mapper) Local(remotePath string) (string, bool) {
+	fp, ok := m.Find(remotePath)
+	if !ok {
+		return "", false
+	}
+
+	rel := strings.TrimPrefix(Clean(remotePath), fp.RemotePath)
+	return filepath.Join(fp.LocalPath, filepath.FromSlash(rel)), true
+}
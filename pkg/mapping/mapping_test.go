@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+)
+
+var testMappings = []config.FilePath{
+	{RemotePath: "/tv", LocalPath: "/media/tv"},
+	{RemotePath: "/tv/kids/", LocalPath: "/media/kids"},
+	{RemotePath: "/movies", LocalPath: "/media/movies"},
+}
+
+func TestLocal(t *testing.T) {
+	tests := []struct {
+		remote string
+		local  string
+		ok     bool
+	}{
+		{"/tv/show/s01e01.mkv", "/media/tv/show/s01e01.mkv", true},
+		{"/tv/kids/show/s01e01.mkv", "/media/kids/show/s01e01.mkv", true},
+		{"/tv2/show/s01e01.mkv", "", false},
+		{"/movies/film.mkv", "/media/movies/film.mkv", true},
+		{"movies//film.mkv", "/media/movies/film.mkv", true},
+		{"/tv/../etc/passwd", "", false},
+		{"/tv/show/../../tv/kids/x.mkv", "/media/kids/x.mkv", true},
+		{"/other/file", "", false},
+	}
+
+	m := New(testMappings)
+	for _, tt := range tests {
+		local, ok := m.Local(tt.remote)
+		if local != tt.local || ok != tt.ok {
+			t.Errorf("Local(%q) = %q, %v, want %q, %v", tt.remote, local, ok, tt.local, tt.ok)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(testMappings); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ambiguous := append([]config.FilePath{{RemotePath: "/movies/", LocalPath: "/other"}}, testMappings...)
+	if err := Validate(ambiguous); err == nil {
+		t.Error("expected an error for ambiguous mappings")
+	}
+}
+
+func FuzzLocal(f *testing.F) {
+	for _, s := range []string{"/tv/show/file", "/tv/kids/../x", "/tv2/x", "../../etc", "/movies"} {
+		f.Add(s)
+	}
+
+	m := New(testMappings)
+	f.Fuzz(func(t *testing.T, remote string) {
+		fp, ok := m.Find(remote)
+		local, lok := m.Local(remote)
+		if ok != lok {
+			t.Fatalf("Find and Local disagree on %q", remote)
+		}
+		if !ok {
+			return
+		}
+
+		rel, err := filepath.Rel(fp.LocalPath, local)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			t.Fatalf("%q maps to %q, outside of %q", remote, local, fp.LocalPath)
+		}
+		if !under(Clean(remote), fp.RemotePath) {
+			t.Fatalf("%q matched %q on a partial path segment", remote, fp.RemotePath)
+		}
+	})
+}
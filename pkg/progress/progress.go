@@ -0,0 +1,175 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package progress shows the progress of downloads on the console, as bars when it is attached to a terminal
+// and as periodic lines otherwise.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+)
+
+const (
+	barWidth = 30
+	percent  = 100
+	// ttyInterval and lineInterval are the minimum time between updates on terminals and other outputs.
+	ttyInterval  = 200 * time.Millisecond
+	lineInterval = 10 * time.Second
+)
+
+type file struct {
+	name    string
+	bytes   int64
+	total   int64
+	started time.Time
+}
+
+func (f *file) rate() config.ByteSize {
+	d := time.Since(f.started).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return config.ByteSize(float64(f.bytes) / d)
+}
+
+// Printer prints the progress of the files that are being downloaded, it is safe for concurrent use.
+type Printer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+	files    map[string]*file
+	// order keeps the files in the order they were started, so the bars don't jump around.
+	order []string
+	last  time.Time
+	// lines is the amount of lines drawn last time on a terminal.
+	lines int
+}
+
+// New returns a printer that writes to f, drawing bars if f is a terminal.
+func New(f *os.File) *Printer {
+	p := &Printer{w: f, interval: lineInterval, files: make(map[string]*file)}
+	if fi, err := f.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		p.tty = true
+		p.interval = ttyInterval
+	}
+	return p
+}
+
+// Handle is an engine.Handler.
+func (p *Printer) Handle(ev engine.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch ev.Type {
+	case engine.FileStarted:
+		p.files[ev.File] = &file{name: path.Base(ev.File), total: ev.Total, started: time.Now()}
+		p.order = append(p.order, ev.File)
+		if !p.tty {
+			return
+		}
+	case engine.Progress:
+		f, ok := p.files[ev.File]
+		if !ok {
+			return
+		}
+		f.bytes, f.total = ev.Bytes, ev.Total
+		if time.Since(p.last) < p.interval {
+			return
+		}
+	case engine.FileDone, engine.FileFailed, engine.FileSkipped:
+		if _, ok := p.files[ev.File]; !ok {
+			return
+		}
+		p.remove(ev.File)
+		if !p.tty {
+			return
+		}
+	default:
+		return
+	}
+	p.draw()
+}
+
+func (p *Printer) remove(webPath string) {
+	delete(p.files, webPath)
+	for i, o := range p.order {
+		if o == webPath {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *Printer) draw() {
+	p.last = time.Now()
+
+	var b strings.Builder
+	if p.tty && p.lines > 0 {
+		// Move back to the first bar that was drawn last time.
+		fmt.Fprintf(&b, "\x1b[%dA", p.lines)
+	}
+	for _, o := range p.order {
+		f := p.files[o]
+		if p.tty {
+			b.WriteString("\x1b[2K")
+			b.WriteString(bar(f))
+		} else {
+			b.WriteString(line(f))
+		}
+		b.WriteByte('\n')
+	}
+	if p.tty {
+		// Clear the bars of files that are done.
+		for i := len(p.order); i < p.lines; i++ {
+			b.WriteString("\x1b[2K\n")
+		}
+		if extra := p.lines - len(p.order); extra > 0 {
+			fmt.Fprintf(&b, "\x1b[%dA", extra)
+		}
+		p.lines = len(p.order)
+	}
+	_, _ = io.WriteString(p.w, b.String())
+}
+
+func bar(f *file) string {
+	if f.total <= 0 {
+		return fmt.Sprintf("[%s] %s %s/s %s", strings.Repeat("?", barWidth), config.ByteSize(f.bytes), f.rate(), f.name)
+	}
+	done := int(f.bytes * barWidth / f.total)
+	if done > barWidth {
+		done = barWidth
+	}
+	return fmt.Sprintf("[%s%s] %3d%% %s/%s %s/s %s", strings.Repeat("=", done), strings.Repeat(" ", barWidth-done),
+		f.bytes*percent/f.total, config.ByteSize(f.bytes), config.ByteSize(f.total), f.rate(), f.name)
+}
+
+func line(f *file) string {
+	if f.total <= 0 {
+		return fmt.Sprintf("%s: %s, %s/s", f.name, config.ByteSize(f.bytes), f.rate())
+	}
+	return fmt.Sprintf("%s: %d%% (%s of %s, %s/s)", f.name, f.bytes*percent/f.total, config.ByteSize(f.bytes),
+		config.ByteSize(f.total), f.rate())
+}
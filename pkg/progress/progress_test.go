@@ -0,0 +1,106 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+)
+
+// output returns what the printer wrote since the last call.
+func output(buf *bytes.Buffer) string {
+	s := buf.String()
+	buf.Reset()
+	return s
+}
+
+func TestBars(t *testing.T) {
+	var buf bytes.Buffer
+	p := &Printer{w: &buf, tty: true, interval: ttyInterval, files: make(map[string]*file)}
+
+	p.Handle(engine.Event{Type: engine.FileStarted, File: "/tv/a.mkv", Total: 1000})
+	got := output(&buf)
+	if want := "\x1b[2K[" + strings.Repeat(" ", barWidth) + "]   0% "; !strings.HasPrefix(got, want) ||
+		!strings.HasSuffix(got, " a.mkv\n") {
+		t.Errorf("first bar is %q, want it to start with %q", got, want)
+	}
+
+	p.last = time.Time{}
+	p.Handle(engine.Event{Type: engine.Progress, File: "/tv/a.mkv", Bytes: 500, Total: 1000})
+	got = output(&buf)
+	want := "\x1b[1A\x1b[2K[" + strings.Repeat("=", barWidth/2) + strings.Repeat(" ", barWidth/2) + "]  50% "
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("redrawn bar is %q, want it to start with %q", got, want)
+	}
+
+	p.Handle(engine.Event{Type: engine.Progress, File: "/tv/a.mkv", Bytes: 600, Total: 1000})
+	if got := output(&buf); got != "" {
+		t.Errorf("progress within the interval drew %q", got)
+	}
+
+	p.Handle(engine.Event{Type: engine.FileStarted, File: "/tv/b.mkv", Total: -1})
+	got = output(&buf)
+	if lines := strings.Split(got, "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], "\x1b[1A") ||
+		!strings.Contains(lines[1], "["+strings.Repeat("?", barWidth)+"] ") || !strings.HasSuffix(lines[1], " b.mkv") {
+		t.Errorf("bars of two files are %q", got)
+	}
+
+	p.Handle(engine.Event{Type: engine.FileDone, File: "/tv/a.mkv"})
+	got = output(&buf)
+	if !strings.HasPrefix(got, "\x1b[2A\x1b[2K[") || strings.Contains(got, "a.mkv") ||
+		!strings.HasSuffix(got, " b.mkv\n\x1b[2K\n\x1b[1A") {
+		t.Errorf("removing a finished bar drew %q", got)
+	}
+	if p.lines != 1 {
+		t.Errorf("printer remembers %d lines, want 1", p.lines)
+	}
+}
+
+func TestLines(t *testing.T) {
+	var buf bytes.Buffer
+	p := &Printer{w: &buf, interval: lineInterval, files: make(map[string]*file)}
+
+	p.Handle(engine.Event{Type: engine.FileStarted, File: "/tv/a.mkv", Total: 1000})
+	p.Handle(engine.Event{Type: engine.FileStarted, File: "/tv/b.mkv", Total: -1})
+	if got := output(&buf); got != "" {
+		t.Errorf("starting files printed %q", got)
+	}
+
+	p.last = time.Time{}
+	p.Handle(engine.Event{Type: engine.Progress, File: "/tv/a.mkv", Bytes: 250, Total: 1000})
+	got := output(&buf)
+	if lines := strings.Split(got, "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], "a.mkv: 25% (250B of ") ||
+		!strings.HasPrefix(lines[1], "b.mkv: 0B, ") || strings.Contains(got, "\x1b") {
+		t.Errorf("progress lines are %q", got)
+	}
+
+	p.Handle(engine.Event{Type: engine.Progress, File: "/tv/a.mkv", Bytes: 500, Total: 1000})
+	p.Handle(engine.Event{Type: engine.FileDone, File: "/tv/a.mkv"})
+	if got := output(&buf); got != "" {
+		t.Errorf("printed %q within the interval", got)
+	}
+
+	p.last = time.Time{}
+	p.Handle(engine.Event{Type: engine.Progress, File: "/tv/b.mkv", Bytes: 100, Total: -1})
+	if got := output(&buf); !strings.HasPrefix(got, "b.mkv: 100B, ") || strings.Contains(got, "a.mkv") {
+		t.Errorf("lines after a file is done are %q", got)
+	}
+}
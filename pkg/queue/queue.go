@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package queue implements the priority queue that decides the order in which files are synchronised.
+package queue
+
+import (
+	"container/heap"
+	"sync"
+)
+
+type item struct {
+	value    interface{}
+	priority int
+	seq      int
+}
+
+type items []*item
+
+func (it items) Len() int { return len(it) }
+
+func (it items) Less(i, j int) bool {
+	if it[i].priority != it[j].priority {
+		return it[i].priority > it[j].priority
+	}
+	return it[i].seq < it[j].seq
+}
+
+func (it items) Swap(i, j int) { it[i], it[j] = it[j], it[i] }
+
+func (it *items) Push(x interface{}) {
+	*it = append(*it, x.(*item))
+}
+
+func (it *items) Pop() interface{} {
+	old := *it
+	n := len(old)
+	i := old[n-1]
+	old[n-1] = nil
+	*it = old[:n-1]
+	return i
+}
+
+// Queue hands out values with the highest priority first, values with the same priority are handed out in
+// the order they were pushed. It is safe for concurrent use.
+type Queue struct {
+	mu    sync.Mutex
+	items items
+	seq   int
+}
+
+func New() *Queue {
+	return &Queue{
+		items: make(items, 0),
+	}
+}
+
+// Push adds v to the queue with the given priority.
+func (q *Queue) Push(v interface{}, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	heap.Push(&q.items, &item{value: v, priority: priority, seq: q.seq})
+	q.seq++
+}
+
+// Pop removes and returns the value with the highest priority, ok is false if the queue is empty.
+func (q *Queue) Pop() (v interface{}, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	return heap.Pop(&q.items).(*item).value, true
+}
+
+// PopFunc removes and returns the value with the highest priority that accept returns true for, ok is false if
+// there is no such value. accept is called with the queue locked.
+func (q *Queue) PopFunc(accept func(v interface{}) bool) (v interface{}, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	best := -1
+	for i, it := range q.items {
+		if best >= 0 && !q.items.Less(i, best) {
+			continue
+		}
+		if accept(it.value) {
+			best = i
+			// The first item is the one with the highest priority.
+			if i == 0 {
+				break
+			}
+		}
+	}
+	if best < 0 {
+		return nil, false
+	}
+	return heap.Remove(&q.items, best).(*item).value, true
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+)
+
+func TestPopFunc(t *testing.T) {
+	q := New()
+	for i, p := range []int{1, 3, 2, 3, 0} {
+		q.Push(i, p)
+	}
+	odd := func(v interface{}) bool { return v.(int)%2 == 1 }
+
+	var got []int
+	for {
+		v, ok := q.PopFunc(odd)
+		if !ok {
+			break
+		}
+		got = append(got, v.(int))
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("popped %v, expected [1 3]", got)
+	}
+	for _, want := range []int{2, 0, 4} {
+		if v, ok := q.Pop(); !ok || v.(int) != want {
+			t.Errorf("popped %v, expected %d", v, want)
+		}
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	const size = 1000
+
+	for i := 0; i < b.N; i++ {
+		q := New()
+		for j := 0; j < size; j++ {
+			q.Push(j, j%10)
+		}
+		for {
+			if _, ok := q.Pop(); !ok {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit implements a token bucket that can be shared between concurrent transfers.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket where every token is a byte. Transfers reserve tokens before they continue, so
+// the rate is honored across all transfers sharing the bucket. It is safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New creates a bucket allowing rate bytes per second, with a burst of one second worth of bytes.
+func New(rate int64) *Bucket {
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller has to wait before using them.
+func (b *Bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes may be transferred or ctx is done.
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	d := b.reserve(n)
+	if d == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns the largest read that doesn't exceed the burst of the bucket.
+func (b *Bucket) chunk() int {
+	return int(b.burst)
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*Bucket
+}
+
+// NewReader returns a reader that honors the rates of all given buckets, nil buckets are ignored.
+func NewReader(ctx context.Context, r io.Reader, buckets ...*Bucket) io.Reader {
+	active := make([]*Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		if b != nil {
+			active = append(active, b)
+		}
+	}
+	if len(active) == 0 {
+		return r
+	}
+
+	return &reader{ctx: ctx, r: r, buckets: active}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for _, b := range r.buckets {
+		if c := b.chunk(); c > 0 && len(p) > c {
+			p = p[:c]
+		}
+	}
+
+	n, err := r.r.Read(p)
+	for _, b := range r.buckets {
+		if werr := b.Wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedBucket(t *testing.T) {
+	const rate = 200 << 10
+	b := New(rate)
+
+	// Four transfers of half a second worth of bytes each, the burst covers the first second.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := NewReader(context.Background(), bytes.NewReader(make([]byte, rate/2)), b)
+			if n, err := io.Copy(ioutil.Discard, r); n != rate/2 || err != nil {
+				t.Errorf("copied %d bytes: %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if d := time.Since(start); d < 900*time.Millisecond || d > 3*time.Second {
+		t.Errorf("transfers took %s, expected about a second", d)
+	}
+}
+
+func TestReaderChunks(t *testing.T) {
+	r := NewReader(context.Background(), bytes.NewReader(make([]byte, 100)), New(10), nil)
+	n, err := r.Read(make([]byte, 100))
+	if n != 10 || err != nil {
+		t.Errorf("read %d bytes: %v, expected the burst of 10", n, err)
+	}
+}
+
+func TestReaderWithoutBuckets(t *testing.T) {
+	src := bytes.NewReader(nil)
+	if r := NewReader(context.Background(), src, nil); r != io.Reader(src) {
+		t.Error("reader without buckets isn't the original one")
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	b := New(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// The burst is gone, the next ten bytes take a second.
+	if err := b.Wait(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Wait(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, expected the deadline to pass", err)
+	}
+}
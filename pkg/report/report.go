@@ -18,22 +18,49 @@ limitations under the License.
 package report
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/engine"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 const (
 	EscapeChars = "\\!\"#$%&'()*+,./:;<=>?@[]^_`{|}~-"
+
+	// maxMessageLen stays a bit below the 4096 characters telegram allows per message.
+	maxMessageLen = 4000
+	// maxPages limits how many messages a report takes, the last one leaves noteLen for saying what was left out.
+	maxPages = 10
+	noteLen  = 64
 )
 
+// Reporter collects what happened during a run, it is safe for concurrent use.
 type Reporter struct {
+	mu         sync.Mutex
 	bot        *tgbotapi.BotAPI
 	chatID     int64
 	downloaded []string
+	uploaded   []string
 	errors     []error
+	// skipped and deferred hold the names of files with the reason they weren't synchronised.
+	skipped  []string
+	deferred []string
+	// lowSpace holds the reasons of files that were deferred because of low disk space, they are summarised
+	// instead of listed.
+	lowSpace []string
+	// failovers holds the listings and downloads that used another mirror because one was unavailable.
+	failovers []string
+	// stats summarises the size and speed of the downloads.
+	stats string
+	mode  engine.Mode
 }
 
 func needsEscape(r rune) bool {
@@ -41,18 +68,82 @@ func needsEscape(r rune) bool {
 }
 
 func escape(in string) string {
-	out := ""
+	var out strings.Builder
+	out.Grow(len(in))
 	for _, c := range in {
 		if needsEscape(c) {
-			out += "\\"
+			out.WriteByte('\\')
 		}
-		out += string(c)
+		out.WriteRune(c)
+	}
+	return out.String()
+}
+
+// pager splits a report into messages that fit within the telegram message limit, breaking only between lines.
+// Lines that don't fit in maxPages messages are counted instead of sent, so a large backlog can't flood the chat.
+type pager struct {
+	pages   []string
+	cur     strings.Builder
+	dropped int
+}
+
+func (p *pager) line(format string, args ...interface{}) {
+	if p.dropped > 0 {
+		p.dropped++
+		return
+	}
+	l := fmt.Sprintf(format, args...)
+	if len(l) > maxMessageLen {
+		// Cut on a rune boundary, and never behind an escaping backslash.
+		l = strings.ToValidUTF8(l[:maxMessageLen-1], "")
+		l = strings.TrimRight(l, "\\") + "\n"
+	}
+	limit := maxMessageLen
+	if len(p.pages) == maxPages-1 {
+		limit -= noteLen
+	}
+	if p.cur.Len()+len(l) > limit {
+		if len(p.pages) == maxPages-1 {
+			p.dropped++
+			return
+		}
+		p.flush()
+	}
+	p.cur.WriteString(l)
+}
+
+// finish ends the last page, with a note about the lines that were left out.
+func (p *pager) finish() {
+	if p.dropped > 0 {
+		p.cur.WriteString(escape(fmt.Sprintf("\n%d more lines were left out", p.dropped)) + "\n")
+	}
+	p.flush()
+}
+
+func (p *pager) flush() {
+	if p.cur.Len() == 0 {
+		return
+	}
+	p.pages = append(p.pages, p.cur.String())
+	p.cur.Reset()
+}
+
+// list adds a section with a title and an item per line.
+func (p *pager) list(title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	p.line("\n*%s:*\n", title)
+	for _, i := range items {
+		p.line("\\- %s\n", escape(i))
 	}
-	return out
 }
 
 func New(c *config.Configuration) (*Reporter, error) {
-	bot, err := tgbotapi.NewBotAPI(c.Telegram.Token)
+	// Telegram is reached through the same proxy as the remote.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = engine.ProxyFunc(c.HTTP.ProxyURL)
+	bot, err := tgbotapi.NewBotAPIWithClient(c.Telegram.Token, &http.Client{Transport: transport})
 	if err != nil {
 		return nil, err
 	}
@@ -61,43 +152,183 @@ func New(c *config.Configuration) (*Reporter, error) {
 		bot:        bot,
 		chatID:     c.Telegram.ChatID,
 		downloaded: make([]string, 0),
+		uploaded:   make([]string, 0),
 		errors:     make([]error, 0),
+		skipped:    make([]string, 0),
+		deferred:   make([]string, 0),
+		lowSpace:   make([]string, 0),
+		failovers:  make([]string, 0),
 	}, nil
 }
 
 func (r *Reporter) AddFile(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.downloaded = append(r.downloaded, s)
 }
 
 func (r *Reporter) AddError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.errors = append(r.errors, err)
 }
 
-func (r *Reporter) SendReport() error {
-	if len(r.downloaded) == 0 && len(r.errors) == 0 {
-		return nil
+// Reset forgets everything that has been recorded, so the reporter can be used for another run.
+func (r *Reporter) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.downloaded = make([]string, 0)
+	r.uploaded = make([]string, 0)
+	r.errors = make([]error, 0)
+	r.skipped = make([]string, 0)
+	r.deferred = make([]string, 0)
+	r.lowSpace = make([]string, 0)
+	r.failovers = make([]string, 0)
+	r.stats = ""
+	r.mode = ""
+}
+
+// fileName returns the name of f in the report, prefixed with its remote if that has a name.
+func fileName(f engine.FileResult) string {
+	if f.Remote != "" {
+		return f.Remote + ": " + path.Base(f.File)
+	}
+	return path.Base(f.File)
+}
+
+// AddResult records the outcome of a sync run.
+func (r *Reporter) AddResult(res *engine.Result) {
+	for _, f := range res.Filter(engine.Downloaded) {
+		if f.Retries > 0 {
+			r.AddFile(fmt.Sprintf("%s (retried %d times)", fileName(f), f.Retries))
+			continue
+		}
+		r.AddFile(fileName(f))
+	}
+	for _, f := range res.Filter(engine.Failed) {
+		r.AddError(f.Err)
+	}
+	if res.Err != nil {
+		r.AddError(res.Err)
 	}
 
-	m := "*Synchronisation complete*\n"
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mode = res.Mode
+	if n := len(res.Filter(engine.Downloaded)); n > 0 {
+		r.stats = fmt.Sprintf("%d files, %s, avg %s/s, %s", n, config.ByteSize(res.Bytes()),
+			config.ByteSize(res.Rate()), res.Duration().Round(time.Second))
+	}
 
-	if len(r.downloaded) > 0 {
-		m += "\n*Files downloaded:*\n"
-		for _, f := range r.downloaded {
-			m += fmt.Sprintf("\\- %s\n", escape(f))
+	r.failovers = append(r.failovers, res.Failovers...)
+	for _, f := range res.Filter(engine.Uploaded) {
+		r.uploaded = append(r.uploaded, fileName(f))
+	}
+	for _, f := range res.Filter(engine.Skipped) {
+		r.skipped = append(r.skipped, fmt.Sprintf("%s: %s", fileName(f), f.Reason))
+	}
+	for _, f := range res.Filter(engine.Deferred) {
+		if errors.Is(f.Err, engine.ErrLowSpace) {
+			r.lowSpace = append(r.lowSpace, f.Reason)
+			continue
 		}
+		r.deferred = append(r.deferred, fmt.Sprintf("%s: %s", fileName(f), f.Reason))
 	}
+}
 
-	if len(r.errors) > 0 {
-		m += "\n*Errors occurred:*\n"
-		for _, e := range r.errors {
-			m += fmt.Sprintf("\\- %s\n", escape(e.Error()))
+// errorList adds the errors grouped by their kind, errors of unknown kinds go last.
+func (r *Reporter) errorList(p *pager) {
+	groups := make(map[error][]error)
+	for _, err := range r.errors {
+		k := engine.KindOf(err)
+		groups[k] = append(groups[k], err)
+	}
+
+	kinds := make([]error, 0, len(engine.Kinds)+1)
+	kinds = append(kinds, engine.Kinds...)
+	kinds = append(kinds, nil)
+
+	for _, k := range kinds {
+		errs := groups[k]
+		if len(errs) == 0 {
+			continue
+		}
+		title := "other"
+		if k != nil {
+			title = k.Error()
+		}
+		p.line("_%s_\n", escape(title))
+		for _, e := range errs {
+			p.line("\\- %s\n", escape(e.Error()))
+		}
+	}
+}
+
+// SendReport sends the report to telegram, split over several messages if needed. It gives up when ctx is done.
+func (r *Reporter) SendReport(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, page := range r.pages() {
+		if err := r.send(ctx, page); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// pages renders the report into messages, there are none if nothing happened. r.mu has to be held.
+func (r *Reporter) pages() []string {
+	if len(r.downloaded) == 0 && len(r.uploaded) == 0 && len(r.errors) == 0 && len(r.skipped) == 0 &&
+		len(r.deferred) == 0 && len(r.lowSpace) == 0 && len(r.failovers) == 0 {
+		return nil
+	}
 
+	p := &pager{}
+	if len(r.lowSpace) > 0 {
+		p.line("*Synchronisation stopped because of low disk space*\n")
+		p.line("%s\n", escape(fmt.Sprintf("%d files were deferred: %s", len(r.lowSpace), r.lowSpace[0])))
+	} else if r.mode == engine.ModeUpload {
+		p.line("*Upload complete*\n")
+	} else {
+		p.line("*Synchronisation complete*\n")
+	}
+	if r.stats != "" {
+		p.line("%s\n", escape(r.stats))
+	}
+	p.list("Files downloaded", r.downloaded)
+	p.list("Files uploaded", r.uploaded)
+	p.list("Files skipped", r.skipped)
+	p.list("Files deferred to a later run", r.deferred)
+	p.list("Failed over to mirrors", r.failovers)
+
+	if len(r.errors) > 0 {
+		p.line("\n*Errors occurred:*\n")
+		r.errorList(p)
+	}
+	p.finish()
+	return p.pages
+}
+
+func (r *Reporter) send(ctx context.Context, m string) error {
 	msg := tgbotapi.NewMessage(r.chatID, m)
 	msg.ParseMode = "MarkdownV2"
 
-	_, err := r.bot.Send(msg)
+	// The telegram library doesn't support contexts, so we just stop waiting for it.
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.bot.Send(msg)
+		done <- err
+	}()
 
-	return err
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("couldn't send report: %w", ctx.Err())
+	}
 }
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package report
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+)
+
+// checkPages checks that every page fits in a message and ends between lines.
+func checkPages(t *testing.T, pages []string) {
+	t.Helper()
+	for i, page := range pages {
+		if len(page) > maxMessageLen || !strings.HasSuffix(page, "\n") || !utf8.ValidString(page) {
+			t.Errorf("page %d of %d bytes doesn't fit or is cut mid line", i, len(page))
+		}
+	}
+}
+
+func TestPagerSplitsPages(t *testing.T) {
+	p := &pager{}
+	var want strings.Builder
+	for i := 0; i < 200; i++ {
+		l := fmt.Sprintf("line %d %s\n", i, strings.Repeat("x", 50))
+		p.line("%s", l)
+		want.WriteString(l)
+	}
+	p.finish()
+
+	if len(p.pages) != 3 {
+		t.Errorf("got %d pages, want 3", len(p.pages))
+	}
+	checkPages(t, p.pages)
+	if got := strings.Join(p.pages, ""); got != want.String() {
+		t.Error("lines were lost or reordered between pages")
+	}
+}
+
+func TestPagerLongLine(t *testing.T) {
+	p := &pager{}
+	p.line("short\n")
+	// The cut falls in the middle of a two byte rune, and behind the backslash of an escaped dot.
+	p.line("%s\n", strings.Repeat("é", maxMessageLen/2))
+	p.line("%s%s\n", strings.Repeat("x", maxMessageLen-4), escape("...."))
+	p.finish()
+
+	if len(p.pages) != 3 || p.pages[0] != "short\n" {
+		t.Fatalf("unexpected pages: %q", p.pages)
+	}
+	checkPages(t, p.pages)
+	if strings.HasSuffix(p.pages[2], "\\\n") {
+		t.Error("cut line ends with an escaping backslash")
+	}
+}
+
+func TestPagerDeferredList(t *testing.T) {
+	var items []string
+	for i := 0; i < 150; i++ {
+		items = append(items, fmt.Sprintf("show.s01e%03d.mkv: only 2m0s old, waiting for 10m0s", i))
+	}
+	p := &pager{}
+	p.line("*Synchronisation complete*\n")
+	p.list("Files deferred to a later run", items)
+	p.finish()
+
+	if len(p.pages) < 2 {
+		t.Fatalf("got %d pages, want several", len(p.pages))
+	}
+	checkPages(t, p.pages)
+	if !strings.Contains(p.pages[0], "*Files deferred to a later run:*") {
+		t.Error("the first page doesn't have the title of the list")
+	}
+	all := strings.Join(p.pages, "")
+	if n := strings.Count(all, "\\- "); n != len(items) {
+		t.Errorf("got %d items, want %d", n, len(items))
+	}
+	if !strings.Contains(all, escape(items[len(items)-1])) {
+		t.Error("the last item is missing")
+	}
+}
+
+func TestPagerMaxPages(t *testing.T) {
+	p := &pager{}
+	items := make([]string, 5000)
+	for i := range items {
+		items[i] = fmt.Sprintf("file%d.mkv", i)
+	}
+	p.list("Files downloaded", items)
+	p.finish()
+
+	if len(p.pages) != maxPages {
+		t.Fatalf("got %d pages, want %d", len(p.pages), maxPages)
+	}
+	checkPages(t, p.pages)
+	sent := strings.Count(strings.Join(p.pages, ""), "\\- ")
+	note := fmt.Sprintf("%d more lines were left out", len(items)-sent)
+	if !strings.HasSuffix(p.pages[maxPages-1], note+"\n") {
+		t.Errorf("the last page doesn't end with %q", note)
+	}
+}
+
+func TestReportSkippedAndDeferred(t *testing.T) {
+	r := &Reporter{}
+	if pages := r.pages(); len(pages) != 0 {
+		t.Errorf("empty report has pages %q", pages)
+	}
+
+	r.AddResult(&engine.Result{Files: []engine.FileResult{
+		{File: "/tv/show/s01e01.mkv", Outcome: engine.Skipped, Reason: "excluded by the filters"},
+		{File: "/tv/show/s01e02.mkv", Outcome: engine.Deferred, Reason: "only 2m0s old",
+			Err: errors.New("only 2m0s old")},
+	}})
+	pages := r.pages()
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	for _, want := range []string{
+		"*Files skipped:*\n\\- " + escape("s01e01.mkv: excluded by the filters") + "\n",
+		"*Files deferred to a later run:*\n\\- " + escape("s01e02.mkv: only 2m0s old") + "\n",
+	} {
+		if !strings.Contains(pages[0], want) {
+			t.Errorf("report %q doesn't contain %q", pages[0], want)
+		}
+	}
+}
+
+func TestReportLowSpace(t *testing.T) {
+	r := &Reporter{}
+	var files []engine.FileResult
+	for _, name := range []string{"s01e01.mkv", "s01e02.mkv", "s01e03.mkv"} {
+		err := fmt.Errorf("free space in /media/tv would drop below 10GB: %w", engine.ErrLowSpace)
+		files = append(files, engine.FileResult{File: "/tv/show/" + name, Outcome: engine.Deferred, Err: err,
+			Reason: err.Error()})
+	}
+	r.AddResult(&engine.Result{Files: files})
+
+	pages := r.pages()
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if !strings.HasPrefix(pages[0], "*Synchronisation stopped because of low disk space*\n") {
+		t.Errorf("report doesn't start with the low space headline: %q", pages[0])
+	}
+	// The files are summarised in a single line instead of listed.
+	if !strings.Contains(pages[0], escape("3 files were deferred: free space in /media/tv")) ||
+		strings.Contains(pages[0], "s01e01") || strings.Contains(pages[0], "Files deferred") {
+		t.Errorf("deferred files aren't summarised: %q", pages[0])
+	}
+}
+
+func TestReportStats(t *testing.T) {
+	r := &Reporter{}
+	started := time.Date(2020, 3, 14, 15, 0, 0, 0, time.UTC)
+	r.AddResult(&engine.Result{
+		Started:  started,
+		Finished: started.Add(3 * time.Second),
+		Files: []engine.FileResult{
+			{File: "/tv/show/s01e01.mkv", Outcome: engine.Downloaded, Bytes: 2e6, Transferred: 2e6},
+			// A resumed download only counts the bytes of this run for the speed.
+			{File: "/tv/show/s01e02.mkv", Outcome: engine.Downloaded, Bytes: 2e6, Transferred: 1e6},
+			{File: "/tv/show/s01e03.mkv", Outcome: engine.Failed, Bytes: 5e6, Err: errors.New("timeout")},
+		},
+	})
+
+	pages := r.pages()
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if want := escape("2 files, 4.0MB, avg 1.0MB/s, 3s") + "\n"; !strings.Contains(pages[0], want) {
+		t.Errorf("report %q doesn't contain the statistics %q", pages[0], want)
+	}
+}
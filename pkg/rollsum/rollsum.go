@@ -0,0 +1,51 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rollsum implements the weak rolling checksum of rsync, which can be moved over data a byte at a time to
+// find blocks that moved to another offset.
+package rollsum
+
+// Rollsum is the checksum of a window of data.
+type Rollsum struct {
+	a, b uint32
+	n    uint32
+}
+
+// New returns the checksum of window, which sets the size of the window for rolling.
+func New(window []byte) *Rollsum {
+	r := &Rollsum{n: uint32(len(window))}
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+	return r
+}
+
+// Roll moves the window a byte further, out is the byte that leaves the window and in the one that enters it.
+func (r *Rollsum) Roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+// Sum returns the checksum of the window.
+func (r *Rollsum) Sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// Checksum returns the checksum of b.
+func Checksum(b []byte) uint32 {
+	return New(b).Sum()
+}
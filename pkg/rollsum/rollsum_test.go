@@ -0,0 +1,36 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollsum
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRoll(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	const window = 512
+	r := New(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		r.Roll(data[i-1], data[i+window-1])
+		if got, want := r.Sum(), Checksum(data[i:i+window]); got != want {
+			t.Fatalf("offset %d: rolled checksum %08x, expected %08x", i, got, want)
+		}
+	}
+}
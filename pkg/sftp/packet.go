@@ -0,0 +1,172 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var errShort = errors.New("sftp: short packet")
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:4])
+	if n < 1 || n > maxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", n)
+	}
+	data := make([]byte, n-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return head[4], data, nil
+}
+
+func writePacket(w io.Writer, typ byte, payload []byte) error {
+	b := putUint32(make([]byte, 0, 5+len(payload)), uint32(len(payload)+1))
+	b = append(b, typ)
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+func putUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func putUint64(b []byte, v uint64) []byte {
+	return putUint32(putUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func putString(b []byte, s string) []byte {
+	return append(putUint32(b, uint32(len(s))), s...)
+}
+
+func getUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errShort
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func getUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errShort
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+func getString(b []byte) (string, []byte, error) {
+	n, b, err := getUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(b)) < n {
+		return "", nil, errShort
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+// Mode bits of the file types, as used by the protocol.
+const (
+	modeType    = 0170000
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+)
+
+func getAttrs(b []byte) (FileInfo, []byte, error) {
+	var fi FileInfo
+	flags, b, err := getUint32(b)
+	if err != nil {
+		return fi, nil, err
+	}
+	if flags&attrSize != 0 {
+		var size uint64
+		if size, b, err = getUint64(b); err != nil {
+			return fi, nil, err
+		}
+		fi.Size = int64(size)
+	}
+	if flags&attrUIDGID != 0 {
+		if len(b) < 8 {
+			return fi, nil, errShort
+		}
+		b = b[8:]
+	}
+	if flags&attrPermissions != 0 {
+		var perm uint32
+		if perm, b, err = getUint32(b); err != nil {
+			return fi, nil, err
+		}
+		fi.Mode = os.FileMode(perm & 0777)
+		switch perm & modeType {
+		case modeDir:
+			fi.Mode |= os.ModeDir
+		case modeSymlink:
+			fi.Mode |= os.ModeSymlink
+		case modeRegular:
+		default:
+			fi.Mode |= os.ModeIrregular
+		}
+	}
+	if flags&attrACModTime != 0 {
+		var mtime uint32
+		if len(b) < 8 {
+			return fi, nil, errShort
+		}
+		if mtime, b, err = getUint32(b[4:]); err != nil {
+			return fi, nil, err
+		}
+		fi.ModTime = time.Unix(int64(mtime), 0)
+	}
+	if flags&attrExtended != 0 {
+		var n uint32
+		if n, b, err = getUint32(b); err != nil {
+			return fi, nil, err
+		}
+		for i := uint32(0); i < 2*n; i++ {
+			if _, b, err = getString(b); err != nil {
+				return fi, nil, err
+			}
+		}
+	}
+	return fi, b, nil
+}
+
+func putAttrs(b []byte, fi os.FileInfo) []byte {
+	perm := uint32(fi.Mode().Perm())
+	switch {
+	case fi.IsDir():
+		perm |= modeDir
+	case fi.Mode()&os.ModeSymlink != 0:
+		perm |= modeSymlink
+	case fi.Mode().IsRegular():
+		perm |= modeRegular
+	}
+	b = putUint32(b, attrSize|attrPermissions|attrACModTime)
+	b = putUint64(b, uint64(fi.Size()))
+	b = putUint32(b, perm)
+	b = putUint32(b, uint32(fi.ModTime().Unix()))
+	return putUint32(b, uint32(fi.ModTime().Unix()))
+}
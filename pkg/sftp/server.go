@@ -0,0 +1,273 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// server is a minimal SFTP server, enough to test clients against.
+type server struct {
+	root string
+	w    io.Writer
+
+	mu      sync.Mutex
+	handles map[string]interface{}
+	next    int
+}
+
+// dirHandle is an open directory, its entries are sent in a single READDIR response.
+type dirHandle struct {
+	dir  string
+	done bool
+}
+
+// Serve serves the files below root over r and w until r is closed. It only supports reading and removing files,
+// it is meant for tests.
+func Serve(root string, r io.Reader, w io.Writer) error {
+	s := &server{root: root, w: w, handles: make(map[string]interface{})}
+
+	typ, _, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != fxpInit {
+		return fmt.Errorf("sftp: expected init, got packet %d", typ)
+	}
+	if err := writePacket(w, fxpVersion, putUint32(nil, protocolVersion)); err != nil {
+		return err
+	}
+
+	for {
+		typ, data, err := readPacket(r)
+		if errors.Is(err, io.EOF) {
+			return s.closeAll()
+		}
+		if err != nil {
+			return err
+		}
+		id, data, err := getUint32(data)
+		if err != nil {
+			return err
+		}
+		if err := s.handle(typ, id, data); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) closeAll() error {
+	for _, h := range s.handles {
+		if f, ok := h.(*os.File); ok {
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// local returns the local path of p, the root can't be escaped.
+func (s *server) local(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (s *server) handle(typ byte, id uint32, data []byte) error {
+	switch typ {
+	case fxpOpen, fxpOpendir, fxpStat, fxpLstat, fxpRemove, fxpRealpath:
+		p, rest, err := getString(data)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.pathRequest(typ, id, p, rest)
+	case fxpRead, fxpReaddir, fxpFstat, fxpClose:
+		h, rest, err := getString(data)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.handleRequest(typ, id, h, rest)
+	default:
+		return s.reply(fxpStatus, id, statusPayload(fxOpUnsupported, "unsupported"))
+	}
+}
+
+func (s *server) pathRequest(typ byte, id uint32, p string, rest []byte) error {
+	local := s.local(p)
+	switch typ {
+	case fxpOpen:
+		flags, _, err := getUint32(rest)
+		if err != nil || flags != openRead {
+			return s.reply(fxpStatus, id, statusPayload(fxOpUnsupported, "only reading is supported"))
+		}
+		f, err := os.Open(local)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.reply(fxpHandle, id, putString(nil, s.add(f)))
+	case fxpOpendir:
+		fi, err := os.Stat(local)
+		if err == nil && !fi.IsDir() {
+			err = fmt.Errorf("not a directory")
+		}
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.reply(fxpHandle, id, putString(nil, s.add(&dirHandle{dir: local})))
+	case fxpStat, fxpLstat:
+		stat := os.Stat
+		if typ == fxpLstat {
+			stat = os.Lstat
+		}
+		fi, err := stat(local)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.reply(fxpAttrs, id, putAttrs(nil, fi))
+	case fxpRemove:
+		fi, err := os.Lstat(local)
+		if err == nil && fi.IsDir() {
+			err = fmt.Errorf("is a directory")
+		}
+		if err == nil {
+			err = os.Remove(local)
+		}
+		return s.status(id, err)
+	default: // fxpRealpath
+		fi, err := os.Stat(local)
+		if err != nil {
+			return s.status(id, err)
+		}
+		b := putUint32(nil, 1)
+		b = putString(b, path.Clean("/"+p))
+		b = putString(b, "")
+		return s.reply(fxpName, id, putAttrs(b, fi))
+	}
+}
+
+func (s *server) handleRequest(typ byte, id uint32, h string, rest []byte) error {
+	s.mu.Lock()
+	v, ok := s.handles[h]
+	s.mu.Unlock()
+	if !ok {
+		return s.reply(fxpStatus, id, statusPayload(fxFailure, "invalid handle"))
+	}
+
+	switch typ {
+	case fxpClose:
+		s.mu.Lock()
+		delete(s.handles, h)
+		s.mu.Unlock()
+		if f, ok := v.(*os.File); ok {
+			return s.status(id, f.Close())
+		}
+		return s.status(id, nil)
+	case fxpRead:
+		f, ok := v.(*os.File)
+		if !ok {
+			return s.reply(fxpStatus, id, statusPayload(fxFailure, "not a file"))
+		}
+		off, rest, err := getUint64(rest)
+		if err != nil {
+			return s.status(id, err)
+		}
+		n, _, err := getUint32(rest)
+		if err != nil {
+			return s.status(id, err)
+		}
+		if n > readSize {
+			n = readSize
+		}
+		buf := make([]byte, n)
+		read, err := f.ReadAt(buf, int64(off))
+		if read == 0 && err != nil {
+			return s.status(id, err)
+		}
+		return s.reply(fxpData, id, putString(nil, string(buf[:read])))
+	case fxpFstat:
+		f, ok := v.(*os.File)
+		if !ok {
+			return s.reply(fxpStatus, id, statusPayload(fxFailure, "not a file"))
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.reply(fxpAttrs, id, putAttrs(nil, fi))
+	default: // fxpReaddir
+		d, ok := v.(*dirHandle)
+		if !ok {
+			return s.reply(fxpStatus, id, statusPayload(fxFailure, "not a directory"))
+		}
+		if d.done {
+			return s.status(id, io.EOF)
+		}
+		d.done = true
+		entries, err := ioutil.ReadDir(d.dir)
+		if err != nil {
+			return s.status(id, err)
+		}
+		b := putUint32(nil, uint32(len(entries)))
+		for _, fi := range entries {
+			b = putString(b, fi.Name())
+			b = putString(b, fi.Name())
+			b = putAttrs(b, fi)
+		}
+		return s.reply(fxpName, id, b)
+	}
+}
+
+func (s *server) add(v interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	h := strconv.Itoa(s.next)
+	s.handles[h] = v
+	return h
+}
+
+func (s *server) reply(typ byte, id uint32, payload []byte) error {
+	return writePacket(s.w, typ, append(putUint32(nil, id), payload...))
+}
+
+// status replies with the status for err.
+func (s *server) status(id uint32, err error) error {
+	switch {
+	case err == nil:
+		return s.reply(fxpStatus, id, statusPayload(fxOK, "ok"))
+	case errors.Is(err, io.EOF):
+		return s.reply(fxpStatus, id, statusPayload(fxEOF, "end of file"))
+	case os.IsNotExist(err):
+		return s.reply(fxpStatus, id, statusPayload(fxNoSuchFile, "no such file"))
+	case os.IsPermission(err):
+		return s.reply(fxpStatus, id, statusPayload(fxPermissionDenied, "permission denied"))
+	default:
+		return s.reply(fxpStatus, id, statusPayload(fxFailure, err.Error()))
+	}
+}
+
+func statusPayload(code uint32, msg string) []byte {
+	b := putUint32(nil, code)
+	b = putString(b, msg)
+	return putString(b, "")
+}
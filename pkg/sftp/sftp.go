@@ -0,0 +1,524 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sftp implements the client side of the parts of the SFTP protocol (version 3) needed to synchronise files
+// from a server: listing directories, reading and removing files. It speaks the protocol over any pipe, usually the
+// sftp subsystem of an ssh process. A minimal server is included for tests.
+package sftp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Packet types.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// Status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxOpUnsupported    = 8
+)
+
+// Attribute flags.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+)
+
+const (
+	protocolVersion = 3
+	// maxPacket is the largest packet that is accepted, servers must support packets of at least 34000 bytes.
+	maxPacket = 256 * 1024
+	// readSize is the amount of bytes requested per read, readAhead the amount of reads kept in flight.
+	readSize  = 32 * 1024
+	readAhead = 16
+	// openRead is the pflag to open a file for reading.
+	openRead = 0x1
+)
+
+// ErrClosed is returned for requests on a client whose connection is gone.
+var ErrClosed = errors.New("sftp connection closed")
+
+// StatusError is an error status the server responded with.
+type StatusError struct {
+	Code uint32
+	Msg  string
+}
+
+func (s *StatusError) Error() string {
+	return fmt.Sprintf("sftp: %s (status %d)", s.Msg, s.Code)
+}
+
+// Is makes the status codes for missing files and denied permissions match the errors of the os package.
+func (s *StatusError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return s.Code == fxNoSuchFile
+	case os.ErrPermission:
+		return s.Code == fxPermissionDenied
+	}
+	return false
+}
+
+// FileInfo describes a file on the server.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// response is a packet from the server.
+type response struct {
+	typ  byte
+	data []byte
+}
+
+// request is a request in flight, its response arrives on ch.
+type request struct {
+	id uint32
+	ch <-chan response
+}
+
+// Client is an SFTP client, it is safe for concurrent use. A request that is cancelled through its context is
+// abandoned, the other requests of the session aren't affected.
+type Client struct {
+	w io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan response
+	err     error
+	wmu     sync.Mutex
+	done    chan struct{}
+}
+
+// NewClient starts an SFTP session over r and w, the output and input of the server. Closing the client closes w.
+func NewClient(r io.Reader, w io.WriteCloser) (*Client, error) {
+	c := &Client{w: w, pending: make(map[uint32]chan response), done: make(chan struct{})}
+
+	if err := writePacket(w, fxpInit, putUint32(nil, protocolVersion)); err != nil {
+		return nil, fmt.Errorf("couldn't start sftp session: %w", err)
+	}
+	typ, data, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start sftp session: %w", err)
+	}
+	if typ != fxpVersion {
+		return nil, fmt.Errorf("couldn't start sftp session: unexpected packet %d", typ)
+	}
+	if v, _, err := getUint32(data); err != nil || v < protocolVersion {
+		return nil, fmt.Errorf("couldn't start sftp session: unsupported version")
+	}
+
+	go c.readLoop(r)
+	return c, nil
+}
+
+// readLoop hands the responses of the server to the requests waiting for them, until the connection fails.
+func (c *Client) readLoop(r io.Reader) {
+	for {
+		typ, data, err := readPacket(r)
+		if err == nil && len(data) < 4 {
+			err = fmt.Errorf("short packet")
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(data)
+
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- response{typ: typ, data: data[4:]}
+		}
+	}
+}
+
+// fail closes the client because of err, all waiting requests fail with it.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	if err == io.EOF {
+		err = ErrClosed
+	}
+	c.err = err
+	close(c.done)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Err returns why the connection is gone, nil while it is usable.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Done is closed once the connection is gone.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close ends the session.
+func (c *Client) Close() error {
+	err := c.w.Close()
+	c.fail(ErrClosed)
+	return err
+}
+
+// send sends a request, the channel its response arrives on is closed if the connection fails first.
+func (c *Client) send(typ byte, payload []byte) (request, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return request{}, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := writePacket(c.w, typ, append(putUint32(nil, id), payload...))
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+		return request{}, err
+	}
+	return request{id: id, ch: ch}, nil
+}
+
+// wait waits for the response to req, the request is abandoned if ctx is done first and its response dropped
+// when it arrives.
+func (c *Client) wait(ctx context.Context, req request) (response, error) {
+	select {
+	case r, ok := <-req.ch:
+		if !ok {
+			return response{}, c.Err()
+		}
+		return r, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, req.id)
+		c.mu.Unlock()
+		return response{}, ctx.Err()
+	}
+}
+
+func (c *Client) call(ctx context.Context, typ byte, payload []byte) (response, error) {
+	if err := ctx.Err(); err != nil {
+		return response{}, err
+	}
+	req, err := c.send(typ, payload)
+	if err != nil {
+		return response{}, err
+	}
+	return c.wait(ctx, req)
+}
+
+// status turns a status response into an error, nil for the OK status.
+func status(r response) error {
+	if r.typ != fxpStatus {
+		return fmt.Errorf("sftp: unexpected packet %d", r.typ)
+	}
+	code, rest, err := getUint32(r.data)
+	if err != nil {
+		return err
+	}
+	if code == fxOK {
+		return nil
+	}
+	if code == fxEOF {
+		return io.EOF
+	}
+	msg, _, _ := getString(rest)
+	return &StatusError{Code: code, Msg: msg}
+}
+
+// handle requests a handle with typ, for opening files and directories.
+func (c *Client) handle(ctx context.Context, typ byte, payload []byte) (string, error) {
+	r, err := c.call(ctx, typ, payload)
+	if err != nil {
+		return "", err
+	}
+	if r.typ != fxpHandle {
+		return "", status(r)
+	}
+	h, _, err := getString(r.data)
+	return h, err
+}
+
+// closeHandle closes h, the handle is still closed by the server if ctx is done already.
+func (c *Client) closeHandle(ctx context.Context, h string) error {
+	req, err := c.send(fxpClose, putString(nil, h))
+	if err != nil {
+		return err
+	}
+	r, err := c.wait(ctx, req)
+	if err != nil {
+		return err
+	}
+	return status(r)
+}
+
+// Stat returns information about the file at p, following symbolic links.
+func (c *Client) Stat(ctx context.Context, p string) (FileInfo, error) {
+	r, err := c.call(ctx, fxpStat, putString(nil, p))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if r.typ != fxpAttrs {
+		return FileInfo{}, status(r)
+	}
+	fi, _, err := getAttrs(r.data)
+	fi.Name = baseName(p)
+	return fi, err
+}
+
+// ReadDir returns the entries of the directory at p, without . and .., with the attributes of the entries
+// themselves rather than of what symbolic links point to.
+func (c *Client) ReadDir(ctx context.Context, p string) ([]FileInfo, error) {
+	h, err := c.handle(ctx, fxpOpendir, putString(nil, p))
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(ctx, h) //nolint:errcheck // Nothing to do about it.
+
+	var entries []FileInfo
+	for {
+		r, err := c.call(ctx, fxpReaddir, putString(nil, h))
+		if err != nil {
+			return nil, err
+		}
+		if r.typ != fxpName {
+			if err := status(r); err != io.EOF {
+				return nil, err
+			}
+			return entries, nil
+		}
+
+		n, data, err := getUint32(r.data)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < n; i++ {
+			var name string
+			if name, data, err = getString(data); err != nil {
+				return nil, err
+			}
+			// The long name is meant for humans.
+			if _, data, err = getString(data); err != nil {
+				return nil, err
+			}
+			var fi FileInfo
+			if fi, data, err = getAttrs(data); err != nil {
+				return nil, err
+			}
+			if name == "." || name == ".." {
+				continue
+			}
+			fi.Name = name
+			entries = append(entries, fi)
+		}
+	}
+}
+
+// Remove removes the file at p.
+func (c *Client) Remove(ctx context.Context, p string) error {
+	r, err := c.call(ctx, fxpRemove, putString(nil, p))
+	if err != nil {
+		return err
+	}
+	return status(r)
+}
+
+// Open opens the file at p for reading, the requests for the file are cancelled when ctx is done.
+func (c *Client) Open(ctx context.Context, p string) (*File, error) {
+	payload := putString(nil, p)
+	payload = putUint32(payload, openRead)
+	payload = putUint32(payload, 0)
+	h, err := c.handle(ctx, fxpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &File{c: c, ctx: ctx, handle: h}, nil
+}
+
+// File is a file opened for reading, reads are done ahead so the latency of the connection doesn't limit the
+// throughput. It isn't safe for concurrent use.
+type File struct {
+	c      *Client
+	ctx    context.Context
+	handle string
+	// offset is the offset of the next read request, reads are in flight from the offset of the first one.
+	offset int64
+	reads  []read
+	buf    []byte
+	eof    bool
+	err    error
+}
+
+type read struct {
+	offset int64
+	req    request
+}
+
+// Stat returns information about the open file.
+func (f *File) Stat() (FileInfo, error) {
+	r, err := f.c.call(f.ctx, fxpFstat, putString(nil, f.handle))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if r.typ != fxpAttrs {
+		return FileInfo{}, status(r)
+	}
+	fi, _, err := getAttrs(r.data)
+	return fi, err
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		f.fill()
+		if len(f.reads) == 0 {
+			f.err = io.EOF
+			continue
+		}
+		f.next()
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// fill sends read requests until the read ahead window is full.
+func (f *File) fill() {
+	for !f.eof && f.err == nil && len(f.reads) < readAhead {
+		payload := putString(nil, f.handle)
+		payload = putUint64(payload, uint64(f.offset))
+		payload = putUint32(payload, readSize)
+		req, err := f.c.send(fxpRead, payload)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.reads = append(f.reads, read{offset: f.offset, req: req})
+		f.offset += readSize
+	}
+}
+
+// next waits for the first read in flight and buffers its data.
+func (f *File) next() {
+	rd := f.reads[0]
+	f.reads = f.reads[1:]
+	r, err := f.c.wait(f.ctx, rd.req)
+	if err != nil {
+		f.err = err
+		return
+	}
+	if r.typ != fxpData {
+		if err := status(r); err != io.EOF {
+			f.err = err
+			if f.err == nil {
+				f.err = fmt.Errorf("sftp: unexpected status for read")
+			}
+			return
+		}
+		f.eof = true
+		f.drain()
+		return
+	}
+	data, _, err := getString(r.data)
+	if err != nil {
+		f.err = err
+		return
+	}
+	f.buf = []byte(data)
+	if len(data) < readSize {
+		// A short read, the reads in flight are for the wrong offsets.
+		f.drain()
+		f.offset = rd.offset + int64(len(data))
+	}
+}
+
+// drain waits for the reads in flight and drops them.
+func (f *File) drain() {
+	for _, rd := range f.reads {
+		_, _ = f.c.wait(f.ctx, rd.req)
+	}
+	f.reads = nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.drain()
+	return f.c.closeHandle(f.ctx, f.handle)
+}
+
+func baseName(p string) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] == '/' {
+			return p[i+1:]
+		}
+	}
+	return p
+}
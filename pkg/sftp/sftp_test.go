@@ -0,0 +1,184 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// connect starts a server for root and returns a client connected to it over pipes.
+func connect(t *testing.T, root string) *Client {
+	t.Helper()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(root, sr, sw)
+		sw.Close()
+	}()
+
+	c, err := NewClient(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return c
+}
+
+func TestClient(t *testing.T) {
+	root := t.TempDir()
+	// Large enough to need several reads, and not a multiple of the read size.
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "file"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "other"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := connect(t, root)
+	ctx := context.Background()
+
+	entries, err := c.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name)
+		if fi.Name == "dir" && !fi.Mode.IsDir() {
+			t.Errorf("dir has mode %v", fi.Mode)
+		}
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "dir" || names[1] != "other" {
+		t.Errorf("entries = %v", names)
+	}
+
+	fi, err := c.Stat(ctx, "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size != int64(len(content)) || !fi.Mode.IsRegular() || fi.ModTime.IsZero() {
+		t.Errorf("stat = %+v", fi)
+	}
+
+	f, err := c.Open(ctx, "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("read %d bytes, want %d", len(got), len(content))
+	}
+
+	if err := c.Remove(ctx, "/other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stat(ctx, "/other"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of removed file: %v", err)
+	}
+	if _, err := c.Open(ctx, "/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("open of missing file: %v", err)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		// Answer the handshake and hang up.
+		readPacket(sr)
+		writePacket(sw, fxpVersion, putUint32(nil, protocolVersion))
+		readPacket(sr)
+		sw.Close()
+	}()
+
+	c, err := NewClient(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stat(context.Background(), "/"); !errors.Is(err, ErrClosed) {
+		t.Errorf("stat on closed connection: %v", err)
+	}
+	if !errors.Is(c.Err(), ErrClosed) {
+		t.Errorf("Err() = %v", c.Err())
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		readPacket(sr)
+		writePacket(sw, fxpVersion, putUint32(nil, protocolVersion))
+		// Requests for /hang are never answered.
+		for {
+			_, data, err := readPacket(sr)
+			if err != nil {
+				sw.Close()
+				return
+			}
+			id, rest, _ := getUint32(data)
+			if p, _, _ := getString(rest); p != "/hang" {
+				writePacket(sw, fxpAttrs, putUint32(putUint32(nil, id), 0))
+			}
+		}
+	}()
+
+	c, err := NewClient(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Stat(ctx, "/hang"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stat of hanging request: %v", err)
+	}
+	if _, err := c.Stat(context.Background(), "/other"); err != nil {
+		t.Errorf("stat after cancelled request: %v", err)
+	}
+	if c.Err() != nil {
+		t.Errorf("Err() = %v", c.Err())
+	}
+}
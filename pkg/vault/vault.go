@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault reads secrets from the KV version 2 secrets engine of HashiCorp Vault, logging in with a token or
+// an AppRole.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNotFound is returned for secrets that don't exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Client talks to the HTTP API of a Vault server.
+type Client struct {
+	addr      string
+	namespace string
+	token     string
+	hc        *http.Client
+}
+
+// New returns a client for the Vault server at addr, like https://vault.example.org:8200. namespace is only
+// needed for Vault Enterprise namespaces.
+func New(addr, namespace string, hc *http.Client) *Client {
+	return &Client{addr: strings.TrimSuffix(addr, "/"), namespace: namespace, hc: hc}
+}
+
+// SetToken makes the client use token for its requests.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// LoginAppRole logs in with the AppRole auth method mounted at mount, and uses the token it gets from then on.
+func (c *Client) LoginAppRole(ctx context.Context, mount, roleID, secretID string) error {
+	body, err := json.Marshal(map[string]string{"role_id": roleID, "secret_id": secretID})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := c.do(ctx, http.MethodPost, "auth/"+mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("couldn't log in with AppRole: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return errors.New("couldn't log in with AppRole: no token in the response")
+	}
+	c.token = resp.Auth.ClientToken
+	return nil
+}
+
+// ReadKV returns the fields of the latest version of the secret at path in the KV version 2 engine mounted at
+// mount.
+func (c *Client) ReadKV(ctx context.Context, mount, path string) (map[string]interface{}, error) {
+	var resp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	p := strings.Trim(mount, "/") + "/data/" + strings.TrimPrefix(path, "/")
+	if err := c.do(ctx, http.MethodGet, p, nil, &resp); err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", p, err)
+	}
+	if resp.Data.Data == nil {
+		// Deleted versions are returned without data.
+		return nil, fmt.Errorf("couldn't read %s: %w", p, ErrNotFound)
+	}
+	return resp.Data.Data, nil
+}
+
+// do sends a request to the API path p and decodes the response into v.
+func (c *Client) do(ctx context.Context, method, p string, body []byte, v interface{}) error {
+	u, err := url.Parse(c.addr + "/v1/" + p)
+	if err != nil {
+		return err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		if len(e.Errors) > 0 {
+			return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(e.Errors, ", "))
+		}
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("couldn't decode the response: %w", err)
+	}
+	return nil
+}
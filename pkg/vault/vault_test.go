@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves an AppRole login for role/secret and the secret mediasync in the KV engine at secret/.
+func fakeVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			if body["role_id"] != "role" || body["secret_id"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors": ["invalid role or secret ID"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth": {"client_token": "approle-token"}}`))
+		case "/v1/secret/data/mediasync":
+			if r.Header.Get("X-Vault-Namespace") != "ns" {
+				t.Errorf("got namespace %q, want ns", r.Header.Get("X-Vault-Namespace"))
+			}
+			if tok := r.Header.Get("X-Vault-Token"); tok != "approle-token" && tok != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "pass"}, "metadata": {"version": 3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+}
+
+func TestReadKV(t *testing.T) {
+	srv := fakeVault(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := New(srv.URL+"/", "ns", srv.Client())
+	if err := c.LoginAppRole(ctx, "approle", "role", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.ReadKV(ctx, "secret", "/mediasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["password"] != "pass" {
+		t.Errorf("got %v, want the password", data)
+	}
+	if _, err := c.ReadKV(ctx, "secret", "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reading a missing secret gave %v, want %v", err, ErrNotFound)
+	}
+
+	c = New(srv.URL, "ns", srv.Client())
+	c.SetToken("token")
+	if _, err := c.ReadKV(ctx, "secret", "mediasync"); err != nil {
+		t.Errorf("reading with a token: %v", err)
+	}
+	c.SetToken("wrong")
+	if _, err := c.ReadKV(ctx, "secret", "mediasync"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("reading with a wrong token gave %v, want permission denied", err)
+	}
+	if err := c.LoginAppRole(ctx, "approle", "role", "wrong"); err == nil {
+		t.Error("logging in with a wrong secret ID succeeded")
+	}
+}
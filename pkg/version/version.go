@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version describes the build of the client. The version, commit and build date are set when building,
+// with:
+//
+//	go build -ldflags "-X github.com/ainmosni/mediasync-client/pkg/version.Version=v1.2.3 \
+//		-X github.com/ainmosni/mediasync-client/pkg/version.Commit=$(git rev-parse HEAD) \
+//		-X github.com/ainmosni/mediasync-client/pkg/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X when building, they are empty otherwise.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the build of the client.
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+	Platform  string
+}
+
+// Get returns the build info. Without a version from the linker it falls back on the version of the module,
+// which is known for binaries installed with go install, and on "dev" for other builds.
+func Get() Info {
+	i := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if i.Version == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			i.Version = bi.Main.Version
+		}
+	}
+	if i.Version == "" {
+		i.Version = "dev"
+	}
+	return i
+}
+
+// String describes the build on a single line, leaving out what isn't known.
+func (i Info) String() string {
+	s := "mediasync-client " + i.Version
+	if i.Commit != "" {
+		s += fmt.Sprintf(", commit %s", i.Commit)
+	}
+	if i.Date != "" {
+		s += fmt.Sprintf(", built %s", i.Date)
+	}
+	return fmt.Sprintf("%s (%s, %s)", s, i.GoVersion, i.Platform)
+}
+
+// UserAgent returns the User-Agent the client sends, so server operators can tell which versions connect.
+func UserAgent() string {
+	return "mediasync-client/" + Get().Version
+}
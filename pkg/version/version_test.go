@@ -0,0 +1,43 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+
+	Version, Commit, Date = "", "", ""
+	if got := Get().Version; got != "dev" {
+		t.Errorf("expected dev without a version from the linker, got %q", got)
+	}
+	if got := Get().String(); strings.Contains(got, "commit") || strings.Contains(got, "built") {
+		t.Errorf("unexpected description of an unknown build: %q", got)
+	}
+
+	Version, Commit, Date = "v1.2.3", "abc123", "2020-06-01T12:00:00Z"
+	if got := UserAgent(); got != "mediasync-client/v1.2.3" {
+		t.Errorf("unexpected user agent %q", got)
+	}
+	want := "mediasync-client v1.2.3, commit abc123, built 2020-06-01T12:00:00Z ("
+	if got := Get().String(); !strings.HasPrefix(got, want) {
+		t.Errorf("unexpected description %q", got)
+	}
+}
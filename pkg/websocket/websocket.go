@@ -0,0 +1,281 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package websocket implements the parts of the WebSocket protocol (RFC 6455) the client needs to receive
+// notifications: the handshake, text and binary messages, pings and closing. The server side is only there for tests.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // Required by the protocol, not used for security.
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MaxMessageSize is the size of the largest message that is accepted.
+const MaxMessageSize = 1 << 20
+
+// acceptGUID is appended to the key of the handshake to compute the accept header.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of the frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// ErrClosed is returned by Read when the other side closed the connection.
+var ErrClosed = errors.New("websocket closed")
+
+// Conn is a WebSocket connection. Read may only be called from a single goroutine, Write and Close from any.
+type Conn struct {
+	r  *bufio.Reader
+	rw io.ReadWriteCloser
+	// client connections mask the frames they send.
+	client bool
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Dial opens a WebSocket connection with a GET request to the http or https URL of req, through client so the
+// transport settings of client apply.
+func Dial(ctx context.Context, client *http.Client, req *http.Request) (*Conn, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	nonce := base64.StdEncoding.EncodeToString(key)
+
+	req = req.WithContext(ctx)
+	req.Method = http.MethodGet
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", nonce)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, &HandshakeError{StatusCode: resp.StatusCode}
+	}
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("transport doesn't support protocol upgrades")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != accept(nonce) {
+		rw.Close()
+		return nil, fmt.Errorf("invalid handshake response")
+	}
+	return &Conn{r: bufio.NewReader(rw), rw: rw, client: true}, nil
+}
+
+// HandshakeError is returned by Dial when the server didn't switch protocols.
+type HandshakeError struct {
+	StatusCode int
+}
+
+func (h *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed: unexpected status %d %s", h.StatusCode,
+		http.StatusText(h.StatusCode))
+}
+
+// Accept completes the handshake of a WebSocket request on the server side.
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack connection", http.StatusInternalServerError)
+		return nil, fmt.Errorf("can't hijack connection")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", accept(r.Header.Get("Sec-WebSocket-Key")))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{r: brw.Reader, rw: conn}, nil
+}
+
+func accept(key string) string {
+	h := sha1.New() //nolint:gosec // Required by the protocol.
+	_, _ = io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Read returns the next text or binary message, answering pings on the way. It returns ErrClosed when the other
+// side closed the connection.
+func (c *Conn) Read() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.Close()
+			return nil, ErrClosed
+		case opText, opBinary, opContinuation:
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %d", op)
+		}
+
+		if len(msg)+len(payload) > MaxMessageSize {
+			return nil, fmt.Errorf("websocket message larger than %d bytes", MaxMessageSize)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, fmt.Errorf("websocket frame larger than %d bytes", MaxMessageSize)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// Write sends msg as a text message.
+func (c *Conn) Write(msg []byte) error {
+	return c.writeFrame(opText, msg)
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	frame := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(n))
+	default:
+		frame[1] = 127
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(n))
+	}
+
+	if !c.client {
+		_, err := c.rw.Write(append(frame, payload...))
+		return err
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame[1] |= 0x80
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// Close sends a close frame and closes the connection, without waiting for the other side to answer.
+func (c *Conn) Close() error {
+	_ = c.writeFrame(opClose, nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rw.Close()
+}
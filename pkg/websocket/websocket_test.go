@@ -0,0 +1,96 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	messages := [][]byte{[]byte("new file"), bytes.Repeat([]byte("x"), 70000), {}}
+	replies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.writeFrame(opPing, []byte("ping")); err != nil {
+			t.Error(err)
+		}
+		for _, m := range messages {
+			if err := conn.Write(m); err != nil {
+				t.Error(err)
+			}
+		}
+		// The pong to the ping is read along with the reply, which checks the masking of the client.
+		reply, err := conn.Read()
+		if err != nil {
+			t.Error(err)
+		}
+		replies <- reply
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Dial(context.Background(), srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, want := range messages {
+		got, err := conn.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("read %d bytes, expected %d", len(got), len(want))
+		}
+	}
+	if err := conn.Write([]byte("thanks")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-replies; string(got) != "thanks" {
+		t.Errorf("server read %q", got)
+	}
+	if _, err := conn.Read(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var herr *HandshakeError
+	if _, err := Dial(context.Background(), srv.Client(), req); !errors.As(err, &herr) || herr.StatusCode != 404 {
+		t.Errorf("expected a handshake error, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Daniël Franke
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/ainmosni/mediasync-client/pkg/config"
+	"github.com/ainmosni/mediasync-client/pkg/engine"
+	"github.com/ainmosni/mediasync-client/pkg/fakeserver"
+	"github.com/ainmosni/mediasync-client/pkg/mapping"
+	"github.com/ainmosni/mediasync-client/pkg/progress"
+)
+
+// simulate runs the engine against a fake server with the mappings of the configuration, downloading into a
+// temporary directory, so a configuration can be tried without touching the real remote or local paths.
+func simulate(args []string, logger *log.Logger) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	files := fs.Int("files", 3, "number of files per mapping")
+	size := fs.Int("size", 1<<20, "size of every file in bytes")
+	latency := fs.Duration("latency", 0, "latency added to every request")
+	failureRate := fs.Float64("failure-rate", 0, "chance between 0 and 1 that a request fails")
+	keep := fs.Bool("keep", false, "keep the downloaded files")
+	withProgress := fs.Bool("progress", false, "show the progress of downloads on stderr")
+	_ = fs.Parse(args)
+
+	c, err := config.GetConfig()
+	if err != nil {
+		logger.Printf("Can't get configuration: %s", err)
+		return exitConfig
+	}
+	if err := mapping.Validate(c.RootMapping); err != nil {
+		logger.Printf("Invalid root mapping: %s", err)
+		return exitConfig
+	}
+
+	dir, err := ioutil.TempDir("", "mediasync-simulate")
+	if err != nil {
+		logger.Println(err)
+		return exitFailure
+	}
+	if *keep {
+		logger.Printf("keeping downloaded files in %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	srv := fakeserver.New(fakeserver.Options{
+		Username:    c.UserName,
+		Password:    c.Password,
+		Latency:     *latency,
+		FailureRate: *failureRate,
+	})
+	defer srv.Close()
+
+	sim := *c
+	sim.Remote = srv.URL
+	sim.Journal = ""
+	sim.ResultFile = ""
+	sim.StagingDir = ""
+	sim.QueueState = ""
+	// The fake server is set up with the basic auth credentials, whatever the remote uses.
+	sim.Auth = config.AuthConfig{}
+	sim.MirrorState = filepath.Join(dir, "mirrored.json")
+	sim.RootMapping = make([]config.FilePath, 0, len(c.RootMapping))
+	for _, m := range c.RootMapping {
+		m.LocalPath = filepath.Join(dir, m.LocalPath)
+		sim.RootMapping = append(sim.RootMapping, m)
+		for i := 0; i < *files; i++ {
+			srv.Add(fakeserver.File{
+				WebPath: path.Join(m.RemotePath, fmt.Sprintf("simulated-%d.bin", i)),
+				Content: make([]byte, *size),
+			})
+		}
+	}
+
+	e := engine.New(&sim)
+	if *withProgress {
+		e.Subscribe(progress.New(os.Stderr).Handle)
+	}
+	ctx, cancel := signalContext(logger)
+	defer cancel()
+	res, err := e.Run(ctx)
+	if err != nil {
+		logger.Println(err)
+	}
+	printResult(res)
+	return resultCode(res)
+}
+
+func printResult(res *engine.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OUTCOME\tFILE\tLOCAL\tREASON")
+	for _, f := range res.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Outcome, f.File, f.Local, f.Reason)
+	}
+	_ = w.Flush()
+	fmt.Printf("\n%d files, %s in %s, avg %s/s\n", len(res.Files), config.ByteSize(res.Bytes()), res.Duration(),
+		config.ByteSize(res.Rate()))
+	if res.Err != nil {
+		fmt.Printf("run failed: %v\n", res.Err)
+	}
+}